@@ -0,0 +1,765 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/jsonmergepatch"
+	"k8s.io/apimachinery/pkg/util/mergepatch"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+var (
+	// Kinds that are pruned additionally to the applied kinds (kubectl's default prune whitelist)
+	pruneKinds = []schema.GroupVersionKind{
+		{Version: "v1", Kind: "ConfigMap"},
+		{Version: "v1", Kind: "Endpoints"},
+		{Version: "v1", Kind: "Namespace"},
+		{Version: "v1", Kind: "PersistentVolumeClaim"},
+		{Version: "v1", Kind: "PersistentVolume"},
+		{Version: "v1", Kind: "Pod"},
+		{Version: "v1", Kind: "ReplicationController"},
+		{Version: "v1", Kind: "Secret"},
+		{Version: "v1", Kind: "Service"},
+		{Group: "batch", Version: "v1", Kind: "Job"},
+		{Group: "batch", Version: "v1beta1", Kind: "CronJob"},
+		{Group: "extensions", Version: "v1beta1", Kind: "Ingress"},
+		{Group: "apps", Version: "v1", Kind: "DaemonSet"},
+		{Group: "apps", Version: "v1", Kind: "Deployment"},
+		{Group: "apps", Version: "v1", Kind: "ReplicaSet"},
+		{Group: "apps", Version: "v1", Kind: "StatefulSet"},
+	}
+	deletePropagation = metav1.DeletePropagationBackground
+)
+
+// apiClient implements K8sClient using the Kubernetes API directly
+type apiClient struct {
+	config Config
+	cache  *discoveryCache
+	once   sync.Once
+	api    *apiConn
+	err    error
+}
+
+type apiConn struct {
+	dynamic   dynamic.Interface
+	discovery discovery.DiscoveryInterface
+	mapper    meta.RESTMapper
+	core      corev1client.CoreV1Interface
+	namespace string
+	host      string
+	context   string
+	log       logrus.FieldLogger
+}
+
+// NewAPIClient creates a K8sClient that talks to the API server directly
+// instead of calling kubectl. The connection is established lazily.
+func NewAPIClient(config Config) K8sClient {
+	return withRetry(&apiClient{config: config, cache: newDiscoveryCache(config.logger())}, config.Retry, config.logger())
+}
+
+func (c *apiClient) conn() (*apiConn, error) {
+	c.once.Do(func() {
+		if c.api == nil {
+			c.api, c.err = connect(&c.config)
+		}
+	})
+	return c.api, c.err
+}
+
+func connect(cfg *Config) (c *apiConn, err error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = cfg.KubeconfigFile
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: cfg.Context,
+		Context: clientcmdapi.Context{
+			Cluster:  cfg.Cluster,
+			AuthInfo: cfg.User,
+		},
+		AuthInfo: clientcmdapi.AuthInfo{
+			Impersonate:       cfg.Impersonate,
+			ImpersonateGroups: cfg.ImpersonateGroups,
+		},
+	}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "load kubeconfig")
+	}
+	c = &apiConn{host: config.Host, context: cfg.Context, log: cfg.logger()}
+	if c.context == "" {
+		rawConfig, err := clientConfig.RawConfig()
+		if err != nil {
+			return nil, errors.Wrap(err, "load kubeconfig")
+		}
+		c.context = rawConfig.CurrentContext
+	}
+	if c.namespace, _, err = clientConfig.Namespace(); err != nil {
+		return nil, errors.Wrap(err, "load kubeconfig")
+	}
+	if c.dynamic, err = dynamic.NewForConfig(config); err != nil {
+		return
+	}
+	if c.core, err = corev1client.NewForConfig(config); err != nil {
+		return
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return
+	}
+	cached := memory.NewMemCacheClient(discoveryClient)
+	c.discovery = cached
+	c.mapper = restmapper.NewShortcutExpander(restmapper.NewDeferredDiscoveryRESTMapper(cached), cached)
+	return
+}
+
+func (c *apiClient) Apply(ctx context.Context, namespace string, resources resource.K8sResourceList, opts ApplyOptions) (results ApplyResults, err error) {
+	api, err := c.conn()
+	if err != nil {
+		return
+	}
+	selector, err := labels.Parse(strings.Join(opts.Labels, ","))
+	if err != nil {
+		return
+	}
+	if opts.Prune && len(opts.Labels) == 0 {
+		return nil, errors.New("apply: prune requires a label selector")
+	}
+	visited := map[types.UID]bool{}
+	visitedNamespaces := map[string]bool{}
+	var conflicts []*FieldConflict
+	conflicting := 0
+	for _, res := range resources {
+		if !selector.Matches(labels.Set(res.Labels())) {
+			continue
+		}
+		if e := ctx.Err(); e != nil {
+			return results, errors.WithStack(e)
+		}
+		applied, action, e := api.apply(namespace, res, opts)
+		if e != nil {
+			if c := conflictsFromStatus(res, e); len(c) > 0 {
+				conflicts = append(conflicts, c...)
+				conflicting++
+				e = &ConflictError{c}
+			}
+			results = append(results, &ApplyResult{Resource: res, Action: ActionFailed, Error: e})
+			continue
+		}
+		visited[applied.GetUID()] = true
+		visitedNamespaces[applied.GetNamespace()] = true
+		results = append(results, &ApplyResult{Resource: resource.FromMap(applied.Object), Action: action})
+	}
+	if conflicting > 0 && conflicting == len(results.Failed()) {
+		return results, &ConflictError{conflicts}
+	}
+	if err = applyResultsError(results); err == nil && opts.Prune {
+		var pruned resource.K8sResourceRefList
+		pruned, err = api.prune(ctx, resources, selector, visited, visitedNamespaces, opts.DryRun)
+		if err == nil && opts.DryRun == DryRunNone {
+			err = c.AwaitDeletion(ctx, namespace, pruned)
+		}
+	}
+	return
+}
+
+func (c *apiConn) apply(namespace string, res *resource.K8sResource, opts ApplyOptions) (*unstructured.Unstructured, ApplyAction, error) {
+	obj, err := toUnstructured(res)
+	if err != nil {
+		return nil, ActionFailed, err
+	}
+	gvk := obj.GroupVersionKind()
+	m, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, ActionFailed, err
+	}
+	if m.Scope.Name() != meta.RESTScopeNameNamespace {
+		obj.SetNamespace("")
+	} else if obj.GetNamespace() == "" {
+		if namespace == "" {
+			namespace = c.namespace
+		}
+		obj.SetNamespace(namespace)
+	}
+	ri := c.resource(m, obj.GetNamespace())
+	current, err := ri.Get(obj.GetName(), metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, ActionFailed, err
+	}
+	exists := err == nil
+	if opts.ServerSide {
+		action := ActionCreated
+		if exists {
+			action = ActionConfigured
+		}
+		if opts.DryRun == DryRunClient {
+			return obj, action, nil
+		}
+		applied, err := c.serverSideApply(ri, obj, opts.ForceConflicts, opts.DryRun)
+		if err != nil {
+			return nil, ActionFailed, err
+		}
+		if exists && applied.GetResourceVersion() == current.GetResourceVersion() {
+			action = ActionUnchanged
+		}
+		return applied, action, nil
+	}
+	modified, err := setLastAppliedConfig(obj)
+	if err != nil {
+		return nil, ActionFailed, err
+	}
+	if !exists {
+		if opts.DryRun == DryRunClient {
+			return obj, ActionCreated, nil
+		}
+		c.log.Debugf("Creating %s", res.ID())
+		created, err := ri.Create(obj, metav1.CreateOptions{DryRun: dryRunOption(opts.DryRun)})
+		if err != nil {
+			return nil, ActionFailed, err
+		}
+		return created, ActionCreated, nil
+	}
+	currentJSON, err := current.MarshalJSON()
+	if err != nil {
+		return nil, ActionFailed, err
+	}
+	original := []byte(current.GetAnnotations()[corev1.LastAppliedConfigAnnotation])
+	patch, patchType, err := threeWayPatch(gvk, original, modified, currentJSON)
+	if err != nil {
+		return nil, ActionFailed, errors.Wrap(err, "create patch")
+	}
+	if string(patch) == "{}" {
+		return current, ActionUnchanged, nil
+	}
+	if opts.DryRun == DryRunClient {
+		return obj, ActionConfigured, nil
+	}
+	if res.IsSecret() {
+		c.log.Debugf("Patching %s", res.ID())
+	} else {
+		c.log.Debugf("Patching %s: %s", res.ID(), patch)
+	}
+	patched, err := ri.Patch(obj.GetName(), patchType, patch, metav1.PatchOptions{DryRun: dryRunOption(opts.DryRun)})
+	if err != nil {
+		return nil, ActionFailed, err
+	}
+	return patched, ActionConfigured, nil
+}
+
+// serverSideApply sends the object as apply patch owned by the k8spkg field manager
+func (c *apiConn) serverSideApply(ri dynamic.ResourceInterface, obj *unstructured.Unstructured, force bool, dryRun DryRun) (*unstructured.Unstructured, error) {
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	c.log.Debugf("Applying %s/%s server-side", strings.ToLower(obj.GetKind()), obj.GetName())
+	return ri.Patch(obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: FieldManager,
+		Force:        &force,
+		DryRun:       dryRunOption(dryRun),
+	})
+}
+
+// dryRunOption returns the API request's dry run option
+func dryRunOption(dryRun DryRun) []string {
+	if dryRun == DryRunServer {
+		return []string{metav1.DryRunAll}
+	}
+	return nil
+}
+
+// setLastAppliedConfig sets the last-applied-configuration annotation the same way kubectl does
+func setLastAppliedConfig(obj *unstructured.Unstructured) (modified []byte, err error) {
+	annotations := obj.GetAnnotations()
+	delete(annotations, corev1.LastAppliedConfigAnnotation)
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	obj.SetAnnotations(annotations)
+	original, err := obj.MarshalJSON()
+	if err != nil {
+		return
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[corev1.LastAppliedConfigAnnotation] = string(original)
+	obj.SetAnnotations(annotations)
+	return obj.MarshalJSON()
+}
+
+// threeWayPatch creates a strategic merge patch for built-in types and a JSON merge patch for all other types
+func threeWayPatch(gvk schema.GroupVersionKind, original, modified, current []byte) ([]byte, types.PatchType, error) {
+	versioned, err := scheme.Scheme.New(gvk)
+	if runtime.IsNotRegisteredError(err) {
+		preconditions := []mergepatch.PreconditionFunc{
+			mergepatch.RequireKeyUnchanged("apiVersion"),
+			mergepatch.RequireKeyUnchanged("kind"),
+			mergepatch.RequireMetadataKeyUnchanged("name"),
+		}
+		patch, err := jsonmergepatch.CreateThreeWayJSONMergePatch(original, modified, current, preconditions...)
+		return patch, types.MergePatchType, err
+	} else if err != nil {
+		return nil, "", err
+	}
+	patchMeta, err := strategicpatch.NewPatchMetaFromStruct(versioned)
+	if err != nil {
+		return nil, "", err
+	}
+	patch, err := strategicpatch.CreateThreeWayMergePatch(original, modified, current, patchMeta, false)
+	return patch, types.StrategicMergePatchType, err
+}
+
+// prune deletes all objects matching the selector that have been applied previously but are not contained in the visited set
+func (c *apiConn) prune(ctx context.Context, applied resource.K8sResourceList, selector labels.Selector, visited map[types.UID]bool, namespaces map[string]bool, dryRun DryRun) (pruned resource.K8sResourceRefList, err error) {
+	kinds := append([]schema.GroupVersionKind{}, pruneKinds...)
+	for _, res := range applied {
+		kinds = append(kinds, schema.FromAPIVersionAndKind(res.APIVersion(), res.Kind()))
+	}
+	seen := map[schema.GroupVersionResource]bool{}
+	for _, gvk := range kinds {
+		m, e := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if e != nil {
+			if meta.IsNoMatchError(e) {
+				continue
+			}
+			return pruned, e
+		}
+		if seen[m.Resource] {
+			continue
+		}
+		seen[m.Resource] = true
+		scopes := []string{""}
+		if m.Scope.Name() == meta.RESTScopeNameNamespace {
+			scopes = scopes[:0]
+			for ns := range namespaces {
+				scopes = append(scopes, ns)
+			}
+		}
+		for _, ns := range scopes {
+			ri := c.resource(m, ns)
+			l, e := ri.List(metav1.ListOptions{LabelSelector: selector.String()})
+			if e != nil {
+				return pruned, errors.Wrapf(e, "prune: list %s", m.Resource.String())
+			}
+			for _, o := range l.Items {
+				if visited[o.GetUID()] || !isApplied(&o) {
+					continue
+				}
+				if e = ctx.Err(); e != nil {
+					return pruned, errors.WithStack(e)
+				}
+				ref := resource.ResourceRef(o.GetAPIVersion(), o.GetKind(), o.GetNamespace(), o.GetName())
+				if e = c.deleteObject(ri, ref, dryRun); e != nil {
+					return pruned, errors.Wrapf(e, "prune %s", ref.ID())
+				}
+				pruned = append(pruned, ref)
+			}
+		}
+	}
+	return
+}
+
+// isApplied returns true if the object has been applied client-side or server-side by k8spkg
+func isApplied(o *unstructured.Unstructured) bool {
+	if o.GetAnnotations()[corev1.LastAppliedConfigAnnotation] != "" {
+		return true
+	}
+	for _, f := range o.GetManagedFields() {
+		if f.Manager == FieldManager && f.Operation == metav1.ManagedFieldsOperationApply {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *apiClient) Delete(ctx context.Context, namespace string, resources resource.K8sResourceRefList, opts DeleteOptions) (err error) {
+	api, err := c.conn()
+	if err != nil {
+		return
+	}
+	for _, ref := range resources {
+		if e := ctx.Err(); e != nil {
+			return errors.WithStack(e)
+		}
+		ri, e := api.resourceForRef(ref, namespace)
+		if e == nil {
+			e = api.deleteObject(ri, ref, opts.DryRun)
+		}
+		if e != nil && err == nil {
+			err = errors.Wrapf(e, "delete %s", ref.ID())
+		}
+	}
+	if err == nil && opts.DryRun == DryRunNone {
+		err = c.AwaitDeletion(ctx, namespace, resources)
+	}
+	return
+}
+
+// deleteObject deletes the referenced object, ignoring it if it does not exist
+func (c *apiConn) deleteObject(ri dynamic.ResourceInterface, ref resource.K8sResourceRef, dryRun DryRun) (err error) {
+	if dryRun == DryRunClient {
+		return
+	}
+	c.log.Debugf("Deleting %s", ref.ID())
+	err = ri.Delete(ref.Name(), &metav1.DeleteOptions{
+		PropagationPolicy: &deletePropagation,
+		DryRun:            dryRunOption(dryRun),
+	})
+	if apierrors.IsNotFound(err) {
+		err = nil
+	}
+	return
+}
+
+func (c *apiClient) AwaitDeletion(ctx context.Context, namespace string, resources resource.K8sResourceRefList) (err error) {
+	api, err := c.conn()
+	if err != nil {
+		return
+	}
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	for _, ref := range resources {
+		ri, e := api.resourceForRef(ref, namespace)
+		if e != nil {
+			if meta.IsNoMatchError(e) {
+				continue // type does not exist (anymore)
+			}
+			return e
+		}
+		if e = awaitDeletion(ctx, ri, ref.Name()); e != nil {
+			return errors.Wrapf(e, "await deletion of %s", ref.ID())
+		}
+	}
+	return
+}
+
+func awaitDeletion(ctx context.Context, ri dynamic.ResourceInterface, name string) error {
+	for {
+		o, err := ri.Get(name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		w, err := ri.Watch(metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", name).String(),
+			ResourceVersion: o.GetResourceVersion(),
+		})
+		if err != nil {
+			return err
+		}
+		deleted, err := awaitDeletedEvent(ctx, w, name)
+		if deleted || err != nil {
+			return err
+		}
+	}
+}
+
+func awaitDeletedEvent(ctx context.Context, w watch.Interface, name string) (bool, error) {
+	defer w.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false, errors.WithStack(ctx.Err())
+		case evt, ok := <-w.ResultChan():
+			if !ok {
+				return false, nil
+			}
+			switch evt.Type {
+			case watch.Deleted:
+				if o, e := meta.Accessor(evt.Object); e == nil && o.GetName() == name {
+					return true, nil
+				}
+			case watch.Error:
+				if err := apierrors.FromObject(evt.Object); !apierrors.IsGone(err) {
+					return false, err
+				}
+				return false, nil
+			}
+		}
+	}
+}
+
+func (c *apiClient) GetResource(ctx context.Context, kind string, namespace string, name string) (r *resource.K8sResource, err error) {
+	api, err := c.conn()
+	if err != nil {
+		return
+	}
+	m, err := api.mappingForType(kind)
+	if err != nil {
+		return
+	}
+	o, err := api.resource(m, namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			err = notFoundError{errors.Errorf("resource %s:%s/%s not found", namespace, kind, name)}
+		}
+		return nil, err
+	}
+	return resource.FromMap(o.Object), nil
+}
+
+func (c *apiClient) Get(ctx context.Context, kinds []string, namespace string, labels []string) <-chan resource.ResourceEvent {
+	ch := make(chan resource.ResourceEvent)
+	go func() {
+		defer close(ch)
+		err := c.list(ctx, kinds, namespace, labels, ch)
+		if err != nil {
+			emit(ctx, ch, resource.ResourceEvent{Error: errors.Wrap(err, "get")})
+		}
+	}()
+	return ch
+}
+
+func (c *apiClient) list(ctx context.Context, kinds []string, namespace string, labels []string, ch chan<- resource.ResourceEvent) (err error) {
+	api, err := c.conn()
+	if err != nil {
+		return
+	}
+	for _, kind := range kinds {
+		m, err := api.mappingForType(kind)
+		if err != nil {
+			return err
+		}
+		l, err := api.resource(m, namespace).List(metav1.ListOptions{LabelSelector: strings.Join(labels, ",")})
+		if err != nil {
+			return err
+		}
+		for _, o := range l.Items {
+			emit(ctx, ch, resource.ResourceEvent{Resource: resource.FromMap(o.Object)})
+		}
+		if err = ctx.Err(); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return
+}
+
+func (c *apiClient) Watch(ctx context.Context, kind, namespace string, labels []string, watchOnly bool) <-chan resource.ResourceEvent {
+	ch := make(chan resource.ResourceEvent)
+	go func() {
+		defer close(ch)
+		if err := c.watch(ctx, kind, namespace, labels, watchOnly, ch); err != nil {
+			emit(ctx, ch, resource.ResourceEvent{Error: errors.Wrap(err, "watch")})
+		}
+	}()
+	return ch
+}
+
+func (c *apiClient) watch(ctx context.Context, kind, namespace string, labels []string, watchOnly bool, ch chan<- resource.ResourceEvent) (err error) {
+	api, err := c.conn()
+	if err != nil {
+		return
+	}
+	m, err := api.mappingForType(kind)
+	if err != nil {
+		return
+	}
+	ri := api.resource(m, namespace)
+	selector := strings.Join(labels, ",")
+	seen := resourceVersions{}
+	resourceVersion := ""
+	relist := false
+	list := func(emitEvents bool) error {
+		l, err := ri.List(metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return err
+		}
+		for _, o := range l.Items {
+			if res := resource.FromMap(o.Object); seen.add(res) && emitEvents {
+				emit(ctx, ch, resource.ResourceEvent{Resource: res})
+			}
+		}
+		resourceVersion = l.GetResourceVersion()
+		return nil
+	}
+	if err = list(!watchOnly); err != nil {
+		return
+	}
+	return resumeWatch(ctx, c.config.logger(), func() (progress bool, err error) {
+		if relist {
+			// re-list since the last seen version is not available anymore
+			if err = list(true); err != nil {
+				return
+			}
+			relist = false
+			progress = true
+		}
+		w, err := ri.Watch(metav1.ListOptions{
+			LabelSelector:       selector,
+			ResourceVersion:     resourceVersion,
+			AllowWatchBookmarks: true,
+		})
+		if err != nil {
+			if isExpired(err) {
+				relist = true
+				return true, nil
+			}
+			return
+		}
+		defer w.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case evt, ok := <-w.ResultChan():
+				if !ok {
+					return
+				}
+				if evt.Type == watch.Error {
+					if err = apierrors.FromObject(evt.Object); isExpired(err) {
+						relist = true
+						return true, nil
+					}
+					return
+				}
+				o, ok := evt.Object.(*unstructured.Unstructured)
+				if !ok {
+					continue
+				}
+				progress = true
+				resourceVersion = o.GetResourceVersion()
+				if evt.Type == watch.Bookmark {
+					continue
+				}
+				if res := resource.FromMap(o.Object); seen.add(res) {
+					emit(ctx, ch, resource.ResourceEvent{Resource: res})
+				}
+			}
+		}
+	})
+}
+
+// isExpired returns true if the error indicates that a watch's resourceVersion is too old ("410 Gone")
+func isExpired(err error) bool {
+	return apierrors.IsGone(err) || apierrors.IsResourceExpired(err)
+}
+
+func (c *apiClient) CurrentContext(ctx context.Context) (string, error) {
+	api, err := c.conn()
+	if err != nil {
+		return "", err
+	}
+	return api.context, nil
+}
+
+func (c *apiClient) ResourceTypes(ctx context.Context) (types []*APIResourceType, err error) {
+	api, err := c.conn()
+	if err != nil {
+		return
+	}
+	return c.cache.resourceTypes(api.host, func() ([]*APIResourceType, error) {
+		return discoverAPIResourceTypes(api.discovery, api.log)
+	})
+}
+
+func (c *apiClient) ContainerLogs(ctx context.Context, namespace, podName, containerName string, previous, follow bool, writer io.Writer) (err error) {
+	api, err := c.conn()
+	if err != nil {
+		return
+	}
+	if namespace == "" {
+		namespace = api.namespace
+	}
+	opts := &corev1.PodLogOptions{
+		Container: containerName,
+		Previous:  previous,
+		Follow:    follow && !previous,
+	}
+	stream, err := api.core.Pods(namespace).GetLogs(podName, opts).Context(ctx).Stream()
+	if err != nil {
+		return errors.Wrapf(err, "logs %s/%s", podName, containerName)
+	}
+	defer stream.Close()
+	_, err = io.Copy(writer, stream)
+	if e := ctx.Err(); e != nil {
+		err = e
+	}
+	return errors.Wrapf(err, "logs %s/%s", podName, containerName)
+}
+
+// mappingForType resolves a kubectl type argument like "Deployment", "deployment.apps" or "deployments.v1.apps"
+func (c *apiConn) mappingForType(typeArg string) (m *meta.RESTMapping, err error) {
+	fullySpecified, gr := schema.ParseResourceArg(strings.ToLower(typeArg))
+	gvr := gr.WithVersion("")
+	if fullySpecified != nil {
+		if _, e := c.mapper.KindFor(*fullySpecified); e == nil {
+			gvr = *fullySpecified
+		}
+	}
+	gvr, err = c.mapper.ResourceFor(gvr)
+	if err != nil {
+		return
+	}
+	gvk, err := c.mapper.KindFor(gvr)
+	if err != nil {
+		return
+	}
+	return c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+}
+
+func (c *apiConn) resourceForRef(ref resource.K8sResourceRef, namespace string) (ri dynamic.ResourceInterface, err error) {
+	gvk := schema.FromAPIVersionAndKind(ref.APIVersion(), ref.Kind())
+	m, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return
+	}
+	if ref.Namespace() != "" {
+		namespace = ref.Namespace()
+	}
+	return c.resource(m, namespace), nil
+}
+
+func (c *apiConn) resource(m *meta.RESTMapping, namespace string) dynamic.ResourceInterface {
+	ri := c.dynamic.Resource(m.Resource)
+	if m.Scope.Name() == meta.RESTScopeNameNamespace {
+		if namespace == "" {
+			namespace = c.namespace
+		}
+		return ri.Namespace(namespace)
+	}
+	return ri
+}
+
+func toUnstructured(res *resource.K8sResource) (o *unstructured.Unstructured, err error) {
+	b, err := json.Marshal(res.Raw())
+	if err != nil {
+		return
+	}
+	o = &unstructured.Unstructured{}
+	err = o.UnmarshalJSON(b)
+	return
+}
+
+func withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, defaultTimeout)
+}
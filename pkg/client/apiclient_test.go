@@ -0,0 +1,313 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var (
+	testConfigMapGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	testAppGVR       = schema.GroupVersionResource{Group: "k8spkg.mgoltzsche.github.com", Version: "v1alpha1", Resource: "applications"}
+)
+
+func newTestAPIClient(obj ...runtime.Object) (*apiClient, *fakedynamic.FakeDynamicClient) {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	mapper.Add(schema.GroupVersionKind{Group: "k8spkg.mgoltzsche.github.com", Version: "v1alpha1", Kind: "Application"}, meta.RESTScopeNamespace)
+	dynamicClient := fakedynamic.NewSimpleDynamicClient(runtime.NewScheme(), obj...)
+	discoveryClient := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{}}
+	discoveryClient.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "configmaps", ShortNames: []string{"cm"}, Kind: "ConfigMap", Namespaced: true, Verbs: []string{"get", "delete"}},
+				{Name: "namespaces", ShortNames: []string{"ns"}, Kind: "Namespace", Verbs: []string{"get", "delete"}},
+				{Name: "bindings", Kind: "Binding", Namespaced: true, Verbs: []string{"create"}},
+			},
+		},
+		{
+			GroupVersion: "k8spkg.mgoltzsche.github.com/v1alpha1",
+			APIResources: []metav1.APIResource{
+				{Name: "applications", Kind: "Application", Namespaced: true, Verbs: []string{"get", "delete"}},
+			},
+		},
+	}
+	c := &apiClient{api: &apiConn{
+		dynamic:   dynamicClient,
+		discovery: discoveryClient,
+		mapper:    mapper,
+		namespace: "default",
+		log:       logrus.StandardLogger(),
+	}}
+	return c, dynamicClient
+}
+
+func testObject(apiVersion, kind, namespace, name string, labels map[string]string) *unstructured.Unstructured {
+	o := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	o.SetAPIVersion(apiVersion)
+	o.SetKind(kind)
+	o.SetNamespace(namespace)
+	o.SetName(name)
+	o.SetLabels(labels)
+	return o
+}
+
+func TestAPIClientApply(t *testing.T) {
+	pkgLabel := map[string]string{"pkg": "mypkg"}
+	stale := testObject("v1", "ConfigMap", "default", "stale", pkgLabel)
+	stale.SetUID("stale-uid")
+	stale.SetAnnotations(map[string]string{corev1.LastAppliedConfigAnnotation: "{}"})
+	unmanaged := testObject("v1", "ConfigMap", "default", "unmanaged", pkgLabel)
+	unmanaged.SetUID("unmanaged-uid")
+	existingApp := testObject("k8spkg.mgoltzsche.github.com/v1alpha1", "Application", "default", "myapp", pkgLabel)
+	existingApp.Object["spec"] = map[string]interface{}{"resources": []interface{}{}, "obsolete": "x"}
+	existingApp.SetAnnotations(map[string]string{corev1.LastAppliedConfigAnnotation: `{"spec":{"obsolete":"x"}}`})
+	testee, fake := newTestAPIClient(stale, unmanaged, existingApp)
+
+	cm := testObject("v1", "ConfigMap", "", "mycm", pkgLabel)
+	cm.Object["data"] = map[string]interface{}{"key": "value"}
+	app := testObject("k8spkg.mgoltzsche.github.com/v1alpha1", "Application", "default", "myapp", pkgLabel)
+	app.Object["spec"] = map[string]interface{}{"resources": []interface{}{}}
+	unlabeled := testObject("v1", "ConfigMap", "", "unlabeled", nil)
+	input := resource.K8sResourceList{resource.FromMap(cm.Object), resource.FromMap(app.Object), resource.FromMap(unlabeled.Object)}
+
+	applied, err := testee.Apply(context.Background(), "myns", input, ApplyOptions{Prune: true, Labels: []string{"pkg=mypkg"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"configmap/mycm", "application.k8spkg.mgoltzsche.github.com/myapp"}, applied.Resources().Refs().Names(), "applied")
+	require.Equal(t, "myns", applied[0].Resource.Namespace(), "namespace of created object")
+	require.Equal(t, "default", applied[1].Resource.Namespace(), "namespace of updated object")
+	require.Equal(t, ActionCreated, applied[0].Action, "action of created object")
+	require.Equal(t, ActionConfigured, applied[1].Action, "action of updated object")
+
+	applied, err = testee.Apply(context.Background(), "myns", input, ApplyOptions{Labels: []string{"pkg=mypkg"}})
+	require.NoError(t, err)
+	require.Equal(t, ActionUnchanged, applied[0].Action, "action of unchanged object")
+
+	created, err := fake.Resource(testConfigMapGVR).Namespace("myns").Get("mycm", metav1.GetOptions{})
+	require.NoError(t, err, "get created object")
+	require.NotEmpty(t, created.GetAnnotations()[corev1.LastAppliedConfigAnnotation], "last-applied-configuration annotation")
+	updated, err := fake.Resource(testAppGVR).Namespace("default").Get("myapp", metav1.GetOptions{})
+	require.NoError(t, err, "get updated object")
+	_, found, _ := unstructured.NestedString(updated.Object, "spec", "obsolete")
+	require.False(t, found, "field removed from input should have been removed from object")
+	_, err = fake.Resource(testConfigMapGVR).Namespace("default").Get("stale", metav1.GetOptions{})
+	require.True(t, IsNotFound(err), "stale object should have been pruned")
+	_, err = fake.Resource(testConfigMapGVR).Namespace("default").Get("unmanaged", metav1.GetOptions{})
+	require.NoError(t, err, "object without last-applied-configuration should not be pruned")
+
+	_, err = testee.Apply(context.Background(), "", input, ApplyOptions{Prune: true})
+	require.Error(t, err, "prune without label selector")
+}
+
+func TestAPIClientDryRun(t *testing.T) {
+	pkgLabel := map[string]string{"pkg": "mypkg"}
+	stale := testObject("v1", "ConfigMap", "myns", "stale", pkgLabel)
+	stale.SetAnnotations(map[string]string{corev1.LastAppliedConfigAnnotation: "{}"})
+	testee, fake := newTestAPIClient(stale)
+	input := resource.K8sResourceList{resource.FromMap(testObject("v1", "ConfigMap", "", "mycm", pkgLabel).Object)}
+	applied, err := testee.Apply(context.Background(), "myns", input, ApplyOptions{Prune: true, Labels: []string{"pkg=mypkg"}, DryRun: DryRunClient})
+	require.NoError(t, err)
+	require.Equal(t, []string{"configmap/mycm"}, applied.Resources().Refs().Names(), "applied")
+	require.Equal(t, ActionCreated, applied[0].Action, "action")
+	_, err = fake.Resource(testConfigMapGVR).Namespace("myns").Get("mycm", metav1.GetOptions{})
+	require.True(t, IsNotFound(err), "dry run should not create object")
+	refs := resource.K8sResourceRefList{resource.ResourceRef("v1", "ConfigMap", "myns", "stale")}
+	err = testee.Delete(context.Background(), "", refs, DeleteOptions{DryRun: DryRunClient})
+	require.NoError(t, err)
+	_, err = fake.Resource(testConfigMapGVR).Namespace("myns").Get("stale", metav1.GetOptions{})
+	require.NoError(t, err, "dry run should neither prune nor delete object")
+}
+
+func TestAPIClientApplyServerSide(t *testing.T) {
+	testee, fake := newTestAPIClient()
+	fake.PrependReactor("patch", "configmaps", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patch := action.(clienttesting.PatchActionImpl)
+		require.Equal(t, types.ApplyPatchType, patch.GetPatchType(), "patch type")
+		if patch.GetName() == "conflicting" {
+			return true, nil, apierrors.NewApplyConflict([]metav1.StatusCause{
+				{Type: metav1.CauseTypeFieldManagerConflict, Message: `conflict with "hpa" using apps/v1`, Field: ".data.a"},
+				{Type: metav1.CauseTypeFieldManagerConflict, Message: `conflict with "hpa" using apps/v1`, Field: ".data.b"},
+				{Type: metav1.CauseTypeFieldManagerConflict, Message: `conflict with "operator"`, Field: ".data.c"},
+			}, "Apply failed with 3 conflicts")
+		}
+		obj := &unstructured.Unstructured{}
+		require.NoError(t, obj.UnmarshalJSON(patch.GetPatch()))
+		require.Empty(t, obj.GetAnnotations()[corev1.LastAppliedConfigAnnotation], "last-applied-configuration annotation")
+		return true, obj, nil
+	})
+	input := resource.K8sResourceList{
+		resource.FromMap(testObject("v1", "ConfigMap", "", "conflicting", nil).Object),
+		resource.FromMap(testObject("v1", "ConfigMap", "", "mycm", nil).Object),
+	}
+	applied, err := testee.Apply(context.Background(), "myns", input, ApplyOptions{ServerSide: true})
+	require.Error(t, err)
+	require.Equal(t, []string{"configmap/mycm"}, applied.Resources().Refs().Names(), "applied")
+	require.Equal(t, ActionFailed, applied[0].Action, "action of conflicting object")
+	require.True(t, IsConflict(applied[0].Error), "error of conflicting object should be a conflict")
+	conflicts := Conflicts(err)
+	require.Equal(t, 2, len(conflicts), "conflicts")
+	require.Equal(t, "configmap::conflicting", conflicts[0].Resource.ID(), "conflicting resource")
+	require.Equal(t, "hpa", conflicts[0].Manager, "conflicting manager")
+	require.Equal(t, []string{".data.a", ".data.b"}, conflicts[0].Fields, "conflicting fields")
+	require.Equal(t, "operator", conflicts[1].Manager, "conflicting manager")
+}
+
+func TestAPIClientGetResource(t *testing.T) {
+	testee, _ := newTestAPIClient(testObject("v1", "ConfigMap", "myns", "mycm", nil))
+	for _, kind := range []string{"ConfigMap", "configmap", "configmaps"} {
+		r, err := testee.GetResource(context.Background(), kind, "myns", "mycm")
+		require.NoError(t, err, kind)
+		require.Equal(t, "configmap:myns:mycm", r.ID())
+	}
+	_, err := testee.GetResource(context.Background(), "ConfigMap", "", "mycm")
+	require.True(t, IsNotFound(err), "IsNotFound(err) expected in default namespace but was %v", err)
+	_, err = testee.GetResource(context.Background(), "unknownkind", "myns", "mycm")
+	require.Error(t, err, "unknown kind")
+}
+
+func TestAPIClientGet(t *testing.T) {
+	testee, _ := newTestAPIClient(
+		testObject("v1", "ConfigMap", "myns", "cm1", map[string]string{"pkg": "a"}),
+		testObject("v1", "ConfigMap", "myns", "cm2", map[string]string{"pkg": "b"}),
+		testObject("k8spkg.mgoltzsche.github.com/v1alpha1", "Application", "myns", "app1", map[string]string{"pkg": "a"}),
+	)
+	var res resource.K8sResourceList
+	for evt := range testee.Get(context.Background(), []string{"configmap", "application.k8spkg.mgoltzsche.github.com"}, "myns", []string{"pkg=a"}) {
+		require.NoError(t, evt.Error)
+		res = append(res, evt.Resource)
+	}
+	require.Equal(t, []string{"configmap/cm1", "application.k8spkg.mgoltzsche.github.com/app1"}, res.Refs().Names())
+	var err error
+	for evt := range testee.Get(context.Background(), []string{"unknownkind"}, "myns", nil) {
+		err = evt.Error
+	}
+	require.Error(t, err, "unknown kind")
+}
+
+func TestAPIClientWatch(t *testing.T) {
+	testee, fake := newTestAPIClient(testObject("v1", "ConfigMap", "myns", "cm1", nil))
+	for _, watchOnly := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		ch := testee.Watch(ctx, "ConfigMap", "myns", nil, watchOnly)
+		if !watchOnly {
+			evt := <-ch
+			require.NoError(t, evt.Error)
+			require.Equal(t, "cm1", evt.Resource.Name(), "listed resource")
+		}
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			o := testObject("v1", "ConfigMap", "myns", "cm1", map[string]string{"changed": "true"})
+			_, err := fake.Resource(testConfigMapGVR).Namespace("myns").Update(o, metav1.UpdateOptions{})
+			require.NoError(t, err)
+		}()
+		select {
+		case evt := <-ch:
+			require.NoError(t, evt.Error)
+			require.Equal(t, "true", evt.Resource.Labels()["changed"], "watched resource")
+		case <-time.After(3 * time.Second):
+			t.Fatal("timed out waiting for watch event")
+		}
+		cancel()
+		for evt := range ch {
+			require.Error(t, evt.Error, "canceled watch")
+		}
+	}
+}
+
+func TestAPIClientWatchResume(t *testing.T) {
+	defer func(interval time.Duration) { watchRetryInterval = interval }(watchRetryInterval)
+	watchRetryInterval = time.Millisecond
+	obj := testObject("v1", "ConfigMap", "myns", "cm1", nil)
+	obj.SetResourceVersion("1")
+	testee, fake := newTestAPIClient(obj)
+	watchCalls := 0
+	fake.PrependWatchReactor("configmaps", func(action clienttesting.Action) (bool, watch.Interface, error) {
+		w := watch.NewFakeWithChanSize(2, false)
+		switch watchCalls++; watchCalls {
+		case 1:
+			// closed stream
+			w.Stop()
+		case 2:
+			// too old resourceVersion
+			status := apierrors.NewResourceExpired("too old resource version").Status()
+			w.Error(&status)
+		default:
+			changed := obj.DeepCopy()
+			changed.SetResourceVersion("2")
+			w.Modify(changed)
+		}
+		return true, w, nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := testee.Watch(ctx, "ConfigMap", "myns", nil, false)
+	var versions []string
+	for evt := range ch {
+		require.NoError(t, evt.Error)
+		versions = append(versions, evt.Resource.Raw()["metadata"].(map[string]interface{})["resourceVersion"].(string))
+		if len(versions) == 2 {
+			break
+		}
+	}
+	cancel()
+	for range ch {
+	}
+	require.Equal(t, []string{"1", "2"}, versions, "resource versions of emitted objects (re-listed object should be deduplicated)")
+	require.Equal(t, 3, watchCalls, "watch calls")
+	lists := 0
+	for _, a := range fake.Actions() {
+		if a.GetVerb() == "list" {
+			lists++
+		}
+	}
+	require.Equal(t, 2, lists, "list calls")
+}
+
+func TestAPIClientDelete(t *testing.T) {
+	testee, fake := newTestAPIClient(
+		testObject("v1", "ConfigMap", "myns", "cm1", nil),
+		testObject("v1", "Namespace", "", "myns", nil),
+	)
+	refs := resource.K8sResourceRefList{
+		resource.ResourceRef("v1", "ConfigMap", "myns", "cm1"),
+		resource.ResourceRef("v1", "ConfigMap", "", "missing"),
+		resource.ResourceRef("v1", "Namespace", "", "myns"),
+	}
+	err := testee.Delete(context.Background(), "", refs, DeleteOptions{})
+	require.NoError(t, err)
+	_, err = fake.Resource(testConfigMapGVR).Namespace("myns").Get("cm1", metav1.GetOptions{})
+	require.True(t, IsNotFound(err), "configmap should have been deleted")
+	err = testee.AwaitDeletion(context.Background(), "", refs)
+	require.NoError(t, err, "AwaitDeletion()")
+}
+
+func TestAPIClientResourceTypes(t *testing.T) {
+	testee, _ := newTestAPIClient()
+	types, err := testee.ResourceTypes(context.Background())
+	require.NoError(t, err)
+	expected := []*APIResourceType{
+		resType("bindings", nil, "", "Binding", true, "v1", "create"),
+		resType("configmaps", []string{"cm"}, "", "ConfigMap", true, "v1", "get", "delete"),
+		resType("namespaces", []string{"ns"}, "", "Namespace", false, "v1", "get", "delete"),
+		resType("applications", nil, "k8spkg.mgoltzsche.github.com", "Application", true, "v1alpha1", "get", "delete"),
+	}
+	require.Equal(t, expected, types)
+}
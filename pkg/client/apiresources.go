@@ -1,120 +1,217 @@
 package client
 
 import (
-	"bufio"
+	"bytes"
 	"context"
-	"io"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"regexp"
-	"strconv"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
 )
 
-var headerRegex = regexp.MustCompile(`[^\s]+($|\s+)`)
+const discoveryCacheTTL = 10 * time.Minute
+
+var cacheDirNameRegex = regexp.MustCompile(`[^a-zA-Z0-9.\-]`)
 
 func (c *k8sClient) ResourceTypes(ctx context.Context) (types []*APIResourceType, err error) {
-	reader, writer := io.Pipe()
-	go func() {
-		args := []string{"api-resources", "--verbs", "delete"}
-		e := kubectl(ctx, nil, writer, c.kubeconfigFile, args)
-		writer.CloseWithError(e)
-	}()
-	types, err = parseResourceTypeTable(reader)
-	reader.Close()
-	return
+	var server bytes.Buffer
+	args := []string{"config", "view", "--minify", "-o", "jsonpath={.clusters[0].cluster.server}"}
+	if e := kubectl(ctx, nil, &server, &c.config, args); e != nil {
+		c.config.logger().Debugf("resolve cluster for discovery cache: %s", e)
+		server.Reset()
+	}
+	return c.cache.resourceTypes(strings.TrimSpace(server.String()), func() ([]*APIResourceType, error) {
+		return discoverResourceTypes(c.config.logger(), func(path string, o interface{}) error {
+			var buf bytes.Buffer
+			if err := kubectl(ctx, nil, &buf, &c.config, []string{"get", "--raw", path}); err != nil {
+				return err
+			}
+			return errors.Wrapf(json.Unmarshal(buf.Bytes(), o), "decode %s", path)
+		})
+	})
 }
 
-func parseResourceTypeTable(reader io.Reader) (types []*APIResourceType, err error) {
-	lineReader := bufio.NewReader(reader)
-	colNames, readers, err := readTableHeader(lineReader)
-	if err != nil {
-		return
+// discoverResourceTypes reads the API resource types from the server's discovery endpoints using the provided getter
+func discoverResourceTypes(log logrus.FieldLogger, get func(path string, o interface{}) error) (types []*APIResourceType, err error) {
+	core := metav1.APIVersions{}
+	if err = get("/api", &core); err != nil {
+		return nil, errors.Wrap(err, "discover api resources")
 	}
-	var name, shortName, apiGroup, kind, namespaced func(line string) string
-	var colsFound int
-	for i, colName := range colNames {
-		switch colName {
-		case "name":
-			name = readers[i]
-			colsFound |= 1
-		case "shortnames":
-			shortName = readers[i]
-			colsFound |= 2
-		case "apigroup":
-			apiGroup = readers[i]
-			colsFound |= 4
-		case "kind":
-			kind = readers[i]
-			colsFound |= 8
-		case "namespaced":
-			namespaced = readers[i]
-			colsFound |= 16
-		}
+	groups := metav1.APIGroupList{}
+	if err = get("/apis", &groups); err != nil {
+		return nil, errors.Wrap(err, "discover api resources")
 	}
-	if colsFound != 31 {
-		return nil, errors.New("parse api-resources: missing NAME, SHORTNAMES, APIGROUP, KIND or NAMESPACED header column")
+	coreGroup := metav1.APIGroup{}
+	for _, v := range core.Versions {
+		coreGroup.Versions = append(coreGroup.Versions, metav1.GroupVersionForDiscovery{GroupVersion: v, Version: v})
 	}
-	var rtype *APIResourceType
-	var line string
-	for {
-		if line, err = lineReader.ReadString('\n'); err != nil {
-			if err == io.EOF {
-				err = nil
+	if len(coreGroup.Versions) > 0 {
+		coreGroup.PreferredVersion = coreGroup.Versions[0]
+	}
+	allGroups := append([]metav1.APIGroup{coreGroup}, groups.Groups...)
+	lists := map[string]*metav1.APIResourceList{}
+	for _, g := range allGroups {
+		for _, v := range g.Versions {
+			path := "/apis/" + v.GroupVersion
+			if g.Name == "" {
+				path = "/api/" + v.GroupVersion
 			}
-			return
+			l := &metav1.APIResourceList{}
+			if e := get(path, l); e != nil {
+				// tolerate unavailable aggregated APIs
+				log.Debugf("discover api resources: %s", e)
+				continue
+			}
+			lists[v.GroupVersion] = l
 		}
-		err = nil
-		rtype = &APIResourceType{
-			Name:     name(line),
-			APIGroup: apiGroup(line),
-			Kind:     kind(line),
+	}
+	return typesFromDiscovery(allGroups, lists), nil
+}
+
+// discoverAPIResourceTypes reads the API resource types using a discovery client
+func discoverAPIResourceTypes(d discovery.DiscoveryInterface, log logrus.FieldLogger) (types []*APIResourceType, err error) {
+	groups, err := d.ServerGroups()
+	if err != nil {
+		return nil, errors.Wrap(err, "discover api resources")
+	}
+	lists := map[string]*metav1.APIResourceList{}
+	for _, g := range groups.Groups {
+		for _, v := range g.Versions {
+			l, e := d.ServerResourcesForGroupVersion(v.GroupVersion)
+			if e != nil {
+				// tolerate unavailable aggregated APIs
+				log.Debugf("discover api resources: %s", e)
+				continue
+			}
+			lists[v.GroupVersion] = l
 		}
-		if rtype.Name == "" {
-			return nil, errors.Errorf("parse api-resources: empty NAME column")
+	}
+	return typesFromDiscovery(groups.Groups, lists), nil
+}
+
+// typesFromDiscovery merges the resources served in the groups' versions into
+// one APIResourceType per group and resource, preferring the group's preferred version.
+func typesFromDiscovery(groups []metav1.APIGroup, lists map[string]*metav1.APIResourceList) (types []*APIResourceType) {
+	for _, g := range groups {
+		versions := []metav1.GroupVersionForDiscovery{g.PreferredVersion}
+		for _, v := range g.Versions {
+			if v.GroupVersion != g.PreferredVersion.GroupVersion {
+				versions = append(versions, v)
+			}
 		}
-		if rtype.Kind == "" {
-			return nil, errors.Errorf("parse api-resources: empty KIND column")
+		byName := map[string]*APIResourceType{}
+		for _, v := range versions {
+			l := lists[v.GroupVersion]
+			if l == nil {
+				continue
+			}
+			for _, r := range l.APIResources {
+				if strings.Contains(r.Name, "/") {
+					continue // skip subresource
+				}
+				t := byName[r.Name]
+				if t == nil {
+					t = &APIResourceType{
+						Name:       r.Name,
+						ShortNames: r.ShortNames,
+						APIGroup:   g.Name,
+						Kind:       r.Kind,
+						Namespaced: r.Namespaced,
+						Version:    v.Version,
+						Verbs:      []string(r.Verbs),
+					}
+					byName[r.Name] = t
+					types = append(types, t)
+				}
+				t.Versions = append(t.Versions, v.Version)
+			}
 		}
-		shortNameCsv := shortName(line)
-		if shortNameCsv != "" {
-			rtype.ShortNames = strings.Split(shortNameCsv, ",")
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if types[i].APIGroup == types[j].APIGroup {
+			return types[i].Name < types[j].Name
 		}
-		if rtype.Namespaced, err = strconv.ParseBool(namespaced(line)); err != nil {
-			return nil, errors.Errorf("parse api-resources: namespaced column: %s", err)
+		return types[i].APIGroup < types[j].APIGroup
+	})
+	return
+}
+
+// discoveryCache stores the discovered API resource types per cluster on disk
+type discoveryCache struct {
+	dir string
+	ttl time.Duration
+	log logrus.FieldLogger
+}
+
+func newDiscoveryCache(log logrus.FieldLogger) *discoveryCache {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		log.Debugf("discovery cache disabled: %s", err)
+		return &discoveryCache{}
+	}
+	return &discoveryCache{filepath.Join(dir, "k8spkg", "discovery"), discoveryCacheTTL, log}
+}
+
+// resourceTypes returns the cluster's cached resource types or
+// discovers and caches them if they are not cached or expired
+func (c *discoveryCache) resourceTypes(cluster string, discover func() ([]*APIResourceType, error)) (types []*APIResourceType, err error) {
+	if c == nil || c.dir == "" || cluster == "" {
+		return discover()
+	}
+	file := filepath.Join(c.dir, cacheDirNameRegex.ReplaceAllString(cluster, "_"), "resourcetypes.json")
+	if fi, e := os.Stat(file); e == nil && time.Since(fi.ModTime()) < c.ttl {
+		if types, e = readResourceTypes(file); e == nil {
+			return
 		}
-		types = append(types, rtype)
+		c.log.Debugf("read discovery cache: %s", e)
+	}
+	if types, err = discover(); err != nil {
+		return
+	}
+	if e := writeResourceTypes(file, types); e != nil {
+		c.log.Debugf("write discovery cache: %s", e)
 	}
+	return
 }
 
-func readTableHeader(reader *bufio.Reader) (colNames []string, colReaders []func(string) string, err error) {
-	line, err := reader.ReadString('\n')
+func readResourceTypes(file string) (types []*APIResourceType, err error) {
+	b, err := ioutil.ReadFile(file)
 	if err != nil {
 		return
 	}
-	colNames = headerRegex.FindAllString(line, -1)
-	colReaders = make([]func(string) string, len(colNames))
-	var currPos int
-	for i, col := range colNames {
-		startPos := currPos
-		if i != len(colNames)-1 {
-			endPos := currPos + len(col)
-			colReaders[i] = func(line string) string {
-				if len(line) < endPos {
-					return ""
-				}
-				return strings.TrimSpace(line[startPos:endPos])
-			}
-		} else {
-			colReaders[i] = func(line string) string {
-				if len(line) < startPos {
-					return ""
-				}
-				return strings.TrimSpace(line[startPos:])
-			}
-		}
-		colNames[i] = strings.ToLower(strings.TrimSpace(col))
-		currPos += len(col)
+	err = json.Unmarshal(b, &types)
+	return types, errors.Wrapf(err, "decode %s", file)
+}
+
+func writeResourceTypes(file string, types []*APIResourceType) (err error) {
+	b, err := json.Marshal(types)
+	if err != nil {
+		return
+	}
+	dir := filepath.Dir(file)
+	if err = os.MkdirAll(dir, 0750); err != nil {
+		return
+	}
+	f, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	if e := f.Close(); e != nil && err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), file)
 	}
 	return
 }
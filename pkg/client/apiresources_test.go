@@ -1,53 +1,135 @@
 package client
 
 import (
-	"bytes"
 	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-var (
-	resTypeTable = `NAME                              SHORTNAMES   APIGROUP                       NAMESPACED   KIND
-configmaps                        cm                                          true         ConfigMap
-namespaces                        ns                                          false        Namespace
-pods                              po,shortname                                true         Pod
-podtemplates                                                                  true         PodTemplate
-deployments                       deploy       apps                           true         Deployment
-`
-	expectedResTypes = []*APIResourceType{
-		resType("configmaps", []string{"cm"}, "", "ConfigMap", true),
-		resType("namespaces", []string{"ns"}, "", "Namespace", false),
-		resType("pods", []string{"po", "shortname"}, "", "Pod", true),
-		resType("podtemplates", nil, "", "PodTemplate", true),
-		resType("deployments", []string{"deploy"}, "apps", "Deployment", true),
-	}
-)
+// Valid response for all discovery endpoints since the kubectl mock returns the same output for every call
+var discoveryResponse = `{
+	"versions": ["v1"],
+	"groups": [],
+	"groupVersion": "v1",
+	"resources": [
+		{"name": "pods", "shortNames": ["po"], "kind": "Pod", "namespaced": true, "verbs": ["get", "delete"]},
+		{"name": "pods/log", "kind": "Pod", "namespaced": true, "verbs": ["get"]},
+		{"name": "namespaces", "shortNames": ["ns"], "kind": "Namespace", "verbs": ["get", "delete"]}
+	]
+}`
 
 func TestResourceTypes(t *testing.T) {
-	expectedCalls := []string{"api-resources --verbs delete"}
-	assertKubectlCalls(t, expectedCalls, []byte(resTypeTable), func(c K8sClient) (err error) {
+	expectedCalls := []string{
+		"config view --minify -o jsonpath={.clusters[0].cluster.server}",
+		"get --raw /api",
+		"get --raw /apis",
+		"get --raw /api/v1",
+	}
+	expected := []*APIResourceType{
+		resType("namespaces", []string{"ns"}, "", "Namespace", false, "v1", "get", "delete"),
+		resType("pods", []string{"po"}, "", "Pod", true, "v1", "get", "delete"),
+	}
+	assertKubectlCalls(t, expectedCalls, []byte(discoveryResponse), func(c K8sClient) (err error) {
+		c.(*k8sClient).cache = nil
 		r, err := c.ResourceTypes(context.Background())
 		if err == nil {
-			require.Equal(t, expectedResTypes, r)
+			require.Equal(t, expected, r)
 		}
 		return
 	})
 }
 
-func TestParseResourceTypeTable(t *testing.T) {
-	types, err := parseResourceTypeTable(bytes.NewReader([]byte(resTypeTable)))
+func TestTypesFromDiscovery(t *testing.T) {
+	groups := []metav1.APIGroup{
+		{
+			Name: "apps",
+			Versions: []metav1.GroupVersionForDiscovery{
+				{GroupVersion: "apps/v1beta1", Version: "v1beta1"},
+				{GroupVersion: "apps/v1", Version: "v1"},
+			},
+			PreferredVersion: metav1.GroupVersionForDiscovery{GroupVersion: "apps/v1", Version: "v1"},
+		},
+		{
+			Name:             "unavailable.example.org",
+			Versions:         []metav1.GroupVersionForDiscovery{{GroupVersion: "unavailable.example.org/v1", Version: "v1"}},
+			PreferredVersion: metav1.GroupVersionForDiscovery{GroupVersion: "unavailable.example.org/v1", Version: "v1"},
+		},
+	}
+	lists := map[string]*metav1.APIResourceList{
+		"apps/v1": {APIResources: []metav1.APIResource{
+			{Name: "deployments", ShortNames: []string{"deploy"}, Kind: "Deployment", Namespaced: true, Verbs: []string{"get"}},
+			{Name: "deployments/scale", Kind: "Scale", Namespaced: true, Verbs: []string{"get"}},
+		}},
+		"apps/v1beta1": {APIResources: []metav1.APIResource{
+			{Name: "deployments", ShortNames: []string{"deploy"}, Kind: "Deployment", Namespaced: true, Verbs: []string{"get"}},
+			{Name: "controllerrevisions", Kind: "ControllerRevision", Namespaced: true, Verbs: []string{"list"}},
+		}},
+	}
+	deployments := resType("deployments", []string{"deploy"}, "apps", "Deployment", true, "v1", "get")
+	deployments.Versions = []string{"v1", "v1beta1"}
+	expected := []*APIResourceType{
+		resType("controllerrevisions", nil, "apps", "ControllerRevision", true, "v1beta1", "list"),
+		deployments,
+	}
+	require.Equal(t, expected, typesFromDiscovery(groups, lists))
+	require.Equal(t, "deployments.v1.apps", deployments.QualifiedName())
+	require.Equal(t, "apps/v1", deployments.APIVersion())
+	require.True(t, deployments.SupportsVerb("get"), "SupportsVerb(get)")
+	require.False(t, deployments.SupportsVerb("delete"), "SupportsVerb(delete)")
+}
+
+func TestDiscoveryCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8spkg-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	testee := &discoveryCache{dir, time.Minute, logrus.StandardLogger()}
+	types := []*APIResourceType{resType("pods", []string{"po"}, "", "Pod", true, "v1", "get")}
+	calls := 0
+	discover := func() ([]*APIResourceType, error) {
+		calls++
+		return types, nil
+	}
+	for i := 0; i < 2; i++ {
+		r, err := testee.resourceTypes("https://127.0.0.1:6443", discover)
+		require.NoError(t, err)
+		require.Equal(t, types, r)
+	}
+	require.Equal(t, 1, calls, "discovery calls")
+	file := filepath.Join(dir, "https___127.0.0.1_6443", "resourcetypes.json")
+	expired := time.Now().Add(-2 * time.Minute)
+	require.NoError(t, os.Chtimes(file, expired, expired))
+	_, err = testee.resourceTypes("https://127.0.0.1:6443", discover)
 	require.NoError(t, err)
-	require.Equal(t, expectedResTypes, types)
+	require.Equal(t, 2, calls, "discovery calls after expiry")
+	_, err = testee.resourceTypes("https://otherhost", discover)
+	require.NoError(t, err)
+	require.Equal(t, 3, calls, "discovery calls for other cluster")
+
+	_, err = testee.resourceTypes("https://failing", func() ([]*APIResourceType, error) {
+		return nil, errors.New("discovery failed")
+	})
+	require.Error(t, err)
+	_, err = os.Stat(filepath.Join(dir, "https___failing"))
+	require.True(t, os.IsNotExist(err), "should not cache failed discovery")
 }
 
-func resType(name string, shortNames []string, apiGroup, kind string, namespaced bool) *APIResourceType {
+func resType(name string, shortNames []string, apiGroup, kind string, namespaced bool, version string, verbs ...string) *APIResourceType {
 	return &APIResourceType{
 		Name:       name,
 		ShortNames: shortNames,
 		APIGroup:   apiGroup,
 		Kind:       kind,
 		Namespaced: namespaced,
+		Version:    version,
+		Versions:   []string{version},
+		Verbs:      verbs,
 	}
 }
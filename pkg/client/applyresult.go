@@ -0,0 +1,93 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+)
+
+// ApplyAction describes how a resource has been changed by an apply
+type ApplyAction string
+
+const (
+	ActionCreated    ApplyAction = "created"
+	ActionConfigured ApplyAction = "configured"
+	ActionUnchanged  ApplyAction = "unchanged"
+	ActionFailed     ApplyAction = "failed"
+)
+
+// ApplyResult is the outcome of applying a single resource
+type ApplyResult struct {
+	// Resource is the applied object as returned by the server or the input object
+	Resource *resource.K8sResource
+	Action   ApplyAction
+	// Error is the reason why the resource could not be applied
+	Error error
+}
+
+// ApplyResults holds the outcome of an apply per input resource
+type ApplyResults []*ApplyResult
+
+// Resources returns the resources that have been applied successfully
+func (r ApplyResults) Resources() (l resource.K8sResourceList) {
+	for _, res := range r {
+		if res.Action != ActionFailed {
+			l = append(l, res.Resource)
+		}
+	}
+	return
+}
+
+// Failed returns the results of the resources that could not be applied
+func (r ApplyResults) Failed() (failed ApplyResults) {
+	for _, res := range r {
+		if res.Action == ActionFailed {
+			failed = append(failed, res)
+		}
+	}
+	return
+}
+
+// ApplyError is returned when some or all resources could not be applied
+type ApplyError struct {
+	Results ApplyResults
+}
+
+// Partial returns true if some resources have been applied successfully
+func (e *ApplyError) Partial() bool {
+	return len(e.Results.Failed()) < len(e.Results)
+}
+
+func (e *ApplyError) Error() string {
+	failed := e.Results.Failed()
+	msgs := make([]string, len(failed))
+	for i, r := range failed {
+		msgs[i] = fmt.Sprintf("%s: %s", r.Resource.ID(), r.Error)
+	}
+	summary := fmt.Sprintf("none of the %d resources could be applied", len(e.Results))
+	if e.Partial() {
+		summary = fmt.Sprintf("%d of %d resources could not be applied", len(failed), len(e.Results))
+	}
+	return fmt.Sprintf("%s:\n  %s", summary, strings.Join(msgs, "\n  "))
+}
+
+// reason returns the reason all failed resources have in common
+func (e *ApplyError) reason() (reason ErrorReason) {
+	for i, r := range e.Results.Failed() {
+		if rr := Reason(r.Error); i == 0 {
+			reason = rr
+		} else if rr != reason {
+			return ReasonUnknown
+		}
+	}
+	return
+}
+
+// applyResultsError returns the error for failed results or nil
+func applyResultsError(results ApplyResults) error {
+	if len(results.Failed()) == 0 {
+		return nil
+	}
+	return &ApplyError{results}
+}
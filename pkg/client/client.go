@@ -6,37 +6,126 @@ import (
 	"fmt"
 	"io"
 	"os/exec"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/mgoltzsche/k8spkg/pkg/resource"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
+var kubectlApplyOutputPattern = regexp.MustCompile(`^([^/\s"]+)(?:/(\S+)| "([^"]+)") (created|configured|unchanged|serverside-applied)(?: \(.+\))?$`)
+
 const (
 	defaultTimeout = time.Duration(2 * time.Minute)
+	// FieldManager is the field manager name used for server-side apply
+	FieldManager = "k8spkg"
 )
 
 type K8sClient interface {
-	Apply(ctx context.Context, namespace string, resources resource.K8sResourceList, prune bool, labels []string) (resource.K8sResourceList, error)
-	Delete(ctx context.Context, namespace string, resources resource.K8sResourceRefList) (err error)
+	Apply(ctx context.Context, namespace string, resources resource.K8sResourceList, opts ApplyOptions) (ApplyResults, error)
+	Delete(ctx context.Context, namespace string, resources resource.K8sResourceRefList, opts DeleteOptions) (err error)
 	GetResource(ctx context.Context, kind string, namespace string, name string) (*resource.K8sResource, error)
 	Get(ctx context.Context, kinds []string, namespace string, labels []string) <-chan resource.ResourceEvent
 	//WatchResource(ctx context.Context, kind, namespace string, name string) <-chan WatchEvent
 	Watch(ctx context.Context, kind, namespace string, labels []string, watchOnly bool) <-chan resource.ResourceEvent
 	AwaitDeletion(ctx context.Context, namespace string, resources resource.K8sResourceRefList) (err error)
 	ResourceTypes(ctx context.Context) (types []*APIResourceType, err error)
+	CurrentContext(ctx context.Context) (string, error)
 	ContainerLogs(ctx context.Context, namespace, podName, containerName string, previous, follow bool, writer io.Writer) (err error)
 }
 
-type notFoundError struct {
-	error
+// Config specifies the cluster to talk to and the identity to use.
+// Empty values default to the kubeconfig's current context.
+type Config struct {
+	// KubeconfigFile overrides the KUBECONFIG env var
+	KubeconfigFile string
+	// Context selects the kubeconfig context
+	Context string
+	// Cluster overrides the context's cluster
+	Cluster string
+	// User overrides the context's user
+	User string
+	// Impersonate specifies the user to impersonate
+	Impersonate string
+	// ImpersonateGroups specifies the groups to impersonate
+	ImpersonateGroups []string
+	// Retry specifies how operations that failed with a transient error are retried
+	Retry RetryPolicy
+	// Log receives the client's log output - defaults to the standard logger
+	Log logrus.FieldLogger
+}
+
+// logger returns the logger the client's log output is written to
+func (c *Config) logger() logrus.FieldLogger {
+	if c.Log == nil {
+		return logrus.StandardLogger()
+	}
+	return c.Log
+}
+
+// kubectlArgs returns the global kubectl options that represent the config
+func (c *Config) kubectlArgs() (args []string) {
+	for _, o := range []struct{ flag, value string }{
+		{"--kubeconfig", c.KubeconfigFile},
+		{"--context", c.Context},
+		{"--cluster", c.Cluster},
+		{"--user", c.User},
+		{"--as", c.Impersonate},
+	} {
+		if o.value != "" {
+			args = append(args, o.flag, o.value)
+		}
+	}
+	for _, g := range c.ImpersonateGroups {
+		args = append(args, "--as-group", g)
+	}
+	return
+}
+
+// ApplyOptions specifies how resources are applied
+type ApplyOptions struct {
+	// Prune deletes previously applied resources matching the labels that are not within the input
+	Prune bool
+	// Labels selects the resources to apply (and prune)
+	Labels []string
+	// ServerSide applies the resources using server-side apply with field manager k8spkg
+	// instead of maintaining the last-applied-configuration annotation
+	ServerSide bool
+	// ForceConflicts takes over the ownership of fields that are managed by another field manager (server-side only)
+	ForceConflicts bool
+	// DryRun simulates the apply without persisting any change
+	DryRun DryRun
+}
+
+// DeleteOptions specifies how resources are deleted
+type DeleteOptions struct {
+	// DryRun simulates the deletion without persisting any change
+	DryRun DryRun
+}
+
+// DryRun specifies whether a modification is simulated and where
+type DryRun string
+
+const (
+	DryRunNone   DryRun = ""
+	DryRunClient DryRun = "client"
+	DryRunServer DryRun = "server"
+)
+
+// ParseDryRun parses a --dry-run option value
+func ParseDryRun(s string) (DryRun, error) {
+	switch d := DryRun(s); d {
+	case DryRunNone, DryRunClient, DryRunServer:
+		return d, nil
+	}
+	return DryRunNone, errors.Errorf("unsupported dry run mode %q provided, expected %s or %s", s, DryRunClient, DryRunServer)
 }
 
-func IsNotFound(err error) bool {
-	_, ok := err.(notFoundError)
-	return ok
+type notFoundError struct {
+	error
 }
 
 // APIResourceType represents a Kubernetes API resource type's metadata
@@ -46,6 +135,34 @@ type APIResourceType struct {
 	APIGroup   string
 	Kind       string
 	Namespaced bool
+	// Version is the API group's preferred version the type is served in
+	Version string
+	// Versions contains all versions the type is served in, the preferred one first
+	Versions []string
+	Verbs    []string
+}
+
+// Returns the type's plural name with version and APIGroup suffix ("deployments.v1.apps", "pods.v1.")
+func (t *APIResourceType) QualifiedName() string {
+	return t.Name + "." + t.Version + "." + t.APIGroup
+}
+
+// Returns the preferred apiVersion
+func (t *APIResourceType) APIVersion() string {
+	if t.APIGroup == "" {
+		return t.Version
+	}
+	return t.APIGroup + "/" + t.Version
+}
+
+// Returns true if the type supports the provided verb
+func (t *APIResourceType) SupportsVerb(verb string) bool {
+	for _, v := range t.Verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
 }
 
 // Returns the type's short name if any or its name
@@ -66,7 +183,8 @@ func (t *APIResourceType) FullName() (name string) {
 }
 
 type k8sClient struct {
-	kubeconfigFile string
+	config Config
+	cache  *discoveryCache
 }
 
 type WatchEvent struct {
@@ -74,35 +192,125 @@ type WatchEvent struct {
 	Error    error
 }
 
-func NewK8sClient(kubeconfigFile string) K8sClient {
-	return &k8sClient{kubeconfigFile}
+func NewK8sClient(config Config) K8sClient {
+	return withRetry(&k8sClient{config, newDiscoveryCache(config.logger())}, config.Retry, config.logger())
 }
 
-func (c *k8sClient) Apply(ctx context.Context, namespace string, resources resource.K8sResourceList, prune bool, labelSelector []string) (l resource.K8sResourceList, err error) {
-	args := []string{"apply", "--wait", "-f", "-", "--record", "--timeout=" + getTimeout(ctx)}
-	if len(labelSelector) > 0 {
-		args = append(args, "-l", strings.Join(labelSelector, ","))
+func (c *k8sClient) Apply(ctx context.Context, namespace string, resources resource.K8sResourceList, opts ApplyOptions) (results ApplyResults, err error) {
+	selector, err := labels.Parse(strings.Join(opts.Labels, ","))
+	if err != nil {
+		return
+	}
+	args := []string{"apply", "--wait", "-f", "-"}
+	if opts.ServerSide {
+		args = append(args, "--server-side", "--field-manager="+FieldManager)
+		if opts.ForceConflicts {
+			args = append(args, "--force-conflicts")
+		}
+	} else {
+		args = append(args, "--record")
+	}
+	if opts.DryRun != DryRunNone {
+		args = append(args, "--dry-run="+string(opts.DryRun))
+	}
+	args = append(args, "--timeout="+getTimeout(ctx))
+	if len(opts.Labels) > 0 {
+		args = append(args, "-l", strings.Join(opts.Labels, ","))
 	}
-	if prune {
+	if opts.Prune {
 		// TODO: delete objects within other namespaces that belong to the package as well
 		args = append(args, "--prune")
 	}
 	if namespace != "" {
 		args = append(args, "-n", namespace)
 	}
-	for evt := range c.kubectlEmit(ctx, resources.YamlReader(), args) {
-		if evt.Error == nil {
-			l = append(l, evt.Resource)
-		} else {
-			err = evt.Error
+	var stdout bytes.Buffer
+	err = kubectl(ctx, resources.YamlReader(), &stdout, &c.config, args)
+	if ctx.Err() != nil {
+		return
+	}
+	var stderr []string
+	kerr, isKubectlErr := errors.Cause(err).(*kubectlError)
+	if isKubectlErr {
+		stderr = kerr.stderr
+	}
+	results = applyResultsFromKubectl(resources, selector, strings.Split(stdout.String(), "\n"), stderr, err)
+	failed := results.Failed()
+	if opts.ServerSide && isKubectlErr {
+		refs := make(resource.K8sResourceRefList, len(failed))
+		for i, r := range failed {
+			refs[i] = r.Resource
+		}
+		if conflicts := conflictsFromKubectl(kerr.stderr, refs); len(conflicts) > 0 {
+			for _, r := range failed {
+				r.Error = &ConflictError{conflictsOf(conflicts, r.Resource)}
+			}
+			return results, &ConflictError{conflicts}
+		}
+	}
+	if e := applyResultsError(results); e != nil {
+		err = e
+	}
+	return
+}
+
+// applyResultsFromKubectl maps kubectl apply's output to the selected input resources.
+// Resources that kubectl did not report as applied are marked as failed with
+// the stderr line that refers to them or the whole error.
+func applyResultsFromKubectl(input resource.K8sResourceList, selector labels.Selector, stdout, stderr []string, err error) (results ApplyResults) {
+	for _, res := range input {
+		if selector.Matches(labels.Set(res.Labels())) {
+			results = append(results, &ApplyResult{Resource: res})
+		}
+	}
+	for _, line := range stdout {
+		m := kubectlApplyOutputPattern.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		kind, name, action := m[1], m[2]+m[3], ApplyAction(m[4])
+		if action == "serverside-applied" {
+			// kubectl does not tell whether the resource has been created
+			action = ActionConfigured
+		}
+		for _, r := range results {
+			if r.Action == "" && r.Resource.Name() == name &&
+				(r.Resource.GroupKind() == resource.CanonicalGroupKind(kind) || strings.ToLower(r.Resource.Kind()) == kind) {
+				r.Action = action
+				break
+			}
+		}
+	}
+	for _, r := range results {
+		if r.Action != "" {
+			continue
+		}
+		if err == nil {
+			// kubectl succeeded but reported the resource in an unknown format
+			r.Action = ActionConfigured
+			continue
+		}
+		r.Action = ActionFailed
+		r.Error = err
+		quotedName := `"` + r.Resource.Name() + `"`
+		kind := strings.ToLower(r.Resource.Kind())
+		for _, line := range stderr {
+			if strings.Contains(line, quotedName) && strings.Contains(strings.ToLower(line), kind) {
+				r.Error = &kubectlError{errors.Cause(err).(*kubectlError).error, []string{line}}
+				break
+			}
 		}
 	}
 	return
 }
 
-func (c *k8sClient) Delete(ctx context.Context, namespace string, resources resource.K8sResourceRefList) (err error) {
+func (c *k8sClient) Delete(ctx context.Context, namespace string, resources resource.K8sResourceRefList, opts DeleteOptions) (err error) {
 	for _, grp := range resources.GroupByNamespace() {
-		args := []string{"delete", "--wait", "--cascade", "--ignore-not-found", "--timeout=" + getTimeout(ctx)}
+		args := []string{"delete", "--wait", "--cascade", "--ignore-not-found"}
+		if opts.DryRun != DryRunNone {
+			args = append(args, "--dry-run="+string(opts.DryRun))
+		}
+		args = append(args, "--timeout="+getTimeout(ctx))
 		args = append(args, grp.Resources.Names()...)
 		if grp.Key == "" {
 			grp.Key = namespace
@@ -110,7 +318,7 @@ func (c *k8sClient) Delete(ctx context.Context, namespace string, resources reso
 		if grp.Key != "" {
 			args = append(args, "-n", grp.Key)
 		}
-		if e := kubectl(ctx, nil, nil, c.kubeconfigFile, args); e != nil && err == nil {
+		if e := kubectl(ctx, nil, nil, &c.config, args); e != nil && err == nil {
 			err = e
 		}
 	}
@@ -127,7 +335,7 @@ func (c *k8sClient) AwaitDeletion(ctx context.Context, namespace string, resourc
 		if grp.Key != "" {
 			args = append(args, "-n", grp.Key)
 		}
-		if err := kubectl(ctx, nil, nil, c.kubeconfigFile, args); err != nil {
+		if err := kubectl(ctx, nil, nil, &c.config, args); err != nil {
 			if kerr, ok := errors.Cause(err).(*kubectlError); ok {
 				var unexpectedLines []string
 				for _, line := range kerr.stderr {
@@ -181,7 +389,41 @@ func (c *k8sClient) Watch(ctx context.Context, kind, namespace string, labels []
 	if watchOnly {
 		args = append(args, "--watch-only")
 	}
-	return c.kubectlEmit(ctx, nil, getArgs(namespace, args...))
+	// kubectl cannot resume a watch from a resourceVersion - the objects listed
+	// by a resumed watch are deduplicated instead.
+	// Events that occur while a --watch-only watch is interrupted are lost.
+	ch := make(chan resource.ResourceEvent)
+	go func() {
+		defer close(ch)
+		seen := resourceVersions{}
+		err := resumeWatch(ctx, c.config.logger(), func() (progress bool, err error) {
+			for evt := range c.kubectlEmit(ctx, nil, getArgs(namespace, args...)) {
+				if evt.Error != nil {
+					err = evt.Error
+					continue
+				}
+				progress = true
+				if seen.add(evt.Resource) {
+					emit(ctx, ch, evt)
+				}
+			}
+			return
+		})
+		emit(ctx, ch, resource.ResourceEvent{Error: err})
+	}()
+	return ch
+}
+
+// CurrentContext returns the name of the kubeconfig context the client uses
+func (c *k8sClient) CurrentContext(ctx context.Context) (string, error) {
+	if c.config.Context != "" {
+		return c.config.Context, nil
+	}
+	var buf bytes.Buffer
+	if err := kubectl(ctx, nil, &buf, &c.config, []string{"config", "current-context"}); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
 }
 
 func (c *k8sClient) ContainerLogs(ctx context.Context, namespace, podName, containerName string, previous, follow bool, writer io.Writer) (err error) {
@@ -195,7 +437,7 @@ func (c *k8sClient) ContainerLogs(ctx context.Context, namespace, podName, conta
 	if namespace != "" {
 		args = append(args, "-n", namespace)
 	}
-	return kubectl(ctx, nil, writer, c.kubeconfigFile, args)
+	return kubectl(ctx, nil, writer, &c.config, args)
 }
 
 func (c *k8sClient) kubectlEmit(ctx context.Context, stdin io.Reader, args []string) <-chan resource.ResourceEvent {
@@ -208,7 +450,7 @@ func (c *k8sClient) kubectlEmit(ctx context.Context, stdin io.Reader, args []str
 			if evt.Error != nil && err == nil {
 				err = evt.Error
 			} else {
-				ch <- evt
+				emit(ctx, ch, evt)
 			}
 		}
 		reader.CloseWithError(err)
@@ -216,29 +458,27 @@ func (c *k8sClient) kubectlEmit(ctx context.Context, stdin io.Reader, args []str
 	}()
 	go func() {
 		args = append(args, "-o", "json")
-		err := kubectl(ctx, stdin, writer, c.kubeconfigFile, args)
+		err := kubectl(ctx, stdin, writer, &c.config, args)
 		writer.CloseWithError(err)
 		if e := <-done; e != nil && err == nil {
 			err = errors.Wrap(e, "get")
 		}
 		if err != nil {
-			ch <- resource.ResourceEvent{nil, err}
+			emit(ctx, ch, resource.ResourceEvent{Error: err})
 		}
 		close(ch)
 	}()
 	return ch
 }
 
-func kubectl(ctx context.Context, in io.Reader, out io.Writer, kubeconfigFile string, args []string) (err error) {
-	if kubeconfigFile != "" {
-		args = append(args, "--kubeconfig", kubeconfigFile)
-	}
+func kubectl(ctx context.Context, in io.Reader, out io.Writer, config *Config, args []string) (err error) {
+	args = append(args, config.kubectlArgs()...)
 	var buf bytes.Buffer
 	cmd := exec.CommandContext(ctx, "kubectl", args...)
 	cmd.Stdin = in
 	cmd.Stdout = out
 	cmd.Stderr = &buf
-	logrus.Debugf("Running %+v", cmd.Args)
+	config.logger().Debugf("Running %+v", cmd.Args)
 	err = cmd.Run()
 	if err != nil && ctx.Err() != nil {
 		return errors.WithStack(ctx.Err())
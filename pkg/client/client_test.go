@@ -7,9 +7,11 @@ import (
 	"io/ioutil"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/mgoltzsche/k8spkg/pkg/resource"
 	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 var (
@@ -29,26 +31,72 @@ func TestApply(t *testing.T) {
 	labelCases := [][]string{nil, {"my/label1=val1", "my/label2=val2"}}
 	for _, labels := range labelCases {
 		for _, ns := range []string{"", "myns"} {
-			expectedCall := "apply --wait -f - --record --timeout=" + defaultTimeout.String()
-			if len(labels) > 0 {
-				expectedCall += " -l " + strings.Join(labels, ",")
-			}
-			if ns != "" {
-				expectedCall += " -n " + ns
-			}
-			expectedCall += " -o json"
-			expectedCalls := []string{expectedCall}
-			assertKubectlCalls(t, expectedCalls, mockOut, func(c K8sClient) (err error) {
-				r, err := c.Apply(context.Background(), ns, obj, false, labels)
-				if err == nil {
-					require.Equal(t, obj.Refs().Names(), r.Refs().Names(), "applied - result")
+			for _, opts := range []ApplyOptions{
+				{Labels: labels},
+				{Labels: labels, ServerSide: true},
+				{Labels: labels, ServerSide: true, ForceConflicts: true},
+				{Labels: labels, DryRun: DryRunClient},
+			} {
+				expectedCall := "apply --wait -f -"
+				if opts.ServerSide {
+					expectedCall += " --server-side --field-manager=k8spkg"
+					if opts.ForceConflicts {
+						expectedCall += " --force-conflicts"
+					}
+				} else {
+					expectedCall += " --record"
 				}
-				return
-			})
+				if opts.DryRun != DryRunNone {
+					expectedCall += " --dry-run=" + string(opts.DryRun)
+				}
+				expectedCall += " --timeout=" + defaultTimeout.String()
+				if len(labels) > 0 {
+					expectedCall += " -l " + strings.Join(labels, ",")
+				}
+				if ns != "" {
+					expectedCall += " -n " + ns
+				}
+				expectedCalls := []string{expectedCall}
+				assertKubectlCalls(t, expectedCalls, nil, func(c K8sClient) (err error) {
+					r, err := c.Apply(context.Background(), ns, obj, opts)
+					if err == nil && len(labels) == 0 {
+						require.Equal(t, obj.Refs().Names(), r.Resources().Refs().Names(), "applied - result")
+					}
+					return
+				})
+			}
 		}
 	}
 }
 
+func TestApplyResultsFromKubectl(t *testing.T) {
+	input := resource.K8sResourceList{
+		resource.FromMap(map[string]interface{}{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": map[string]interface{}{"name": "mydeployment"}}),
+		resource.FromMap(map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]interface{}{"name": "mycm"}}),
+		resource.FromMap(map[string]interface{}{"apiVersion": "v1", "kind": "Service", "metadata": map[string]interface{}{"name": "mysvc"}}),
+		resource.FromMap(map[string]interface{}{"apiVersion": "v1", "kind": "Secret", "metadata": map[string]interface{}{"name": "mysecret"}}),
+	}
+	stdout := []string{
+		"deployment.apps/mydeployment created",
+		"configmap/mycm unchanged",
+		`service "mysvc" configured (dry run)`,
+		"",
+	}
+	stderr := []string{`The Secret "mysecret" is invalid: data[x]: Invalid value: "x"`}
+	kerr := &kubectlError{fmt.Errorf("exit status 1"), stderr}
+	results := applyResultsFromKubectl(input, labels.Everything(), stdout, stderr, kerr)
+	actions := []ApplyAction{}
+	for _, r := range results {
+		actions = append(actions, r.Action)
+	}
+	require.Equal(t, []ApplyAction{ActionCreated, ActionUnchanged, ActionConfigured, ActionFailed}, actions, "actions")
+	require.Equal(t, ReasonInvalid, Reason(results[3].Error), "reason of failed resource")
+	err := applyResultsError(results)
+	require.True(t, err.(*ApplyError).Partial(), "partial")
+	require.True(t, IsInvalid(err), "IsInvalid(err)")
+	require.Contains(t, err.Error(), "1 of 4 resources could not be applied", "error message")
+}
+
 func TestDelete(t *testing.T) {
 	mockOut, err := ioutil.ReadFile("../resource/test/k8sobjectlist.yaml")
 	require.NoError(t, err)
@@ -56,23 +104,29 @@ func TestDelete(t *testing.T) {
 	require.NoError(t, err)
 	refs := append(obj.Refs(), resource.ResourceRef("v1", "Secret", "cert-manager", "mysecret"))
 	for _, ns := range []string{"", "myns"} {
-		expectedCalls := []string{}
-		for _, grp := range refs.GroupByNamespace() {
-			expectedCall := "delete --wait --cascade --ignore-not-found --timeout=" + defaultTimeout.String()
-			for _, o := range grp.Resources {
-				expectedCall += " " + o.QualifiedKind() + "/" + o.Name()
-			}
-			if grp.Key == "" {
-				grp.Key = ns
-			}
-			if grp.Key != "" {
-				expectedCall += " -n " + grp.Key
+		for _, dryRun := range []DryRun{DryRunNone, DryRunServer} {
+			expectedCalls := []string{}
+			for _, grp := range refs.GroupByNamespace() {
+				expectedCall := "delete --wait --cascade --ignore-not-found"
+				if dryRun != DryRunNone {
+					expectedCall += " --dry-run=" + string(dryRun)
+				}
+				expectedCall += " --timeout=" + defaultTimeout.String()
+				for _, o := range grp.Resources {
+					expectedCall += " " + o.QualifiedKind() + "/" + o.Name()
+				}
+				if grp.Key == "" {
+					grp.Key = ns
+				}
+				if grp.Key != "" {
+					expectedCall += " -n " + grp.Key
+				}
+				expectedCalls = append(expectedCalls, expectedCall)
 			}
-			expectedCalls = append(expectedCalls, expectedCall)
+			assertKubectlCalls(t, expectedCalls, nil, func(c K8sClient) (err error) {
+				return c.Delete(context.Background(), ns, refs, DeleteOptions{DryRun: dryRun})
+			})
 		}
-		assertKubectlCalls(t, expectedCalls, nil, func(c K8sClient) (err error) {
-			return c.Delete(context.Background(), ns, refs)
-		})
 	}
 }
 
@@ -147,6 +201,10 @@ func TestGet(t *testing.T) {
 }
 
 func TestWatch(t *testing.T) {
+	defer func(interval time.Duration) { watchRetryInterval = interval }(watchRetryInterval)
+	defer func(retries int) { watchRetries = retries }(watchRetries)
+	watchRetryInterval = 10 * time.Millisecond
+	watchRetries = 1
 	mockOut, err := ioutil.ReadFile("mock/watch.json")
 	require.NoError(t, err)
 	labelCases := [][]string{nil, {"my/label1=val1", "my/label2=val2"}}
@@ -168,17 +226,23 @@ func TestWatch(t *testing.T) {
 				assertKubectlCalls(t, expectedCalls, mockOut, func(c K8sClient) (err error) {
 					resNames := map[string]bool{}
 					returnedIds := []string{}
-					for evt := range c.Watch(context.Background(), "Deployment", ns, labels, watchOnly) {
-						if evt.Error != nil {
+					ctx, cancel := context.WithCancel(context.Background())
+					defer cancel()
+					for evt := range c.Watch(ctx, "Deployment", ns, labels, watchOnly) {
+						if evt.Error != nil && ctx.Err() == nil {
 							err = evt.Error
 						}
-						if err != nil {
+						if err != nil || evt.Error != nil {
 							continue
 						}
 						if !resNames[evt.Resource.ID()] {
 							returnedIds = append(returnedIds, evt.Resource.ID())
 						}
 						resNames[evt.Resource.ID()] = true
+						if len(returnedIds) == 3 {
+							// kubectl mock exits after the output - watch would be resumed forever
+							cancel()
+						}
 					}
 					if err == nil {
 						expectedIds := []string{
@@ -213,3 +277,35 @@ func TestAwaitDeletion(t *testing.T) {
 		return c.AwaitDeletion(context.Background(), "", res)
 	})
 }
+
+func TestCurrentContext(t *testing.T) {
+	assertKubectlCalls(t, []string{"config current-context"}, nil, func(c K8sClient) (err error) {
+		_, err = c.CurrentContext(context.Background())
+		return
+	})
+	kubeContext, err := NewK8sClient(Config{Context: "myctx"}).CurrentContext(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "myctx", kubeContext, "context provided with config")
+}
+
+func TestConfigKubectlArgs(t *testing.T) {
+	c := Config{
+		KubeconfigFile:    "kubeconfig.yaml",
+		Context:           "myctx",
+		Cluster:           "mycluster",
+		User:              "myuser",
+		Impersonate:       "someone",
+		ImpersonateGroups: []string{"group1", "group2"},
+	}
+	expected := []string{
+		"--kubeconfig", "kubeconfig.yaml",
+		"--context", "myctx",
+		"--cluster", "mycluster",
+		"--user", "myuser",
+		"--as", "someone",
+		"--as-group", "group1",
+		"--as-group", "group2",
+	}
+	require.Equal(t, expected, c.kubectlArgs())
+	require.Nil(t, (&Config{}).kubectlArgs(), "empty config")
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	conflictManagerPattern = regexp.MustCompile(`^conflicts? with "([^"]+)"(?: using \S+(?: at \S+)?)?(?:: (.+))?:?$`)
+	conflictFieldPattern   = regexp.MustCompile(`^- (.+)$`)
+	applyFailedPrefix      = "Apply failed with "
+)
+
+// FieldConflict describes fields of a resource that are owned by another field manager
+type FieldConflict struct {
+	Resource resource.K8sResourceRef
+	Manager  string
+	Fields   []string
+}
+
+func (c *FieldConflict) String() string {
+	return fmt.Sprintf("%s: conflict with %q: %s", c.Resource.ID(), c.Manager, strings.Join(c.Fields, ", "))
+}
+
+// ConflictError is returned by a server-side apply when fields of the
+// applied resources are owned by another field manager.
+// The apply can be forced using ApplyOptions.ForceConflicts.
+type ConflictError struct {
+	Conflicts []*FieldConflict
+}
+
+func (e *ConflictError) Error() string {
+	msgs := make([]string, len(e.Conflicts))
+	for i, c := range e.Conflicts {
+		msgs[i] = c.String()
+	}
+	return fmt.Sprintf("field manager conflicts (use --force-conflicts to override):\n  %s", strings.Join(msgs, "\n  "))
+}
+
+// Conflicts returns the field conflicts of a ConflictError or nil
+func Conflicts(err error) []*FieldConflict {
+	if e, ok := errors.Cause(err).(*ConflictError); ok {
+		return e.Conflicts
+	}
+	return nil
+}
+
+// conflictsOf returns the conflicts of the provided resource
+func conflictsOf(conflicts []*FieldConflict, ref resource.K8sResourceRef) (filtered []*FieldConflict) {
+	for _, c := range conflicts {
+		if c.Resource == ref {
+			filtered = append(filtered, c)
+		}
+	}
+	return
+}
+
+// conflictsFromStatus extracts field conflicts from an API server conflict error
+func conflictsFromStatus(ref resource.K8sResourceRef, err error) (conflicts []*FieldConflict) {
+	status, ok := errors.Cause(err).(apierrors.APIStatus)
+	if !ok || !apierrors.IsConflict(err) || status.Status().Details == nil {
+		return
+	}
+	byManager := map[string]*FieldConflict{}
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type != metav1.CauseTypeFieldManagerConflict {
+			continue
+		}
+		manager := cause.Message
+		if m := conflictManagerPattern.FindStringSubmatch(cause.Message); m != nil {
+			manager = m[1]
+		}
+		c := byManager[manager]
+		if c == nil {
+			c = &FieldConflict{Resource: ref, Manager: manager}
+			byManager[manager] = c
+			conflicts = append(conflicts, c)
+		}
+		c.Fields = append(c.Fields, cause.Field)
+	}
+	return
+}
+
+// conflictsFromKubectl maps the conflicts reported within kubectl's stderr
+// to the resources that have not been applied.
+// Since kubectl does not name the resource within a conflict message but
+// processes the resources in order the n-th error is mapped to the n-th
+// unapplied resource.
+// Returns nil if the errors cannot be mapped unambiguously.
+func conflictsFromKubectl(stderr []string, unapplied resource.K8sResourceRefList) (conflicts []*FieldConflict) {
+	var (
+		errs     [][]*FieldConflict
+		current  *FieldConflict
+		conflict bool
+	)
+	for _, line := range stderr {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "error: ") || strings.HasPrefix(line, "Error from server") {
+			errs = append(errs, nil)
+			current = nil
+		}
+		if pos := strings.Index(line, applyFailedPrefix); pos >= 0 && len(errs) > 0 {
+			conflict = true
+			line = line[pos+len(applyFailedPrefix):]
+			if pos = strings.Index(line, ": "); pos >= 0 {
+				line = line[pos+2:]
+			}
+		}
+		if m := conflictManagerPattern.FindStringSubmatch(line); m != nil && len(errs) > 0 {
+			current = &FieldConflict{Manager: m[1]}
+			if m[2] != "" {
+				current.Fields = []string{m[2]}
+			}
+			errs[len(errs)-1] = append(errs[len(errs)-1], current)
+		} else if m := conflictFieldPattern.FindStringSubmatch(line); m != nil && current != nil {
+			current.Fields = append(current.Fields, m[1])
+		} else {
+			current = nil
+		}
+	}
+	if !conflict || len(errs) != len(unapplied) {
+		return nil
+	}
+	for i, errConflicts := range errs {
+		for _, c := range errConflicts {
+			c.Resource = unapplied[i]
+			conflicts = append(conflicts, c)
+		}
+	}
+	return
+}
@@ -0,0 +1,37 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConflictsFromKubectl(t *testing.T) {
+	stderr := strings.Split(`error: Apply failed with 1 conflict: conflict with "kubectl-client-side-apply" using apps/v1 at 2019-11-01T10:00:00Z: .spec.replicas
+Please review the fields above--they currently have other managers. Here
+are the ways you can resolve this warning:
+* If you intend to manage all of these fields, please re-run the apply
+  command with the `+"`--force-conflicts`"+` flag.
+error: Apply failed with 3 conflicts: conflicts with "hpa" using autoscaling/v1:
+- .spec.replicas
+- .spec.template.spec.containers[name="app"].resources
+conflict with "operator": .metadata.labels.version
+Please review the fields above--they currently have other managers.`, "\n")
+	unapplied := resource.K8sResourceRefList{
+		resource.ResourceRef("apps/v1", "Deployment", "myns", "a"),
+		resource.ResourceRef("apps/v1", "Deployment", "myns", "b"),
+	}
+	conflicts := conflictsFromKubectl(stderr, unapplied)
+	expected := []*FieldConflict{
+		{unapplied[0], "kubectl-client-side-apply", []string{".spec.replicas"}},
+		{unapplied[1], "hpa", []string{".spec.replicas", `.spec.template.spec.containers[name="app"].resources`}},
+		{unapplied[1], "operator", []string{".metadata.labels.version"}},
+	}
+	require.Equal(t, expected, conflicts)
+	require.Contains(t, (&ConflictError{conflicts}).Error(), `deployment.apps:myns:b: conflict with "hpa": .spec.replicas, .spec.template`)
+
+	require.Nil(t, conflictsFromKubectl(stderr, unapplied[:1]), "ambiguous errors")
+	require.Nil(t, conflictsFromKubectl([]string{"error: unrelated"}, unapplied[:1]), "no conflict")
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ErrorReason classifies an error returned by a K8sClient
+type ErrorReason string
+
+const (
+	ReasonUnknown           ErrorReason = ""
+	ReasonNotFound          ErrorReason = "NotFound"
+	ReasonConflict          ErrorReason = "Conflict"
+	ReasonForbidden         ErrorReason = "Forbidden"
+	ReasonInvalid           ErrorReason = "Invalid"
+	ReasonAlreadyExists     ErrorReason = "AlreadyExists"
+	ReasonThrottled         ErrorReason = "Throttled"
+	ReasonServerTimeout     ErrorReason = "ServerTimeout"
+	ReasonConnectionRefused ErrorReason = "ConnectionRefused"
+)
+
+var (
+	kubectlReasonPattern = regexp.MustCompile(`^Error from server \(([A-Za-z]+)\)`)
+	statusReasons        = map[metav1.StatusReason]ErrorReason{
+		metav1.StatusReasonNotFound:        ReasonNotFound,
+		metav1.StatusReasonConflict:        ReasonConflict,
+		metav1.StatusReasonForbidden:       ReasonForbidden,
+		metav1.StatusReasonInvalid:         ReasonInvalid,
+		metav1.StatusReasonAlreadyExists:   ReasonAlreadyExists,
+		metav1.StatusReasonTooManyRequests: ReasonThrottled,
+		metav1.StatusReasonServerTimeout:   ReasonServerTimeout,
+		metav1.StatusReasonTimeout:         ReasonServerTimeout,
+	}
+)
+
+// Reason returns the classification of an error returned by a K8sClient
+func Reason(err error) ErrorReason {
+	if err == nil {
+		return ReasonUnknown
+	}
+	switch e := errors.Cause(err).(type) {
+	case notFoundError:
+		return ReasonNotFound
+	case *ConflictError:
+		return ReasonConflict
+	case *ApplyError:
+		return e.reason()
+	case *sessionError:
+		return e.Reason
+	case *kubectlError:
+		return reasonFromKubectl(e.stderr)
+	case apierrors.APIStatus:
+		if r, ok := statusReasons[e.Status().Reason]; ok {
+			return r
+		}
+		switch e.Status().Code {
+		case 429:
+			return ReasonThrottled
+		case 504:
+			return ReasonServerTimeout
+		}
+		return ReasonUnknown
+	}
+	if isConnectionRefused(err.Error()) {
+		return ReasonConnectionRefused
+	}
+	return ReasonUnknown
+}
+
+// reasonFromKubectl classifies an error using kubectl's stderr output
+func reasonFromKubectl(stderr []string) ErrorReason {
+	for _, line := range stderr {
+		if m := kubectlReasonPattern.FindStringSubmatch(line); m != nil {
+			if r, ok := statusReasons[metav1.StatusReason(m[1])]; ok {
+				return r
+			}
+		}
+		switch {
+		case isConnectionRefused(line):
+			return ReasonConnectionRefused
+		case strings.Contains(line, " is invalid: "):
+			return ReasonInvalid
+		case strings.Contains(line, "the server was unable to return a response in the time allotted"):
+			return ReasonServerTimeout
+		}
+	}
+	return ReasonUnknown
+}
+
+func isConnectionRefused(msg string) bool {
+	return strings.Contains(msg, "connection refused") || strings.Contains(msg, "was refused")
+}
+
+func IsNotFound(err error) bool {
+	return Reason(err) == ReasonNotFound
+}
+
+// IsConflict returns true if the error indicates a concurrent modification or a field manager conflict
+func IsConflict(err error) bool {
+	return Reason(err) == ReasonConflict
+}
+
+func IsForbidden(err error) bool {
+	return Reason(err) == ReasonForbidden
+}
+
+func IsInvalid(err error) bool {
+	return Reason(err) == ReasonInvalid
+}
+
+func IsAlreadyExists(err error) bool {
+	return Reason(err) == ReasonAlreadyExists
+}
+
+func IsThrottled(err error) bool {
+	return Reason(err) == ReasonThrottled
+}
+
+func IsServerTimeout(err error) bool {
+	return Reason(err) == ReasonServerTimeout
+}
+
+func IsConnectionRefused(err error) bool {
+	return Reason(err) == ReasonConnectionRefused
+}
+
+// IsTransient returns true if an operation that failed with the error may succeed when retried
+func IsTransient(err error) bool {
+	switch Reason(err) {
+	case ReasonThrottled, ReasonServerTimeout, ReasonConnectionRefused:
+		return true
+	}
+	return false
+}
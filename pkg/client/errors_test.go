@@ -0,0 +1,60 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestReason(t *testing.T) {
+	gr := schema.GroupResource{Group: "apps", Resource: "deployments"}
+	kubectlErr := func(stderr ...string) error {
+		return errors.Wrap(&kubectlError{errors.New("exit status 1"), stderr}, "kubectl")
+	}
+	for _, c := range []struct {
+		err       error
+		reason    ErrorReason
+		transient bool
+	}{
+		{nil, ReasonUnknown, false},
+		{errors.New("some error"), ReasonUnknown, false},
+		{notFoundError{errors.New("not found")}, ReasonNotFound, false},
+		{errors.Wrap(apierrors.NewNotFound(gr, "x"), "get"), ReasonNotFound, false},
+		{apierrors.NewConflict(gr, "x", errors.New("modified")), ReasonConflict, false},
+		{&ConflictError{}, ReasonConflict, false},
+		{apierrors.NewForbidden(gr, "x", errors.New("denied")), ReasonForbidden, false},
+		{apierrors.NewInvalid(schema.GroupKind{Group: "apps", Kind: "Deployment"}, "x", field.ErrorList{}), ReasonInvalid, false},
+		{apierrors.NewAlreadyExists(gr, "x"), ReasonAlreadyExists, false},
+		{apierrors.NewTooManyRequests("slow down", 1), ReasonThrottled, true},
+		{apierrors.NewServerTimeout(gr, "get", 1), ReasonServerTimeout, true},
+		{apierrors.NewTimeoutError("timeout", 1), ReasonServerTimeout, true},
+		{&url.Error{Op: "Get", URL: "https://127.0.0.1:6443", Err: fmt.Errorf("dial tcp 127.0.0.1:6443: connect: connection refused")}, ReasonConnectionRefused, true},
+		{kubectlErr(`Error from server (NotFound): deployments.apps "x" not found`), ReasonNotFound, false},
+		{kubectlErr(`Error from server (Conflict): Operation cannot be fulfilled on deployments.apps "x": the object has been modified`), ReasonConflict, false},
+		{kubectlErr(`Error from server (Forbidden): deployments.apps "x" is forbidden: User "bob" cannot get resource`), ReasonForbidden, false},
+		{kubectlErr(`The Deployment "x" is invalid: spec.template.metadata.labels: Invalid value`), ReasonInvalid, false},
+		{kubectlErr(`Error from server (AlreadyExists): deployments.apps "x" already exists`), ReasonAlreadyExists, false},
+		{kubectlErr(`Error from server (TooManyRequests): the server has received too many requests`), ReasonThrottled, true},
+		{kubectlErr(`Error from server (ServerTimeout): the server was unable to return a response in the time allotted`), ReasonServerTimeout, true},
+		{kubectlErr(`Error from server (Timeout): the server was unable to return a response in the time allotted`), ReasonServerTimeout, true},
+		{kubectlErr(`The connection to the server 127.0.0.1:6443 was refused - did you specify the right host or port?`), ReasonConnectionRefused, true},
+		{kubectlErr(`error: unknown flag`), ReasonUnknown, false},
+	} {
+		require.Equal(t, c.reason, Reason(c.err), "Reason(%v)", c.err)
+		require.Equal(t, c.transient, IsTransient(c.err), "IsTransient(%v)", c.err)
+	}
+	require.True(t, IsNotFound(kubectlErr(`Error from server (NotFound): not found`)), "IsNotFound()")
+	require.True(t, IsConflict(&ConflictError{}), "IsConflict()")
+	require.True(t, IsForbidden(apierrors.NewForbidden(gr, "x", errors.New("denied"))), "IsForbidden()")
+	require.True(t, IsInvalid(kubectlErr(`The Deployment "x" is invalid: spec`)), "IsInvalid()")
+	require.True(t, IsAlreadyExists(apierrors.NewAlreadyExists(gr, "x")), "IsAlreadyExists()")
+	require.True(t, IsThrottled(apierrors.NewTooManyRequests("slow down", 1)), "IsThrottled()")
+	require.True(t, IsServerTimeout(apierrors.NewServerTimeout(gr, "get", 1)), "IsServerTimeout()")
+	require.True(t, IsConnectionRefused(errors.New("connect: connection refused")), "IsConnectionRefused()")
+}
@@ -0,0 +1,419 @@
+// Package fakecluster provides an in-memory cluster that implements
+// client.K8sClient in order to test code built on top of it without a cluster.
+package fakecluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mgoltzsche/k8spkg/pkg/client"
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// DefaultNamespace is used for namespaced objects when no namespace is specified
+const DefaultNamespace = "default"
+
+// Options configures a Cluster
+type Options struct {
+	// Context is returned as the current kubeconfig context - defaults to "fake"
+	Context string
+	// ResourceTypes served by the cluster - defaults to DefaultResourceTypes()
+	ResourceTypes []*client.APIResourceType
+	// DeploymentDelay after which an applied Deployment becomes available
+	DeploymentDelay time.Duration
+	// JobDelay after which an applied Job completes
+	JobDelay time.Duration
+	// PodDelay after which an applied Pod becomes ready
+	PodDelay time.Duration
+}
+
+// Cluster is an in-memory cluster that stores objects, honors namespaces and
+// label selectors and emits watch events when objects change or are deleted.
+// It simulates the controllers of Deployments, Jobs, Pods and
+// CustomResourceDefinitions by updating their status after the configured delays.
+// Field ownership is not tracked: server-side applies never conflict.
+type Cluster struct {
+	opts            Options
+	types           []*client.APIResourceType
+	objects         map[string]map[string]interface{}
+	watchers        map[*watcher]bool
+	resourceVersion int
+	mutex           sync.Mutex
+}
+
+var _ client.K8sClient = &Cluster{}
+
+// New creates an empty Cluster
+func New(opts Options) *Cluster {
+	if opts.Context == "" {
+		opts.Context = "fake"
+	}
+	types := opts.ResourceTypes
+	if types == nil {
+		types = DefaultResourceTypes()
+	}
+	return &Cluster{
+		opts:     opts,
+		types:    append([]*client.APIResourceType{}, types...),
+		objects:  map[string]map[string]interface{}{},
+		watchers: map[*watcher]bool{},
+	}
+}
+
+// Objects returns all objects stored within the cluster ordered by type, namespace and name
+func (c *Cluster) Objects() (l resource.K8sResourceList) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, key := range c.sortedKeys() {
+		l = append(l, resource.FromMap(copyObject(c.objects[key])))
+	}
+	return
+}
+
+func (c *Cluster) sortedKeys() []string {
+	keys := make([]string, 0, len(c.objects))
+	for key := range c.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// typeFor returns the resource type the kind argument refers to
+func (c *Cluster) typeFor(kind string) (*client.APIResourceType, error) {
+	for _, t := range c.types {
+		if matchesType(t, kind) {
+			return t, nil
+		}
+	}
+	return nil, errors.Errorf("the server doesn't have a resource type %q", kind)
+}
+
+// typeOf returns the resource type of the provided object
+func (c *Cluster) typeOf(ref resource.K8sResourceRef) (*client.APIResourceType, error) {
+	group := apiGroup(ref.APIVersion())
+	for _, t := range c.types {
+		if t.APIGroup == group && t.Kind == ref.Kind() {
+			return t, nil
+		}
+	}
+	return nil, errors.Errorf("no matches for kind %q in version %q", ref.Kind(), ref.APIVersion())
+}
+
+// namespaceOf returns the namespace an object of the type is stored in
+func namespaceOf(t *client.APIResourceType, namespaces ...string) string {
+	if !t.Namespaced {
+		return ""
+	}
+	for _, ns := range namespaces {
+		if ns != "" {
+			return ns
+		}
+	}
+	return DefaultNamespace
+}
+
+// objectKey returns the key an object is stored with.
+// Types of legacy API groups share the objects with the group they have been moved to.
+func objectKey(t *client.APIResourceType, namespace, name string) string {
+	return groupKind(t) + "/" + namespace + "/" + name
+}
+
+// groupKind returns the type's canonical group-kind
+func groupKind(t *client.APIResourceType) string {
+	return resource.ResourceRef(t.APIVersion(), t.Kind, "", "").GroupKind()
+}
+
+// asType returns a copy of the object in the type's version
+func asType(obj map[string]interface{}, t *client.APIResourceType) map[string]interface{} {
+	obj = copyObject(obj)
+	obj["apiVersion"] = t.APIVersion()
+	return obj
+}
+
+func (c *Cluster) Apply(ctx context.Context, namespace string, resources resource.K8sResourceList, opts client.ApplyOptions) (results client.ApplyResults, err error) {
+	selector, err := labels.Parse(strings.Join(opts.Labels, ","))
+	if err != nil {
+		return
+	}
+	if e := ctx.Err(); e != nil {
+		return nil, errors.WithStack(e)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	applied := map[string]bool{}
+	namespaces := map[string]bool{}
+	for _, res := range resources {
+		if !selector.Matches(labels.Set(res.Labels())) {
+			continue
+		}
+		key, obj, action, e := c.apply(namespace, res, opts.DryRun == client.DryRunNone)
+		if e != nil {
+			results = append(results, &client.ApplyResult{Resource: res, Action: client.ActionFailed, Error: e})
+			continue
+		}
+		applied[key] = true
+		ns, _ := metadata(obj)["namespace"].(string)
+		namespaces[ns] = true
+		results = append(results, &client.ApplyResult{Resource: resource.FromMap(copyObject(obj)), Action: action})
+	}
+	if len(results.Failed()) > 0 {
+		return results, &client.ApplyError{Results: results}
+	}
+	if opts.Prune {
+		if len(opts.Labels) == 0 {
+			return results, errors.New("apply: prune requires a label selector")
+		}
+		for _, key := range c.sortedKeys() {
+			obj := resource.FromMap(c.objects[key])
+			if !applied[key] && selector.Matches(labels.Set(obj.Labels())) &&
+				(obj.Namespace() == "" || namespaces[obj.Namespace()]) &&
+				opts.DryRun == client.DryRunNone {
+				c.delete(key)
+			}
+		}
+	}
+	return
+}
+
+// apply stores the object unless it is a dry run and returns its key, the stored object and what happened
+func (c *Cluster) apply(namespace string, res *resource.K8sResource, store bool) (key string, obj map[string]interface{}, action client.ApplyAction, err error) {
+	if err = res.Validate(); err != nil {
+		return
+	}
+	t, err := c.typeOf(res)
+	if err != nil {
+		return
+	}
+	ns := namespaceOf(t, res.Namespace(), namespace)
+	key = objectKey(t, ns, res.Name())
+	obj = copyObject(res.Raw())
+	meta := metadata(obj)
+	meta["namespace"] = ns
+	if ns == "" {
+		delete(meta, "namespace")
+	}
+	delete(obj, "status")
+	current := c.objects[key]
+	if current == nil {
+		action = client.ActionCreated
+		meta["uid"] = fmt.Sprintf("fake-%d", c.resourceVersion+1)
+		meta["creationTimestamp"] = time.Now().UTC().Format(time.RFC3339)
+		meta["generation"] = float64(1)
+	} else {
+		action = client.ActionConfigured
+		currentMeta := metadata(current)
+		for _, field := range []string{"uid", "creationTimestamp", "generation", "resourceVersion"} {
+			if v, ok := currentMeta[field]; ok {
+				meta[field] = v
+			}
+		}
+		if status, ok := current["status"]; ok {
+			obj["status"] = copyValue(status)
+		}
+		if reflect.DeepEqual(obj, current) {
+			return key, current, client.ActionUnchanged, nil
+		}
+		meta["generation"] = generation(current) + 1
+	}
+	if !store {
+		return
+	}
+	c.put(key, obj)
+	c.reconcile(key, obj)
+	return
+}
+
+// put stores the object with a new resourceVersion and notifies the watchers
+func (c *Cluster) put(key string, obj map[string]interface{}) {
+	c.resourceVersion++
+	metadata(obj)["resourceVersion"] = strconv.Itoa(c.resourceVersion)
+	c.objects[key] = obj
+	c.notify(obj)
+}
+
+// delete removes the object and notifies the watchers
+func (c *Cluster) delete(key string) {
+	obj := c.objects[key]
+	if obj == nil {
+		return
+	}
+	if res := resource.FromMap(obj); res.Kind() == "Namespace" && apiGroup(res.APIVersion()) == "" {
+		// delete the namespace's contents
+		for _, k := range c.sortedKeys() {
+			if metadata(c.objects[k])["namespace"] == res.Name() {
+				c.remove(k)
+			}
+		}
+	}
+	c.remove(key)
+}
+
+// remove removes the object and notifies the watchers with its last state
+// with the deletionTimestamp and a new resourceVersion set
+func (c *Cluster) remove(key string) {
+	obj := copyObject(c.objects[key])
+	delete(c.objects, key)
+	c.resourceVersion++
+	meta := metadata(obj)
+	meta["resourceVersion"] = strconv.Itoa(c.resourceVersion)
+	meta["deletionTimestamp"] = time.Now().UTC().Format(time.RFC3339)
+	c.notify(obj)
+}
+
+func (c *Cluster) Delete(ctx context.Context, namespace string, resources resource.K8sResourceRefList, opts client.DeleteOptions) (err error) {
+	if e := ctx.Err(); e != nil {
+		return errors.WithStack(e)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	keys := make([]string, 0, len(resources))
+	for _, ref := range resources {
+		t, e := c.typeOf(ref)
+		if e != nil {
+			return e
+		}
+		keys = append(keys, objectKey(t, namespaceOf(t, ref.Namespace(), namespace), ref.Name()))
+	}
+	if opts.DryRun == client.DryRunNone {
+		for _, key := range keys {
+			c.delete(key)
+		}
+	}
+	return
+}
+
+// AwaitDeletion returns immediately since objects are deleted synchronously
+func (c *Cluster) AwaitDeletion(ctx context.Context, namespace string, resources resource.K8sResourceRefList) (err error) {
+	return errors.WithStack(ctx.Err())
+}
+
+func (c *Cluster) GetResource(ctx context.Context, kind string, namespace string, name string) (*resource.K8sResource, error) {
+	t, err := c.typeFor(kind)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	obj := c.objects[objectKey(t, namespaceOf(t, namespace), name)]
+	if obj == nil {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Group: t.APIGroup, Resource: t.Name}, name)
+	}
+	return resource.FromMap(asType(obj, t)), nil
+}
+
+func (c *Cluster) Get(ctx context.Context, kinds []string, namespace string, labelSelector []string) <-chan resource.ResourceEvent {
+	ch := make(chan resource.ResourceEvent)
+	go func() {
+		defer close(ch)
+		var l resource.K8sResourceList
+		var err error
+		for _, kind := range kinds {
+			match, e := c.matcher(kind, namespace, labelSelector)
+			if e != nil {
+				err = e
+				break
+			}
+			c.mutex.Lock()
+			for _, key := range c.sortedKeys() {
+				if obj := match(c.objects[key]); obj != nil {
+					l = append(l, resource.FromMap(obj))
+				}
+			}
+			c.mutex.Unlock()
+		}
+		for _, res := range l {
+			ch <- resource.ResourceEvent{Resource: res}
+		}
+		if err != nil {
+			ch <- resource.ResourceEvent{Error: err}
+		}
+	}()
+	return ch
+}
+
+// matcher returns a function that returns a copy of the object in the kind's version
+// if it is of the kind, within the namespace and matches the label selector or nil otherwise.
+// Like the API server it serves the objects regardless of the version or legacy API group they have been applied with.
+func (c *Cluster) matcher(kind, namespace string, labelSelector []string) (func(map[string]interface{}) map[string]interface{}, error) {
+	t, err := c.typeFor(kind)
+	if err != nil {
+		return nil, err
+	}
+	selector, err := labels.Parse(strings.Join(labelSelector, ","))
+	if err != nil {
+		return nil, err
+	}
+	ns := namespaceOf(t, namespace)
+	gk := groupKind(t)
+	return func(obj map[string]interface{}) map[string]interface{} {
+		res := resource.FromMap(obj)
+		if res.GroupKind() == gk && res.Namespace() == ns && selector.Matches(labels.Set(res.Labels())) {
+			return asType(obj, t)
+		}
+		return nil
+	}, nil
+}
+
+func (c *Cluster) ResourceTypes(ctx context.Context) ([]*client.APIResourceType, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]*client.APIResourceType{}, c.types...), nil
+}
+
+func (c *Cluster) CurrentContext(ctx context.Context) (string, error) {
+	return c.opts.Context, nil
+}
+
+// ContainerLogs writes no logs since no containers are run
+func (c *Cluster) ContainerLogs(ctx context.Context, namespace, podName, containerName string, previous, follow bool, writer io.Writer) (err error) {
+	_, err = c.GetResource(ctx, "pod", namespace, podName)
+	return
+}
+
+func metadata(obj map[string]interface{}) map[string]interface{} {
+	meta, ok := obj["metadata"].(map[string]interface{})
+	if !ok {
+		meta = map[string]interface{}{}
+		obj["metadata"] = meta
+	}
+	return meta
+}
+
+func generation(obj map[string]interface{}) float64 {
+	g, _ := metadata(obj)["generation"].(float64)
+	return g
+}
+
+// copyObject returns a deep copy of the object with JSON types only (numbers as float64)
+func copyObject(obj map[string]interface{}) (c map[string]interface{}) {
+	copyJSON(obj, &c)
+	return
+}
+
+func copyValue(v interface{}) (c interface{}) {
+	copyJSON(v, &c)
+	return
+}
+
+func copyJSON(v, target interface{}) {
+	b, err := json.Marshal(v)
+	if err == nil {
+		err = json.Unmarshal(b, target)
+	}
+	if err != nil {
+		panic(errors.Wrap(err, "copy object"))
+	}
+}
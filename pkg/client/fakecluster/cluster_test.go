@@ -0,0 +1,157 @@
+package fakecluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mgoltzsche/k8spkg/pkg/client"
+	"github.com/mgoltzsche/k8spkg/pkg/k8spkg"
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func testResource(apiVersion, kind, namespace, name string, labels map[string]interface{}) *resource.K8sResource {
+	meta := map[string]interface{}{"name": name, "labels": labels}
+	if namespace != "" {
+		meta["namespace"] = namespace
+	}
+	return resource.FromMap(map[string]interface{}{"apiVersion": apiVersion, "kind": kind, "metadata": meta})
+}
+
+func actions(results client.ApplyResults) (a []client.ApplyAction) {
+	for _, r := range results {
+		a = append(a, r.Action)
+	}
+	return
+}
+
+func TestClusterApply(t *testing.T) {
+	ctx := context.Background()
+	testee := New(Options{})
+	pkgLabel := map[string]interface{}{"pkg": "mypkg"}
+	cm := testResource("v1", "ConfigMap", "", "mycm", pkgLabel)
+	ns := testResource("v1", "Namespace", "", "myns", pkgLabel)
+	stale := testResource("v1", "ConfigMap", "", "stale", pkgLabel)
+	unlabeled := testResource("v1", "ConfigMap", "", "unlabeled", nil)
+	opts := client.ApplyOptions{Labels: []string{"pkg=mypkg"}}
+
+	results, err := testee.Apply(ctx, "myns", resource.K8sResourceList{cm, ns, stale, unlabeled}, opts)
+	require.NoError(t, err)
+	require.Equal(t, []client.ApplyAction{client.ActionCreated, client.ActionCreated, client.ActionCreated}, actions(results), "actions")
+	require.Equal(t, "myns", results[0].Resource.Namespace(), "namespace of namespaced object")
+	require.Equal(t, "", results[1].Resource.Namespace(), "namespace of cluster-scoped object")
+
+	cm.Raw()["data"] = map[string]interface{}{"key": "value"}
+	opts.Prune = true
+	results, err = testee.Apply(ctx, "myns", resource.K8sResourceList{cm, ns}, opts)
+	require.NoError(t, err)
+	require.Equal(t, []client.ApplyAction{client.ActionConfigured, client.ActionUnchanged}, actions(results), "actions")
+	_, err = testee.GetResource(ctx, "configmap", "myns", "stale")
+	require.True(t, client.IsNotFound(err), "stale object should have been pruned")
+	res, err := testee.GetResource(ctx, "cm", "myns", "mycm")
+	require.NoError(t, err)
+	v, _, _ := unstructured.NestedString(res.Raw(), "data", "key")
+	require.Equal(t, "value", v, "data of configured object")
+
+	var names []string
+	for evt := range testee.Get(ctx, []string{"configmap", "namespace"}, "myns", []string{"pkg=mypkg"}) {
+		require.NoError(t, evt.Error)
+		names = append(names, evt.Resource.Name())
+	}
+	require.Equal(t, []string{"mycm", "myns"}, names, "objects listed by label")
+
+	unknown := testResource("example.org/v1", "Unknown", "", "x", pkgLabel)
+	results, err = testee.Apply(ctx, "myns", resource.K8sResourceList{cm, unknown}, opts)
+	require.Error(t, err, "apply unknown type")
+	require.Equal(t, []client.ApplyAction{client.ActionUnchanged, client.ActionFailed}, actions(results), "actions")
+	require.True(t, err.(*client.ApplyError).Partial(), "partial")
+
+	err = testee.Delete(ctx, "", resource.K8sResourceRefList{ns}, client.DeleteOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 0, len(testee.Objects()), "objects after namespace deletion")
+}
+
+func TestClusterWatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	testee := New(Options{DeploymentDelay: 50 * time.Millisecond})
+	deployment := testResource("apps/v1", "Deployment", "", "mydeployment", nil)
+	evts := testee.Watch(ctx, "deployments.v1.apps", "", nil, false)
+	_, err := testee.Apply(ctx, "", resource.K8sResourceList{deployment}, client.ApplyOptions{})
+	require.NoError(t, err)
+	var available []string
+	for evt := range evts {
+		require.NoError(t, evt.Error)
+		require.Equal(t, "mydeployment", evt.Resource.Name())
+		for _, c := range evt.Resource.Conditions() {
+			if c.Type == "available" {
+				available = append(available, c.Reason)
+				if c.Status {
+					cancel()
+				}
+			}
+		}
+	}
+	require.Equal(t, []string{"MinimumReplicasUnavailable", "MinimumReplicasAvailable"}, available, "available condition reasons")
+
+	// deletion
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ns := testResource("v1", "Namespace", "", "myns", nil)
+	cm := testResource("v1", "ConfigMap", "myns", "mycm", nil)
+	_, err = testee.Apply(ctx, "", resource.K8sResourceList{ns, cm}, client.ApplyOptions{})
+	require.NoError(t, err)
+	evts = testee.Watch(ctx, "configmaps", "myns", nil, true)
+	err = testee.Delete(ctx, "", resource.K8sResourceList{ns}.Refs(), client.DeleteOptions{})
+	require.NoError(t, err)
+	evt := <-evts
+	require.NoError(t, evt.Error)
+	require.Equal(t, cm.ID(), evt.Resource.ID(), "deleted object")
+	deletionTimestamp, _, _ := unstructured.NestedString(evt.Resource.Raw(), "metadata", "deletionTimestamp")
+	require.NotEmpty(t, deletionTimestamp, "deletionTimestamp of deleted object")
+}
+
+func TestClusterPackageManager(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	testee := New(Options{DeploymentDelay: 50 * time.Millisecond, JobDelay: 100 * time.Millisecond})
+	pkg := &k8spkg.K8sPackage{Name: "mypkg", Resources: resource.K8sResourceList{
+		testResource("apps/v1", "Deployment", "", "mydeployment", nil),
+		testResource("batch/v1", "Job", "", "myjob", nil),
+	}}
+	manager := k8spkg.NewPackageManager(testee, "myns")
+	err := manager.Apply(ctx, pkg, client.ApplyOptions{})
+	require.NoError(t, err, "apply")
+	_, err = testee.GetResource(ctx, "application.k8spkg.mgoltzsche.github.com", "myns", "mypkg")
+	require.NoError(t, err, "get Application")
+	err = manager.Delete(ctx, "mypkg", client.DeleteOptions{})
+	require.NoError(t, err, "delete")
+	require.Equal(t, 0, len(testee.Objects()), "objects after package deletion")
+}
+
+func TestClusterPackageManagerLegacyGroup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	types := append(DefaultResourceTypes(), apiType("deployments", "Deployment", "extensions", "v1beta1", true, "deploy"))
+	testee := New(Options{ResourceTypes: types, DeploymentDelay: 50 * time.Millisecond})
+	manager := k8spkg.NewPackageManager(testee, "myns")
+	deployment := func(apiVersion string) *resource.K8sResource {
+		res := testResource(apiVersion, "Deployment", "", "mydeployment", map[string]interface{}{k8spkg.PKG_NAME_LABEL: "mypkg"})
+		res.Raw()["spec"] = map[string]interface{}{"replicas": float64(1)}
+		return res
+	}
+	legacy := &k8spkg.K8sPackage{Name: "mypkg", Resources: resource.K8sResourceList{deployment("extensions/v1beta1")}}
+	err := manager.Apply(ctx, legacy, client.ApplyOptions{})
+	require.NoError(t, err, "apply legacy group")
+	migrated := &k8spkg.K8sPackage{Name: "mypkg", Resources: resource.K8sResourceList{deployment("apps/v1")}}
+	err = manager.Apply(ctx, migrated, client.ApplyOptions{Prune: true})
+	require.NoError(t, err, "apply migrated group with prune")
+	res, err := testee.GetResource(ctx, "deployment.extensions", "myns", "mydeployment")
+	require.NoError(t, err, "deployment should not be pruned")
+	require.Equal(t, "extensions/v1beta1", res.APIVersion(), "apiVersion of the object served within the legacy group")
+	err = manager.Delete(ctx, "mypkg", client.DeleteOptions{})
+	require.NoError(t, err, "delete")
+	require.Equal(t, 0, len(testee.Objects()), "objects after package deletion")
+}
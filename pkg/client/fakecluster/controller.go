@@ -0,0 +1,141 @@
+package fakecluster
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// reconcile simulates the controller of the object's kind (if any).
+// The initial status is set immediately, the final status after the configured delay.
+func (c *Cluster) reconcile(key string, obj map[string]interface{}) {
+	res := resource.FromMap(obj)
+	switch res.GroupKind() {
+	case "deployment.apps":
+		replicas := float64(1)
+		if r, found, _ := unstructured.NestedFloat64(obj, "spec", "replicas"); found {
+			replicas = r
+		}
+		c.progress(key, obj, c.opts.DeploymentDelay, func(status map[string]interface{}, gen float64) {
+			status["observedGeneration"] = gen
+			status["replicas"] = replicas
+			status["updatedReplicas"] = replicas
+			status["readyReplicas"] = float64(0)
+			status["availableReplicas"] = float64(0)
+			status["conditions"] = []interface{}{condition("Available", false, "MinimumReplicasUnavailable")}
+		}, func(status map[string]interface{}, gen float64) {
+			status["readyReplicas"] = replicas
+			status["availableReplicas"] = replicas
+			status["conditions"] = []interface{}{condition("Available", true, "MinimumReplicasAvailable")}
+		})
+	case "job.batch":
+		c.progress(key, obj, c.opts.JobDelay, func(status map[string]interface{}, gen float64) {
+			status["active"] = float64(1)
+		}, func(status map[string]interface{}, gen float64) {
+			delete(status, "active")
+			status["succeeded"] = float64(1)
+			status["conditions"] = []interface{}{condition("Complete", true, "")}
+		})
+	case "pod":
+		c.progress(key, obj, c.opts.PodDelay, func(status map[string]interface{}, gen float64) {
+			status["phase"] = "Pending"
+			status["conditions"] = []interface{}{condition("Ready", false, "ContainersNotReady")}
+		}, func(status map[string]interface{}, gen float64) {
+			status["phase"] = "Running"
+			status["conditions"] = []interface{}{condition("Ready", true, "")}
+		})
+	case "customresourcedefinition.apiextensions.k8s.io":
+		c.registerType(obj)
+		c.progress(key, obj, 0, nil, func(status map[string]interface{}, gen float64) {
+			status["conditions"] = []interface{}{condition("Established", true, "InitialNamesAccepted")}
+		})
+	}
+}
+
+// progress updates the object's status using the initial function immediately
+// and using the final function after the delay unless the object changed meanwhile
+func (c *Cluster) progress(key string, obj map[string]interface{}, delay time.Duration, initial, final func(status map[string]interface{}, generation float64)) {
+	gen := generation(obj)
+	if initial != nil {
+		c.updateStatus(key, gen, initial)
+	}
+	if delay <= 0 {
+		c.updateStatus(key, gen, final)
+		return
+	}
+	time.AfterFunc(delay, func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.updateStatus(key, gen, final)
+	})
+}
+
+// updateStatus modifies the status of the object if it has still the provided generation
+func (c *Cluster) updateStatus(key string, gen float64, update func(status map[string]interface{}, generation float64)) {
+	current := c.objects[key]
+	if current == nil || generation(current) != gen {
+		return
+	}
+	obj := copyObject(current)
+	status, ok := obj["status"].(map[string]interface{})
+	if !ok {
+		status = map[string]interface{}{}
+		obj["status"] = status
+	}
+	update(status, gen)
+	c.put(key, obj)
+}
+
+// registerType makes the cluster serve the type a CustomResourceDefinition defines
+func (c *Cluster) registerType(crd map[string]interface{}) {
+	group, _, _ := unstructured.NestedString(crd, "spec", "group")
+	kind, _, _ := unstructured.NestedString(crd, "spec", "names", "kind")
+	plural, _, _ := unstructured.NestedString(crd, "spec", "names", "plural")
+	shortNames, _, _ := unstructured.NestedStringSlice(crd, "spec", "names", "shortNames")
+	scope, _, _ := unstructured.NestedString(crd, "spec", "scope")
+	var versions []string
+	if v, _, _ := unstructured.NestedString(crd, "spec", "version"); v != "" {
+		versions = append(versions, v)
+	}
+	list, _, _ := unstructured.NestedSlice(crd, "spec", "versions")
+	for _, entry := range list {
+		if v, ok := entry.(map[string]interface{}); ok {
+			if name, _ := v["name"].(string); name != "" && (len(versions) == 0 || versions[0] != name) {
+				versions = append(versions, name)
+			}
+		}
+	}
+	if group == "" || kind == "" || len(versions) == 0 {
+		return
+	}
+	if plural == "" {
+		plural = strings.ToLower(kind) + "s"
+	}
+	t := apiType(plural, kind, group, versions[0], scope != "Cluster", shortNames...)
+	t.Versions = versions
+	for i, existing := range c.types {
+		if existing.APIGroup == group && existing.Kind == kind {
+			c.types[i] = t
+			return
+		}
+	}
+	c.types = append(c.types, t)
+}
+
+func condition(conditionType string, status bool, reason string) map[string]interface{} {
+	s := "False"
+	if status {
+		s = "True"
+	}
+	cond := map[string]interface{}{
+		"type":               conditionType,
+		"status":             s,
+		"lastTransitionTime": time.Now().UTC().Format(time.RFC3339),
+	}
+	if reason != "" {
+		cond["reason"] = reason
+	}
+	return cond
+}
@@ -0,0 +1,79 @@
+package fakecluster
+
+import (
+	"strings"
+
+	"github.com/mgoltzsche/k8spkg/pkg/client"
+)
+
+var defaultVerbs = []string{"create", "delete", "deletecollection", "get", "list", "patch", "update", "watch"}
+
+// DefaultResourceTypes returns the API resource types a new Cluster serves by default:
+// common built-in types and the k8spkg Application type.
+// Further types can be registered by applying a CustomResourceDefinition.
+func DefaultResourceTypes() []*client.APIResourceType {
+	return []*client.APIResourceType{
+		apiType("configmaps", "ConfigMap", "", "v1", true, "cm"),
+		apiType("endpoints", "Endpoints", "", "v1", true, "ep"),
+		apiType("events", "Event", "", "v1", true, "ev"),
+		apiType("namespaces", "Namespace", "", "v1", false, "ns"),
+		apiType("persistentvolumeclaims", "PersistentVolumeClaim", "", "v1", true, "pvc"),
+		apiType("persistentvolumes", "PersistentVolume", "", "v1", false, "pv"),
+		apiType("pods", "Pod", "", "v1", true, "po"),
+		apiType("secrets", "Secret", "", "v1", true),
+		apiType("serviceaccounts", "ServiceAccount", "", "v1", true, "sa"),
+		apiType("services", "Service", "", "v1", true, "svc"),
+		apiType("customresourcedefinitions", "CustomResourceDefinition", "apiextensions.k8s.io", "v1beta1", false, "crd", "crds"),
+		apiType("apiservices", "APIService", "apiregistration.k8s.io", "v1", false),
+		apiType("daemonsets", "DaemonSet", "apps", "v1", true, "ds"),
+		apiType("deployments", "Deployment", "apps", "v1", true, "deploy"),
+		apiType("replicasets", "ReplicaSet", "apps", "v1", true, "rs"),
+		apiType("statefulsets", "StatefulSet", "apps", "v1", true, "sts"),
+		apiType("cronjobs", "CronJob", "batch", "v1beta1", true, "cj"),
+		apiType("jobs", "Job", "batch", "v1", true),
+		apiType("ingresses", "Ingress", "networking.k8s.io", "v1beta1", true, "ing"),
+		apiType("clusterrolebindings", "ClusterRoleBinding", "rbac.authorization.k8s.io", "v1", false),
+		apiType("clusterroles", "ClusterRole", "rbac.authorization.k8s.io", "v1", false),
+		apiType("rolebindings", "RoleBinding", "rbac.authorization.k8s.io", "v1", true),
+		apiType("roles", "Role", "rbac.authorization.k8s.io", "v1", true),
+		apiType("applications", "Application", "k8spkg.mgoltzsche.github.com", "v1alpha1", true),
+	}
+}
+
+func apiType(name, kind, group, version string, namespaced bool, shortNames ...string) *client.APIResourceType {
+	return &client.APIResourceType{
+		Name:       name,
+		ShortNames: shortNames,
+		APIGroup:   group,
+		Kind:       kind,
+		Namespaced: namespaced,
+		Version:    version,
+		Versions:   []string{version},
+		Verbs:      defaultVerbs,
+	}
+}
+
+// matchesType returns true if the kind argument refers to the type.
+// Like kubectl it accepts the kind, plural or short name, optionally
+// suffixed with the API group or version and API group.
+func matchesType(t *client.APIResourceType, kind string) bool {
+	kind = strings.ToLower(kind)
+	if kind == strings.ToLower(t.QualifiedName()) {
+		return true
+	}
+	names := append([]string{strings.ToLower(t.Kind), t.Name}, t.ShortNames...)
+	for _, name := range names {
+		if kind == name || t.APIGroup != "" && kind == name+"."+t.APIGroup {
+			return true
+		}
+	}
+	return false
+}
+
+// apiGroup returns the API group of an apiVersion
+func apiGroup(apiVersion string) string {
+	if gv := strings.SplitN(apiVersion, "/", 2); len(gv) == 2 {
+		return gv[0]
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+package fakecluster
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+)
+
+// watcher queues the changed objects that match a watch so that
+// a slow consumer does not block the cluster
+type watcher struct {
+	match  func(map[string]interface{}) map[string]interface{}
+	queue  []map[string]interface{}
+	signal chan struct{}
+	mutex  sync.Mutex
+}
+
+func (w *watcher) enqueue(obj map[string]interface{}) {
+	w.mutex.Lock()
+	w.queue = append(w.queue, obj)
+	w.mutex.Unlock()
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (w *watcher) dequeue() (objs []map[string]interface{}) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	objs, w.queue = w.queue, nil
+	return
+}
+
+// notify enqueues the changed object for all matching watches
+func (c *Cluster) notify(obj map[string]interface{}) {
+	for w := range c.watchers {
+		if o := w.match(obj); o != nil {
+			w.enqueue(o)
+		}
+	}
+}
+
+// Watch emits the matching objects (unless watchOnly) and then every change of
+// a matching object until the context is cancelled.
+// A deleted object is emitted with its last state and the deletionTimestamp set.
+func (c *Cluster) Watch(ctx context.Context, kind, namespace string, labels []string, watchOnly bool) <-chan resource.ResourceEvent {
+	ch := make(chan resource.ResourceEvent)
+	match, err := c.matcher(kind, namespace, labels)
+	if err != nil {
+		go func() {
+			ch <- resource.ResourceEvent{Error: err}
+			close(ch)
+		}()
+		return ch
+	}
+	w := &watcher{match: match, signal: make(chan struct{}, 1)}
+	c.mutex.Lock()
+	if !watchOnly {
+		for _, key := range c.sortedKeys() {
+			if obj := match(c.objects[key]); obj != nil {
+				w.enqueue(obj)
+			}
+		}
+	}
+	c.watchers[w] = true
+	c.mutex.Unlock()
+	go func() {
+		defer close(ch)
+		defer func() {
+			c.mutex.Lock()
+			delete(c.watchers, w)
+			c.mutex.Unlock()
+		}()
+		for {
+			for _, obj := range w.dequeue() {
+				select {
+				case ch <- resource.ResourceEvent{Resource: resource.FromMap(obj)}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-w.signal:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
@@ -91,7 +91,7 @@ func assertKubectlCalls(t *testing.T, expectedCalls []string, mockOutput []byte,
 		os.Unsetenv("K8SCLIENTTEST_ERROR")
 
 		// success case
-		c := NewK8sClient(kubeconfig)
+		c := NewK8sClient(Config{KubeconfigFile: kubeconfig})
 		err = testee(c)
 		require.NoError(t, err)
 		actualCalls, err := trackedKubectlCalls(kubectlCallFile)
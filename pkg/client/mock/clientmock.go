@@ -12,7 +12,9 @@ import (
 
 	"github.com/mgoltzsche/k8spkg/pkg/client"
 	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 func MockDataFile(file string) (mockOut []byte) {
@@ -39,7 +41,10 @@ type ClientMock struct {
 	MockResources   resource.K8sResourceList
 	MockWatchEvents []resource.ResourceEvent
 	MockTypes       []*client.APIResourceType
-	lock            sync.Mutex
+	MockContext     string
+	// all resources applied so far - emitted by Watch when no MockWatchEvents are provided
+	applied resource.K8sResourceList
+	lock    sync.Mutex
 }
 
 func NewClientMock() *ClientMock {
@@ -58,15 +63,39 @@ func requireContext(ctx context.Context) {
 	}
 }
 
-func (c *ClientMock) Apply(ctx context.Context, namespace string, resources resource.K8sResourceList, prune bool, labels []string) (r resource.K8sResourceList, err error) {
+func (c *ClientMock) Apply(ctx context.Context, namespace string, resources resource.K8sResourceList, opts client.ApplyOptions) (r client.ApplyResults, err error) {
 	requireContext(ctx)
-	c.call("apply %s/ %v %+v", namespace, prune, labels)
+	mode := ""
+	if opts.ServerSide {
+		mode = fmt.Sprintf(" serverside force=%v", opts.ForceConflicts)
+	}
+	if opts.DryRun != client.DryRunNone {
+		mode += fmt.Sprintf(" dryrun=%s", opts.DryRun)
+	}
+	c.call("apply %s/ %v %+v%s", namespace, opts.Prune, opts.Labels, mode)
+	c.lock.Lock()
 	c.Applied = resources
-	return resources, c.MockErr
+	c.applied = append(c.applied, resources...)
+	c.lock.Unlock()
+	if c.MockErr != nil {
+		return nil, c.MockErr
+	}
+	for _, res := range resources {
+		action := client.ActionCreated
+		if c.lookup(res.QualifiedKind(), namespace, res.Name()) != nil {
+			action = client.ActionConfigured
+		}
+		r = append(r, &client.ApplyResult{Resource: res, Action: action})
+	}
+	return
 }
-func (c *ClientMock) Delete(ctx context.Context, namespace string, resources resource.K8sResourceRefList) (err error) {
+func (c *ClientMock) Delete(ctx context.Context, namespace string, resources resource.K8sResourceRefList, opts client.DeleteOptions) (err error) {
 	requireContext(ctx)
-	c.call("delete %s/ %+v", namespace, resources.Names())
+	mode := ""
+	if opts.DryRun != client.DryRunNone {
+		mode = fmt.Sprintf(" dryrun=%s", opts.DryRun)
+	}
+	c.call("delete %s/ %+v%s", namespace, resources.Names(), mode)
 	return c.MockErr
 }
 func (c *ClientMock) AwaitDeletion(ctx context.Context, namespace string, resources resource.K8sResourceRefList) (err error) {
@@ -77,10 +106,26 @@ func (c *ClientMock) AwaitDeletion(ctx context.Context, namespace string, resour
 func (c *ClientMock) GetResource(ctx context.Context, kind, namespace, name string) (*resource.K8sResource, error) {
 	requireContext(ctx)
 	c.call("getresource %s/ %s %s", namespace, kind, name)
-	if c.MockResource == nil {
-		return nil, fmt.Errorf("mock cient: get resource: no mock resource specified")
+	res := c.lookup(kind, namespace, name)
+	if res == nil {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: strings.ToLower(kind)}, name)
 	}
-	return c.MockResource, c.MockErr
+	return res, c.MockErr
+}
+
+// lookup returns MockResource or the resource with the given kind and name within MockResources
+func (c *ClientMock) lookup(kind, namespace, name string) *resource.K8sResource {
+	if c.MockResource != nil {
+		return c.MockResource
+	}
+	kind = strings.ToLower(kind)
+	for _, res := range c.MockResources {
+		if (res.QualifiedKind() == kind || strings.ToLower(res.Kind()) == kind) && res.Name() == name &&
+			(namespace == "" || res.Namespace() == "" || res.Namespace() == namespace) {
+			return res
+		}
+	}
+	return nil
 }
 func (c *ClientMock) Get(ctx context.Context, kinds []string, namespace string, labels []string) <-chan resource.ResourceEvent {
 	requireContext(ctx)
@@ -104,9 +149,12 @@ func (c *ClientMock) Watch(ctx context.Context, kind, namespace string, labels [
 	c.call("watch %s/%s %+v %v", namespace, kind, labels, watchOnly)
 	watchEvents := c.MockWatchEvents
 	if len(watchEvents) == 0 {
-		for _, res := range c.Applied {
+		c.lock.Lock()
+		applied := c.applied
+		c.lock.Unlock()
+		for _, res := range applied {
 			// set positive status
-			m := (&unstructured.Unstructured{res.Raw()}).DeepCopy().Object
+			m := (&unstructured.Unstructured{Object: res.Raw()}).DeepCopy().Object
 			unstructured.SetNestedField(m, 3.0, "metadata", "generation")
 			unstructured.SetNestedField(m, 2.0, "spec", "replicas")
 			m["status"] = map[string]interface{}{
@@ -123,7 +171,7 @@ func (c *ClientMock) Watch(ctx context.Context, kind, namespace string, labels [
 				"numberReady":            2.0,
 			}
 			res = resource.FromMap(m)
-			watchEvents = append(watchEvents, resource.ResourceEvent{res, nil})
+			watchEvents = append(watchEvents, resource.ResourceEvent{Resource: res})
 		}
 	}
 	ch := make(chan resource.ResourceEvent)
@@ -140,6 +188,11 @@ func (c *ClientMock) ResourceTypes(ctx context.Context) (types []*client.APIReso
 	c.call("resourcetypes")
 	return c.MockTypes, c.MockErr
 }
+func (c *ClientMock) CurrentContext(ctx context.Context) (string, error) {
+	requireContext(ctx)
+	c.call("currentcontext")
+	return c.MockContext, c.MockErr
+}
 
 func (c *ClientMock) ContainerLogs(ctx context.Context, namespace, podName, containerName string, previous, follow bool, writer io.Writer) (err error) {
 	requireContext(ctx)
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// OpenAPIClient is implemented by clients that can fetch the cluster's OpenAPI schema
+type OpenAPIClient interface {
+	// OpenAPISchema returns the OpenAPI v2 document the API server serves
+	OpenAPISchema(ctx context.Context) ([]byte, error)
+}
+
+// OpenAPISchema returns the cluster's OpenAPI v2 document
+// or an error if the client doesn't support fetching it.
+func OpenAPISchema(ctx context.Context, c K8sClient) ([]byte, error) {
+	if oc, ok := c.(OpenAPIClient); ok {
+		return oc.OpenAPISchema(ctx)
+	}
+	return nil, errors.Errorf("openapi schema: not supported by client %T", c)
+}
+
+func (c *k8sClient) OpenAPISchema(ctx context.Context) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := kubectl(ctx, nil, &buf, &c.config, []string{"get", "--raw", "/openapi/v2"}); err != nil {
+		return nil, errors.Wrap(err, "openapi schema")
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *apiClient) OpenAPISchema(ctx context.Context) ([]byte, error) {
+	api, err := c.conn()
+	if err != nil {
+		return nil, err
+	}
+	b, err := api.discovery.RESTClient().Get().AbsPath("/openapi/v2").Do().Raw()
+	return b, errors.Wrap(err, "openapi schema")
+}
+
+func (c *retryClient) OpenAPISchema(ctx context.Context) (b []byte, err error) {
+	err = c.retry(ctx, func() (e error) {
+		b, e = OpenAPISchema(ctx, c.K8sClient)
+		return
+	})
+	return
+}
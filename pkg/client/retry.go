@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// RetryPolicy specifies how often and when operations that failed with a
+// transient error (see IsTransient) are retried
+type RetryPolicy struct {
+	// MaxRetries is the maximum number of retries per operation - 0 disables retries
+	MaxRetries int
+	// InitialDelay is the delay before the first retry - doubled with every retry
+	InitialDelay time.Duration
+	// MaxDelay limits the delay between retries
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy retries an operation up to 5 times within about 15 seconds
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:   5,
+	InitialDelay: 500 * time.Millisecond,
+	MaxDelay:     8 * time.Second,
+}
+
+// retryClient decorates a K8sClient retrying operations that failed with a transient error.
+// Watches are not decorated since they resume on their own.
+type retryClient struct {
+	K8sClient
+	policy RetryPolicy
+	log    logrus.FieldLogger
+}
+
+// withRetry returns the client decorated with the retry policy unless it disables retries.
+// Retries are logged to the provided logger.
+func withRetry(c K8sClient, policy RetryPolicy, log logrus.FieldLogger) K8sClient {
+	if policy.MaxRetries <= 0 {
+		return c
+	}
+	return &retryClient{c, policy, log}
+}
+
+func (c *retryClient) retry(ctx context.Context, op func() error) (err error) {
+	delay := c.policy.InitialDelay
+	for i := 0; ; i++ {
+		if err = op(); err == nil || !IsTransient(err) || i >= c.policy.MaxRetries || ctx.Err() != nil {
+			return
+		}
+		wait := delay
+		if seconds, ok := apierrors.SuggestsClientDelay(err); ok && time.Duration(seconds)*time.Second > wait {
+			wait = time.Duration(seconds) * time.Second
+		}
+		c.log.Warnf("%s - retrying in %s", err, wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		if delay *= 2; delay > c.policy.MaxDelay {
+			delay = c.policy.MaxDelay
+		}
+	}
+}
+
+func (c *retryClient) Apply(ctx context.Context, namespace string, resources resource.K8sResourceList, opts ApplyOptions) (results ApplyResults, err error) {
+	err = c.retry(ctx, func() (e error) {
+		results, e = c.K8sClient.Apply(ctx, namespace, resources, opts)
+		return
+	})
+	return
+}
+
+func (c *retryClient) Delete(ctx context.Context, namespace string, resources resource.K8sResourceRefList, opts DeleteOptions) error {
+	return c.retry(ctx, func() error {
+		return c.K8sClient.Delete(ctx, namespace, resources, opts)
+	})
+}
+
+func (c *retryClient) GetResource(ctx context.Context, kind, namespace, name string) (r *resource.K8sResource, err error) {
+	err = c.retry(ctx, func() (e error) {
+		r, e = c.K8sClient.GetResource(ctx, kind, namespace, name)
+		return
+	})
+	return
+}
+
+// Get retries the operation only when it failed before any resource has been emitted
+func (c *retryClient) Get(ctx context.Context, kinds []string, namespace string, labels []string) <-chan resource.ResourceEvent {
+	ch := make(chan resource.ResourceEvent)
+	go func() {
+		defer close(ch)
+		err := c.retry(ctx, func() (err error) {
+			emitted := false
+			for evt := range c.K8sClient.Get(ctx, kinds, namespace, labels) {
+				if err != nil {
+					continue // drain failed attempt
+				}
+				if evt.Error != nil && !emitted && IsTransient(evt.Error) {
+					err = evt.Error
+					continue
+				}
+				emitted = true
+				emit(ctx, ch, evt)
+			}
+			return
+		})
+		if err != nil {
+			emit(ctx, ch, resource.ResourceEvent{Error: err})
+		}
+	}()
+	return ch
+}
+
+func (c *retryClient) AwaitDeletion(ctx context.Context, namespace string, resources resource.K8sResourceRefList) error {
+	return c.retry(ctx, func() error {
+		return c.K8sClient.AwaitDeletion(ctx, namespace, resources)
+	})
+}
+
+func (c *retryClient) ResourceTypes(ctx context.Context) (types []*APIResourceType, err error) {
+	err = c.retry(ctx, func() (e error) {
+		types, e = c.K8sClient.ResourceTypes(ctx)
+		return
+	})
+	return
+}
+
+func (c *retryClient) CurrentContext(ctx context.Context) (kubeContext string, err error) {
+	err = c.retry(ctx, func() (e error) {
+		kubeContext, e = c.K8sClient.CurrentContext(ctx)
+		return
+	})
+	return
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// failingClient fails the first calls with the provided errors
+type failingClient struct {
+	K8sClient
+	errs  []error
+	calls int
+}
+
+func (c *failingClient) err() (err error) {
+	if c.calls < len(c.errs) {
+		err = c.errs[c.calls]
+	}
+	c.calls++
+	return
+}
+
+func (c *failingClient) Apply(ctx context.Context, namespace string, resources resource.K8sResourceList, opts ApplyOptions) (ApplyResults, error) {
+	return nil, c.err()
+}
+
+func (c *failingClient) Get(ctx context.Context, kinds []string, namespace string, labels []string) <-chan resource.ResourceEvent {
+	ch := make(chan resource.ResourceEvent, 2)
+	if err := c.err(); err != nil {
+		ch <- resource.ResourceEvent{Error: err}
+	} else {
+		ch <- resource.ResourceEvent{Resource: resource.FromMap(testObject("v1", "ConfigMap", "myns", "cm", nil).Object)}
+	}
+	close(ch)
+	return ch
+}
+
+func TestRetryClient(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 2, InitialDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+	throttled := apierrors.NewTooManyRequests("slow down", 0)
+	forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "configmaps"}, "cm", errors.New("denied"))
+	ctx := context.Background()
+
+	// retry transient errors
+	delegate := &failingClient{errs: []error{throttled, throttled}}
+	_, err := withRetry(delegate, policy, logrus.StandardLogger()).Apply(ctx, "", nil, ApplyOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 3, delegate.calls, "calls")
+
+	// fail after max retries
+	delegate = &failingClient{errs: []error{throttled, throttled, throttled}}
+	_, err = withRetry(delegate, policy, logrus.StandardLogger()).Apply(ctx, "", nil, ApplyOptions{})
+	require.True(t, IsThrottled(err), "should return last error")
+	require.Equal(t, 3, delegate.calls, "calls")
+
+	// don't retry permanent errors
+	delegate = &failingClient{errs: []error{forbidden}}
+	_, err = withRetry(delegate, policy, logrus.StandardLogger()).Apply(ctx, "", nil, ApplyOptions{})
+	require.True(t, IsForbidden(err), "should return permanent error")
+	require.Equal(t, 1, delegate.calls, "calls")
+
+	// retry streamed operation
+	delegate = &failingClient{errs: []error{throttled}}
+	var names []string
+	for evt := range withRetry(delegate, policy, logrus.StandardLogger()).Get(ctx, []string{"configmap"}, "myns", nil) {
+		require.NoError(t, evt.Error)
+		names = append(names, evt.Resource.Name())
+	}
+	require.Equal(t, []string{"cm"}, names, "retrieved")
+	require.Equal(t, 2, delegate.calls, "calls")
+
+	// stop emitting when the context is cancelled
+	cancelCtx, cancel := context.WithCancel(ctx)
+	delegate = &failingClient{}
+	evts := withRetry(delegate, policy, logrus.StandardLogger()).Get(cancelCtx, []string{"configmap"}, "myns", nil)
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	_, ok := <-evts
+	require.False(t, ok, "should close the channel without emitting after the context has been cancelled")
+
+	// disabled retries
+	delegate = &failingClient{}
+	require.True(t, delegate == withRetry(delegate, RetryPolicy{}, logrus.StandardLogger()), "should not decorate client when retries are disabled")
+}
@@ -0,0 +1,471 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// sessionEntry is a recorded K8sClient call or an event emitted by a call.
+// A session file contains one JSON encoded entry per line.
+// Calls that return a channel start a stream and each emitted event is
+// written as separate entry referring to the stream as soon as it is
+// received so that a session that is interrupted can still be replayed.
+type sessionEntry struct {
+	Call   string          `json:"call"`
+	Args   json.RawMessage `json:"args,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *sessionError   `json:"error,omitempty"`
+	Stream int             `json:"stream,omitempty"`
+	Event  *sessionEvent   `json:"event,omitempty"`
+	events []*sessionEvent
+	// cancelled is true if the stream's context has been cancelled before the stream ended
+	cancelled bool
+}
+
+type sessionEvent struct {
+	Resource map[string]interface{} `json:"resource,omitempty"`
+	Error    *sessionError          `json:"error,omitempty"`
+}
+
+type sessionApplyResult struct {
+	Resource map[string]interface{} `json:"resource"`
+	Action   ApplyAction            `json:"action"`
+	Error    *sessionError          `json:"error,omitempty"`
+}
+
+// sessionError is a recorded error that keeps the original error's reason
+type sessionError struct {
+	Message string      `json:"message"`
+	Reason  ErrorReason `json:"reason,omitempty"`
+}
+
+func (e *sessionError) Error() string {
+	return e.Message
+}
+
+func toSessionError(err error) *sessionError {
+	if err == nil {
+		return nil
+	}
+	return &sessionError{err.Error(), Reason(err)}
+}
+
+func fromSessionError(err *sessionError) error {
+	if err == nil {
+		return nil
+	}
+	return err
+}
+
+func rawResource(res *resource.K8sResource) map[string]interface{} {
+	if res == nil {
+		return nil
+	}
+	// Secret values are masked since sessions are meant to be shared
+	return resource.Redact(res.Raw())
+}
+
+func refIDs(refs resource.K8sResourceRefList) []string {
+	ids := make([]string, len(refs))
+	for i, ref := range refs {
+		ids[i] = ref.ID()
+	}
+	return ids
+}
+
+func sessionArgs(args map[string]interface{}) json.RawMessage {
+	b, err := json.Marshal(args)
+	if err != nil {
+		panic(errors.Wrap(err, "encode session call args"))
+	}
+	return b
+}
+
+func applyArgs(namespace string, resources resource.K8sResourceList, opts ApplyOptions) json.RawMessage {
+	raw := make([]map[string]interface{}, len(resources))
+	for i, res := range resources {
+		raw[i] = rawResource(res)
+	}
+	return sessionArgs(map[string]interface{}{"namespace": namespace, "resources": raw, "options": opts})
+}
+
+func deleteArgs(namespace string, refs resource.K8sResourceRefList, opts DeleteOptions) json.RawMessage {
+	return sessionArgs(map[string]interface{}{"namespace": namespace, "resources": refIDs(refs), "options": opts})
+}
+
+func getResourceArgs(kind, namespace, name string) json.RawMessage {
+	return sessionArgs(map[string]interface{}{"kind": kind, "namespace": namespace, "name": name})
+}
+
+func getListArgs(kinds []string, namespace string, labels []string) json.RawMessage {
+	return sessionArgs(map[string]interface{}{"kinds": kinds, "namespace": namespace, "labels": labels})
+}
+
+func watchArgs(kind, namespace string, labels []string, watchOnly bool) json.RawMessage {
+	return sessionArgs(map[string]interface{}{"kind": kind, "namespace": namespace, "labels": labels, "watchOnly": watchOnly})
+}
+
+func awaitDeletionArgs(namespace string, refs resource.K8sResourceRefList) json.RawMessage {
+	return sessionArgs(map[string]interface{}{"namespace": namespace, "resources": refIDs(refs)})
+}
+
+func containerLogsArgs(namespace, podName, containerName string, previous, follow bool) json.RawMessage {
+	return sessionArgs(map[string]interface{}{"namespace": namespace, "pod": podName, "container": containerName, "previous": previous, "follow": follow})
+}
+
+// recordingClient decorates a K8sClient writing every call with its
+// arguments, results, emitted events and error into a session file
+// that can be replayed using NewReplayClient.
+type recordingClient struct {
+	delegate K8sClient
+	writer   io.Writer
+	err      error
+	streams  int
+	mutex    sync.Mutex
+}
+
+// NewRecordingClient returns a client that records all calls to the
+// delegate into the writer.
+// Every call is written as soon as it completed.
+// When an entry cannot be written the error is logged and the recording
+// stops while the calls are still delegated.
+func NewRecordingClient(delegate K8sClient, writer io.Writer) K8sClient {
+	return &recordingClient{delegate: delegate, writer: writer}
+}
+
+func (c *recordingClient) record(call string, args json.RawMessage, result interface{}, err error) {
+	entry := &sessionEntry{Call: call, Args: args, Error: toSessionError(err)}
+	if result != nil {
+		b, e := json.Marshal(result)
+		if e != nil {
+			c.fail(errors.Wrapf(e, "encode %s session call result", call))
+			return
+		}
+		entry.Result = b
+	}
+	c.write(entry)
+}
+
+func (c *recordingClient) write(entry *sessionEntry) {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		c.fail(errors.Wrapf(err, "encode %s session entry", entry.Call))
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err != nil {
+		return
+	}
+	if _, err = c.writer.Write(append(b, '\n')); err != nil {
+		c.failLocked(err)
+	}
+}
+
+// fail stops the recording
+func (c *recordingClient) fail(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err == nil {
+		c.failLocked(err)
+	}
+}
+
+func (c *recordingClient) failLocked(err error) {
+	c.err = errors.Wrap(err, "record session")
+	logrus.Errorf("%s - stopped recording, the session is incomplete", c.err)
+}
+
+// recordEvents starts a stream and records each event before it is forwarded.
+// Events are recorded once they have been delivered. When the context is
+// cancelled before the stream ended a "cancel" entry is recorded and the
+// remaining events are dropped.
+func (c *recordingClient) recordEvents(ctx context.Context, call string, args json.RawMessage, ch <-chan resource.ResourceEvent) <-chan resource.ResourceEvent {
+	c.mutex.Lock()
+	c.streams++
+	stream := c.streams
+	c.mutex.Unlock()
+	c.write(&sessionEntry{Call: call, Args: args, Stream: stream})
+	out := make(chan resource.ResourceEvent)
+	go func() {
+		defer close(out)
+		cancelled := false
+		for evt := range ch {
+			if cancelled {
+				continue
+			}
+			select {
+			case out <- evt:
+				c.write(&sessionEntry{Call: "event", Stream: stream, Event: &sessionEvent{rawResource(evt.Resource), toSessionError(evt.Error)}})
+			case <-ctx.Done():
+				cancelled = true
+			}
+		}
+		if cancelled || ctx.Err() != nil {
+			c.write(&sessionEntry{Call: "cancel", Stream: stream})
+		}
+	}()
+	return out
+}
+
+func (c *recordingClient) Apply(ctx context.Context, namespace string, resources resource.K8sResourceList, opts ApplyOptions) (ApplyResults, error) {
+	results, err := c.delegate.Apply(ctx, namespace, resources, opts)
+	recorded := make([]sessionApplyResult, len(results))
+	for i, r := range results {
+		recorded[i] = sessionApplyResult{rawResource(r.Resource), r.Action, toSessionError(r.Error)}
+	}
+	c.record("apply", applyArgs(namespace, resources, opts), recorded, err)
+	return results, err
+}
+
+func (c *recordingClient) Delete(ctx context.Context, namespace string, resources resource.K8sResourceRefList, opts DeleteOptions) error {
+	err := c.delegate.Delete(ctx, namespace, resources, opts)
+	c.record("delete", deleteArgs(namespace, resources, opts), nil, err)
+	return err
+}
+
+func (c *recordingClient) GetResource(ctx context.Context, kind string, namespace string, name string) (*resource.K8sResource, error) {
+	res, err := c.delegate.GetResource(ctx, kind, namespace, name)
+	c.record("getresource", getResourceArgs(kind, namespace, name), rawResource(res), err)
+	return res, err
+}
+
+func (c *recordingClient) Get(ctx context.Context, kinds []string, namespace string, labels []string) <-chan resource.ResourceEvent {
+	return c.recordEvents(ctx, "get", getListArgs(kinds, namespace, labels), c.delegate.Get(ctx, kinds, namespace, labels))
+}
+
+func (c *recordingClient) Watch(ctx context.Context, kind, namespace string, labels []string, watchOnly bool) <-chan resource.ResourceEvent {
+	return c.recordEvents(ctx, "watch", watchArgs(kind, namespace, labels, watchOnly), c.delegate.Watch(ctx, kind, namespace, labels, watchOnly))
+}
+
+func (c *recordingClient) AwaitDeletion(ctx context.Context, namespace string, resources resource.K8sResourceRefList) error {
+	err := c.delegate.AwaitDeletion(ctx, namespace, resources)
+	c.record("awaitdeletion", awaitDeletionArgs(namespace, resources), nil, err)
+	return err
+}
+
+func (c *recordingClient) ResourceTypes(ctx context.Context) ([]*APIResourceType, error) {
+	types, err := c.delegate.ResourceTypes(ctx)
+	c.record("resourcetypes", sessionArgs(nil), types, err)
+	return types, err
+}
+
+func (c *recordingClient) CurrentContext(ctx context.Context) (string, error) {
+	kubeContext, err := c.delegate.CurrentContext(ctx)
+	c.record("currentcontext", sessionArgs(nil), kubeContext, err)
+	return kubeContext, err
+}
+
+func (c *recordingClient) ContainerLogs(ctx context.Context, namespace, podName, containerName string, previous, follow bool, writer io.Writer) error {
+	var buf bytes.Buffer
+	err := c.delegate.ContainerLogs(ctx, namespace, podName, containerName, previous, follow, io.MultiWriter(writer, &buf))
+	c.record("logs", containerLogsArgs(namespace, podName, containerName, previous, follow), buf.String(), err)
+	return err
+}
+
+func (c *recordingClient) OpenAPISchema(ctx context.Context) ([]byte, error) {
+	b, err := OpenAPISchema(ctx, c.delegate)
+	c.record("openapischema", sessionArgs(nil), b, err)
+	return b, err
+}
+
+// replayClient serves the calls recorded within a session without a cluster.
+// Calls are matched by name and arguments - repeated calls with the same
+// arguments are served in the recorded order.
+type replayClient struct {
+	entries map[string][]*sessionEntry
+	mutex   sync.Mutex
+}
+
+// NewReplayClient returns a client that replays the session read from the reader
+func NewReplayClient(reader io.Reader) (K8sClient, error) {
+	c := &replayClient{entries: map[string][]*sessionEntry{}}
+	streams := map[int]*sessionEntry{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		entry := &sessionEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, errors.Wrapf(err, "read session: line %d", line)
+		}
+		if entry.Event != nil || entry.Call == "cancel" {
+			stream := streams[entry.Stream]
+			if stream == nil {
+				return nil, errors.Errorf("read session: line %d: %s of unknown stream %d", line, entry.Call, entry.Stream)
+			}
+			if entry.Event != nil {
+				stream.events = append(stream.events, entry.Event)
+			} else {
+				stream.cancelled = true
+			}
+			continue
+		}
+		if entry.Stream > 0 {
+			streams[entry.Stream] = entry
+		}
+		key := replayKey(entry.Call, entry.Args)
+		c.entries[key] = append(c.entries[key], entry)
+	}
+	return c, errors.Wrap(scanner.Err(), "read session")
+}
+
+func replayKey(call string, args json.RawMessage) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, args); err != nil {
+		buf.Reset()
+		buf.Write(args)
+	}
+	return call + " " + buf.String()
+}
+
+// next returns the next recorded entry of the call with the given arguments
+func (c *replayClient) next(call string, args json.RawMessage) (*sessionEntry, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := replayKey(call, args)
+	entries := c.entries[key]
+	if len(entries) == 0 {
+		return nil, errors.Errorf("replay session: no recorded %s call with args %s", call, args)
+	}
+	c.entries[key] = entries[1:]
+	return entries[0], nil
+}
+
+// result decodes the entry's result into the provided value and returns the recorded error
+func (e *sessionEntry) result(o interface{}) error {
+	if len(e.Result) > 0 {
+		if err := json.Unmarshal(e.Result, o); err != nil {
+			return errors.Wrapf(err, "replay session: decode %s result", e.Call)
+		}
+	}
+	return fromSessionError(e.Error)
+}
+
+// replayEvents emits the recorded events until the context is cancelled.
+// A stream that has been cancelled during the recording is kept open
+// after its events have been emitted until the context is cancelled.
+func (c *replayClient) replayEvents(ctx context.Context, call string, args json.RawMessage) <-chan resource.ResourceEvent {
+	ch := make(chan resource.ResourceEvent)
+	go func() {
+		defer close(ch)
+		entry, err := c.next(call, args)
+		if err != nil {
+			emit(ctx, ch, resource.ResourceEvent{Error: err})
+			return
+		}
+		for _, evt := range entry.events {
+			var res *resource.K8sResource
+			if evt.Resource != nil {
+				res = resource.FromMap(evt.Resource)
+			}
+			if !emit(ctx, ch, resource.ResourceEvent{Resource: res, Error: fromSessionError(evt.Error)}) {
+				return
+			}
+		}
+		if entry.cancelled {
+			// the recorded stream did not end before its context has been cancelled
+			<-ctx.Done()
+		}
+	}()
+	return ch
+}
+
+func (c *replayClient) Apply(ctx context.Context, namespace string, resources resource.K8sResourceList, opts ApplyOptions) (results ApplyResults, err error) {
+	entry, err := c.next("apply", applyArgs(namespace, resources, opts))
+	if err != nil {
+		return
+	}
+	var recorded []sessionApplyResult
+	err = entry.result(&recorded)
+	for _, r := range recorded {
+		results = append(results, &ApplyResult{resource.FromMap(r.Resource), r.Action, fromSessionError(r.Error)})
+	}
+	return
+}
+
+func (c *replayClient) Delete(ctx context.Context, namespace string, resources resource.K8sResourceRefList, opts DeleteOptions) error {
+	entry, err := c.next("delete", deleteArgs(namespace, resources, opts))
+	if err != nil {
+		return err
+	}
+	return entry.result(nil)
+}
+
+func (c *replayClient) GetResource(ctx context.Context, kind string, namespace string, name string) (res *resource.K8sResource, err error) {
+	entry, err := c.next("getresource", getResourceArgs(kind, namespace, name))
+	if err != nil {
+		return
+	}
+	var raw map[string]interface{}
+	if err = entry.result(&raw); raw != nil {
+		res = resource.FromMap(raw)
+	}
+	return
+}
+
+func (c *replayClient) Get(ctx context.Context, kinds []string, namespace string, labels []string) <-chan resource.ResourceEvent {
+	return c.replayEvents(ctx, "get", getListArgs(kinds, namespace, labels))
+}
+
+func (c *replayClient) Watch(ctx context.Context, kind, namespace string, labels []string, watchOnly bool) <-chan resource.ResourceEvent {
+	return c.replayEvents(ctx, "watch", watchArgs(kind, namespace, labels, watchOnly))
+}
+
+func (c *replayClient) AwaitDeletion(ctx context.Context, namespace string, resources resource.K8sResourceRefList) error {
+	entry, err := c.next("awaitdeletion", awaitDeletionArgs(namespace, resources))
+	if err != nil {
+		return err
+	}
+	return entry.result(nil)
+}
+
+func (c *replayClient) ResourceTypes(ctx context.Context) (types []*APIResourceType, err error) {
+	entry, err := c.next("resourcetypes", sessionArgs(nil))
+	if err != nil {
+		return
+	}
+	err = entry.result(&types)
+	return
+}
+
+func (c *replayClient) CurrentContext(ctx context.Context) (kubeContext string, err error) {
+	entry, err := c.next("currentcontext", sessionArgs(nil))
+	if err != nil {
+		return
+	}
+	err = entry.result(&kubeContext)
+	return
+}
+
+func (c *replayClient) OpenAPISchema(ctx context.Context) (b []byte, err error) {
+	entry, err := c.next("openapischema", sessionArgs(nil))
+	if err != nil {
+		return
+	}
+	err = entry.result(&b)
+	return
+}
+
+func (c *replayClient) ContainerLogs(ctx context.Context, namespace, podName, containerName string, previous, follow bool, writer io.Writer) (err error) {
+	entry, err := c.next("logs", containerLogsArgs(namespace, podName, containerName, previous, follow))
+	if err != nil {
+		return
+	}
+	var logs string
+	err = entry.result(&logs)
+	if _, e := io.WriteString(writer, logs); e != nil && err == nil {
+		err = e
+	}
+	return
+}
@@ -0,0 +1,155 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// sessionTestClient answers calls with fixed results
+type sessionTestClient struct {
+	K8sClient
+	watchEvents []resource.ResourceEvent
+}
+
+func (c *sessionTestClient) Apply(ctx context.Context, namespace string, resources resource.K8sResourceList, opts ApplyOptions) (results ApplyResults, err error) {
+	for _, res := range resources {
+		results = append(results, &ApplyResult{Resource: res, Action: ActionCreated})
+	}
+	return
+}
+
+func (c *sessionTestClient) GetResource(ctx context.Context, kind string, namespace string, name string) (*resource.K8sResource, error) {
+	return nil, apierrors.NewNotFound(schema.GroupResource{Resource: kind}, name)
+}
+
+func (c *sessionTestClient) Watch(ctx context.Context, kind, namespace string, labels []string, watchOnly bool) <-chan resource.ResourceEvent {
+	ch := make(chan resource.ResourceEvent)
+	go func() {
+		for _, evt := range c.watchEvents {
+			ch <- evt
+		}
+		close(ch)
+	}()
+	return ch
+}
+
+func (c *sessionTestClient) OpenAPISchema(ctx context.Context) ([]byte, error) {
+	return []byte(`{"swagger":"2.0"}`), nil
+}
+
+func TestRecordReplaySession(t *testing.T) {
+	ctx := context.Background()
+	pod := resource.FromMap(map[string]interface{}{"apiVersion": "v1", "kind": "Pod", "metadata": map[string]interface{}{"name": "mypod", "namespace": "myns"}})
+	delegate := &sessionTestClient{watchEvents: []resource.ResourceEvent{{Resource: pod}, {Error: apierrors.NewTooManyRequests("throttled", 1)}}}
+	var session bytes.Buffer
+	testee := NewRecordingClient(delegate, &session)
+	runSession := func(c K8sClient) (results ApplyResults, getErr error, events []resource.ResourceEvent) {
+		results, err := c.Apply(ctx, "myns", resource.K8sResourceList{pod}, ApplyOptions{Prune: true})
+		require.NoError(t, err, "apply")
+		_, getErr = c.GetResource(ctx, "pod", "myns", "mypod")
+		for evt := range c.Watch(ctx, "pod", "myns", nil, false) {
+			events = append(events, evt)
+		}
+		doc, err := OpenAPISchema(ctx, c)
+		require.NoError(t, err, "openapi schema")
+		require.Equal(t, `{"swagger":"2.0"}`, string(doc), "openapi schema")
+		return
+	}
+	results, getErr, events := runSession(testee)
+	require.Equal(t, 2, len(events), "recorded events")
+
+	replay, err := NewReplayClient(bytes.NewReader(session.Bytes()))
+	require.NoError(t, err, "read session")
+	rResults, rGetErr, rEvents := runSession(replay)
+	require.Equal(t, results.Resources().Refs().Names(), rResults.Resources().Refs().Names(), "replayed apply results")
+	require.Equal(t, ActionCreated, rResults[0].Action, "replayed apply action")
+	require.True(t, IsNotFound(rGetErr), "replayed error should keep its reason")
+	require.Equal(t, getErr.Error(), rGetErr.Error(), "replayed error message")
+	require.Equal(t, 2, len(rEvents), "replayed events")
+	require.Equal(t, pod.ID(), rEvents[0].Resource.ID(), "replayed resource event")
+	require.True(t, IsThrottled(rEvents[1].Error), "replayed error event should keep its reason")
+
+	_, err = replay.GetResource(ctx, "pod", "myns", "mypod")
+	require.Error(t, err, "replay of call that has not been recorded as often")
+	require.False(t, IsNotFound(err), "replay of call that has not been recorded as often")
+	_, err = replay.Apply(ctx, "otherns", resource.K8sResourceList{pod}, ApplyOptions{})
+	require.Error(t, err, "replay of call with different args")
+}
+
+// failingWriter fails every write after the first n writes
+type failingWriter struct {
+	n      int
+	writes int
+}
+
+func (w *failingWriter) Write(b []byte) (int, error) {
+	if w.writes++; w.writes > w.n {
+		return 0, errors.New("no space left on device")
+	}
+	return len(b), nil
+}
+
+func TestRecordSessionWriteError(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+	ctx := context.Background()
+	pod := resource.FromMap(map[string]interface{}{"apiVersion": "v1", "kind": "Pod", "metadata": map[string]interface{}{"name": "mypod", "namespace": "myns"}})
+	writer := &failingWriter{n: 1}
+	testee := NewRecordingClient(&sessionTestClient{}, writer)
+	for i := 0; i < 3; i++ {
+		results, err := testee.Apply(ctx, "myns", resource.K8sResourceList{pod}, ApplyOptions{})
+		require.NoError(t, err, "apply should succeed when the session cannot be written")
+		require.Equal(t, 1, len(results), "apply results")
+	}
+	require.Equal(t, 2, writer.writes, "writes after first error")
+	require.Equal(t, 1, len(hook.AllEntries()), "logged errors")
+	require.Contains(t, hook.LastEntry().Message, "no space left on device")
+}
+
+func TestRecordReplaySessionCancelledStream(t *testing.T) {
+	pod := func(name string) *resource.K8sResource {
+		return resource.FromMap(map[string]interface{}{"apiVersion": "v1", "kind": "Pod", "metadata": map[string]interface{}{"name": name, "namespace": "myns"}})
+	}
+	delegate := &sessionTestClient{watchEvents: []resource.ResourceEvent{{Resource: pod("a")}, {Resource: pod("b")}, {Resource: pod("c")}}}
+	var session bytes.Buffer
+	testee := NewRecordingClient(delegate, &session)
+	ctx, cancel := context.WithCancel(context.Background())
+	evts := testee.Watch(ctx, "pod", "myns", nil, false)
+	require.Equal(t, "a", (<-evts).Resource.Name(), "recorded event")
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	for range evts {
+	}
+	require.Contains(t, session.String(), `"call":"cancel"`, "cancellation marker")
+	require.NotContains(t, session.String(), `"name":"b"`, "undelivered event should not be recorded")
+
+	replay, err := NewReplayClient(bytes.NewReader(session.Bytes()))
+	require.NoError(t, err, "read session")
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	evts = replay.Watch(ctx, "pod", "myns", nil, false)
+	require.Equal(t, "a", (<-evts).Resource.Name(), "replayed event")
+	select {
+	case evt, ok := <-evts:
+		t.Fatalf("replayed cancelled stream should stay open until cancelled but received %v (open: %v)", evt, ok)
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	_, ok := <-evts
+	require.False(t, ok, "replayed stream should be closed after cancellation")
+
+	// unrecorded call after cancellation
+	evts = replay.Watch(ctx, "pod", "otherns", nil, false)
+	time.Sleep(50 * time.Millisecond)
+	_, ok = <-evts
+	require.False(t, ok, "should not emit error after cancellation")
+}
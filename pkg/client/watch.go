@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// Number of consecutive failed watch attempts after which a watch fails
+	watchRetries = 5
+	// Initial delay before an interrupted watch is resumed - doubled after every attempt without progress
+	watchRetryInterval    = time.Second
+	maxWatchRetryInterval = 30 * time.Second
+)
+
+// resumeWatch runs the provided watch attempt repeatedly until the context is
+// cancelled so that a watch survives interrupted streams.
+// An attempt returns true when it received any event which resets the backoff
+// and the failure counter.
+// The watch fails when watchRetries attempts failed in a row without progress
+// or immediately when an attempt failed with an error that is not resumable.
+func resumeWatch(ctx context.Context, log logrus.FieldLogger, attempt func() (progress bool, err error)) error {
+	failures := 0
+	delay := watchRetryInterval
+	for {
+		progress, err := attempt()
+		if ctx.Err() != nil {
+			return errors.WithStack(ctx.Err())
+		}
+		if progress {
+			failures = 0
+			delay = watchRetryInterval
+			if err == nil {
+				log.Debug("watch closed, resuming")
+				continue
+			}
+		}
+		if err != nil {
+			if failures++; failures > watchRetries || !isResumable(err) {
+				return err
+			}
+			log.Debugf("watch interrupted, resuming in %s: %s", delay, err)
+		}
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxWatchRetryInterval {
+			delay = maxWatchRetryInterval
+		}
+	}
+}
+
+// streamInterruptions are messages of errors that indicate an interrupted watch stream
+var streamInterruptions = []string{
+	"EOF",
+	"connection reset",
+	"broken pipe",
+	"stream error",
+	"GOAWAY",
+	"unable to decode an event from the watch stream",
+	"very short watch",
+}
+
+// isResumable returns true if a watch that failed with the error may succeed when resumed
+func isResumable(err error) bool {
+	if IsTransient(err) || isExpired(errors.Cause(err)) {
+		return true
+	}
+	msg := err.Error()
+	for _, s := range streamInterruptions {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// emit sends the event unless the context is cancelled before it is received.
+// Returns false if the context has been cancelled.
+func emit(ctx context.Context, ch chan<- resource.ResourceEvent, evt resource.ResourceEvent) bool {
+	select {
+	case ch <- evt:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// resourceVersions tracks the last seen resourceVersion per object
+// in order to drop objects that are replayed when a watch is resumed.
+type resourceVersions map[string]string
+
+// add records the resource's version and returns false if it has been seen before
+func (v resourceVersions) add(res *resource.K8sResource) bool {
+	meta, _ := res.Raw()["metadata"].(map[string]interface{})
+	rv, _ := meta["resourceVersion"].(string)
+	if rv == "" {
+		return true
+	}
+	key, _ := meta["uid"].(string)
+	if key == "" {
+		key = res.ID()
+	}
+	if v[key] == rv {
+		return false
+	}
+	v[key] = rv
+	return true
+}
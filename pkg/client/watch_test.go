@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestResumeWatch(t *testing.T) {
+	defer func(interval time.Duration) { watchRetryInterval = interval }(watchRetryInterval)
+	watchRetryInterval = time.Millisecond
+
+	// resume closed and failed watches until the context is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	attempts := 0
+	err := resumeWatch(ctx, logrus.StandardLogger(), func() (bool, error) {
+		if attempts++; attempts == 10 {
+			cancel()
+		}
+		if attempts%2 == 0 {
+			return false, fmt.Errorf("stream error")
+		}
+		return true, nil
+	})
+	require.Error(t, err)
+	require.Equal(t, context.Canceled, ctx.Err())
+	require.Equal(t, 10, attempts, "attempts")
+
+	// fail after too many attempts without progress
+	attempts = 0
+	err = resumeWatch(context.Background(), logrus.StandardLogger(), func() (bool, error) {
+		attempts++
+		return false, apierrors.NewTooManyRequests("persistent error", 1)
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "persistent error")
+	require.Equal(t, watchRetries+1, attempts, "attempts")
+
+	// fail immediately on permanent errors
+	for _, permanent := range []error{
+		apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", fmt.Errorf("denied")),
+		apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, "mypod"),
+		fmt.Errorf("the server doesn't have a resource type \"unknown\""),
+	} {
+		attempts = 0
+		err = resumeWatch(context.Background(), logrus.StandardLogger(), func() (bool, error) {
+			attempts++
+			return false, permanent
+		})
+		require.Error(t, err)
+		require.Equal(t, 1, attempts, "attempts on %q", permanent)
+	}
+}
+
+func TestResourceVersions(t *testing.T) {
+	testee := resourceVersions{}
+	res := func(uid, rv string) *resource.K8sResource {
+		o := testObject("v1", "ConfigMap", "myns", "cm", nil)
+		o.SetUID(types.UID("uid" + uid))
+		o.SetResourceVersion(rv)
+		return resource.FromMap(o.Object)
+	}
+	require.True(t, testee.add(res("1", "1")), "new object")
+	require.False(t, testee.add(res("1", "1")), "replayed object")
+	require.True(t, testee.add(res("1", "2")), "changed object")
+	require.True(t, testee.add(res("2", "2")), "recreated object")
+	require.True(t, testee.add(res("1", "")), "object without version")
+	require.True(t, testee.add(res("1", "")), "object without version")
+}
@@ -16,6 +16,9 @@ limitations under the License.
 package cmd
 
 import (
+	"github.com/mgoltzsche/k8spkg/pkg/client"
+	"github.com/mgoltzsche/k8spkg/pkg/k8spkg"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
@@ -26,19 +29,71 @@ var (
 		Long: `Installs or updates the provided source as package
 and waits for the rollout to complete`,
 		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if forceConflicts && !serverSide {
+				return errors.New("--force-conflicts requires --server-side")
+			}
+			dryRunMode, err := client.ParseDryRun(dryRun)
+			if err != nil {
+				return
+			}
+			contexts, err := targetContexts()
+			if err != nil {
+				return
+			}
+			sel, err := selection()
+			if err != nil {
+				return
+			}
+			schemas, err := schemaSource(applySchema)
+			if err != nil {
+				return
+			}
 			ctx := newContext()
 			pkg, err := sourcePackage(ctx)
 			if err != nil {
 				return
 			}
-			return pkgManager().Apply(ctx, pkg, prune)
+			opts := client.ApplyOptions{
+				Prune:          prune,
+				ServerSide:     serverSide,
+				ForceConflicts: forceConflicts,
+				DryRun:         dryRunMode,
+			}
+			apply := func(m *k8spkg.PackageManager) error {
+				m.SetBatchOptions(batchOpts)
+				m.SetCreateNamespaces(createNs)
+				m.SetSchemaSource(schemas)
+				m.SetSkipUnchanged(skipUnchanged)
+				m.SetSelection(sel)
+				return m.Apply(ctx, pkg, opts)
+			}
+			if len(contexts) > 0 {
+				return forEachContext(contexts, apply)
+			}
+			return apply(pkgManager())
 		},
 	}
-	prune bool
+	prune          bool
+	serverSide     bool
+	forceConflicts bool
+	createNs       bool
+	skipUnchanged  = true
+	batchOpts      = k8spkg.DefaultBatchOptions
 )
 
 func init() {
 	addSourceNameFlags(applyCmd.Flags())
+	addDryRunFlag(applyCmd.Flags())
+	addContextsFlags(applyCmd.Flags())
+	addSchemaFlag(applyCmd.Flags(), &applySchema, schemaNone)
+	addSelectorFlags(applyCmd.Flags())
+	addPatchFlags(applyCmd.Flags())
 	applyCmd.Flags().BoolVar(&prune, "prune", false, "Deletes all sources that belong to the provided package but were not present within the input")
+	applyCmd.Flags().BoolVar(&serverSide, "server-side", false, "Applies the resources using server-side apply with field manager "+client.FieldManager)
+	applyCmd.Flags().BoolVar(&forceConflicts, "force-conflicts", false, "Takes over the ownership of fields that are managed by another field manager (requires --server-side)")
+	applyCmd.Flags().BoolVar(&skipUnchanged, "skip-unchanged", true, "Skips the resources that did not change since the package has been applied successfully the last time")
+	applyCmd.Flags().BoolVar(&createNs, "create-namespace", false, "Creates the namespaces the package refers to if they do not exist and deletes them with the package")
+	applyCmd.Flags().IntVar(&batchOpts.Size, "batch-size", k8spkg.DefaultBatchOptions.Size, "Max number of resources of a kind that are applied at once (0 applies all resources of a kind at once)")
+	applyCmd.Flags().IntVar(&batchOpts.Concurrency, "concurrency", k8spkg.DefaultBatchOptions.Concurrency, "Max number of batches that are applied in parallel")
 	rootCmd.AddCommand(applyCmd)
 }
@@ -31,17 +31,33 @@ var (
 			if len(args) != 0 {
 				return fmt.Errorf("no arguments supported but provided %+v", args)
 			}
+			enc, err := outputEncoder()
+			if err != nil {
+				return
+			}
+			sel, err := selection()
+			if err != nil {
+				return
+			}
 			ctx := newContext()
 			pkg, err := sourcePackage(ctx)
 			if err != nil {
 				return
 			}
-			return pkg.Resources.WriteYaml(os.Stdout)
+			resources := pkg.Resources.Filter(sel.Match)
+			if !showSecrets {
+				resources = resources.Redacted()
+			}
+			return enc.Encode(os.Stdout, resources)
 		},
 	}
 )
 
 func init() {
 	addSourceNameFlags(buildCmd.Flags())
+	addShowSecretsFlag(buildCmd.Flags())
+	addOutputFlag(buildCmd.Flags())
+	addSelectorFlags(buildCmd.Flags())
+	addPatchFlags(buildCmd.Flags())
 	rootCmd.AddCommand(buildCmd)
 }
@@ -20,12 +20,14 @@ import (
 	"io"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/mgoltzsche/k8spkg/pkg/k8spkg"
 	"github.com/mgoltzsche/k8spkg/pkg/kustomize"
 	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/mgoltzsche/k8spkg/pkg/validate"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
@@ -39,6 +41,24 @@ var (
 	sourceFile         string
 	pkgName            string
 	enableAlphaPlugins bool
+	dryRun             string
+	schema             = schemaBuiltin
+	applySchema        = schemaNone
+	kubeVersion        = validate.BuiltinVersion
+	showSecrets        bool
+	output             = "yaml"
+	only               []string
+	exclude            []string
+	onDuplicate        = string(resource.DuplicateError)
+	patchFiles         []string
+	patchJSON          []string
+	patchType          = string(resource.PatchStrategicMerge)
+)
+
+const (
+	schemaBuiltin = "builtin"
+	schemaCluster = "cluster"
+	schemaNone    = "none"
 )
 
 func addRequestFlags(f *pflag.FlagSet) {
@@ -51,6 +71,90 @@ func addSourceFlags(f *pflag.FlagSet) {
 	f.StringVarP(&sourceFile, "file", "f", "", "Load manifest from file or URL")
 	f.StringVarP(&sourceKustomize, "kustomize", "k", "", "Load manifest from rendered kustomize source")
 	f.BoolVar(&enableAlphaPlugins, "enable_alpha_plugins", false, "enable kustomize plugins (alpha feature)")
+	f.StringVar(&onDuplicate, "on-duplicate", string(resource.DuplicateError), "Specifies how resources that occur multiple times within the source are handled. Must be \"error\", \"first\", \"last\" or \"merge\" (strategic merge onto the previous occurrence)")
+}
+
+func addDryRunFlag(f *pflag.FlagSet) {
+	f.StringVar(&dryRun, "dry-run", "", "Prints the resources that would be changed without changing them. Must be \"client\" or \"server\"")
+}
+
+func addShowSecretsFlag(f *pflag.FlagSet) {
+	f.BoolVar(&showSecrets, "show-secrets", false, "Prints the values of Secrets instead of masking them")
+}
+
+func addOutputFlag(f *pflag.FlagSet) {
+	f.StringVarP(&output, "output", "o", "yaml", "Output format. One of: "+strings.Join(resource.Formats(), "|"))
+}
+
+// outputEncoder returns the encoder of the output format.
+// The table format lists the package labels.
+func outputEncoder() (resource.Encoder, error) {
+	if output == "table" {
+		return resource.NewTableEncoder(
+			resource.TableColumn{Header: "PACKAGE", Label: k8spkg.PKG_NAME_LABEL},
+			resource.TableColumn{Header: "PACKAGE NAMESPACE", Label: k8spkg.PKG_NS_LABEL},
+		), nil
+	}
+	return resource.NewEncoder(output)
+}
+
+func addSelectorFlags(f *pflag.FlagSet) {
+	f.StringArrayVar(&only, "only", nil, "Selects the resources that match the selector, e.g. kind=Deployment,name=api-*. Keys other than kind, namespace and name match labels. May be specified multiple times")
+	f.StringArrayVar(&exclude, "exclude", nil, "Ignores the resources that match the selector (see --only). May be specified multiple times")
+}
+
+// selection returns the resource selection the --only and --exclude flags specify
+func selection() (*resource.Selection, error) {
+	return resource.ParseSelection(only, exclude)
+}
+
+func addPatchFlags(f *pflag.FlagSet) {
+	f.StringArrayVar(&patchFiles, "patch", nil, "Applies the patches within [SELECTOR:]FILE to the source's resources before they are labeled. A list is a JSON patch and an object is applied as --patch-type specifies. May be specified multiple times")
+	f.StringArrayVar(&patchJSON, "patch-json", nil, "Applies the JSON patch list or object patch (see --patch-type) to the resources that match the selector, e.g. 'kind=Deployment,name=api:[{\"op\":\"replace\",\"path\":\"/spec/replicas\",\"value\":3}]'. May be specified multiple times")
+	f.StringVar(&patchType, "patch-type", string(resource.PatchStrategicMerge), "Specifies how object patches are applied. Must be \"strategic\" (strategic merge patch, a JSON merge patch for custom resources) or \"merge\" (JSON merge patch that replaces lists)")
+}
+
+// patches returns the patches the --patch and --patch-json flags specify in that order
+func patches() (p []*resource.Patch, err error) {
+	objType, err := resource.ParsePatchType(patchType)
+	if err != nil {
+		return
+	}
+	for _, f := range patchFiles {
+		l, err := resource.ParsePatchFile(f, objType)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, l...)
+	}
+	for _, s := range patchJSON {
+		patch, err := resource.ParsePatchJSON(s, objType)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, patch)
+	}
+	return
+}
+
+func addSchemaFlag(f *pflag.FlagSet, v *string, defaultValue string) {
+	f.StringVar(v, "schema", defaultValue, "Validates the resources against the OpenAPI schemas. Must be \""+schemaBuiltin+"\" (Kubernetes "+validate.BuiltinVersion+" API k8spkg has been built with, does not check required fields), \""+schemaCluster+"\", \""+schemaNone+"\" or an OpenAPI v2 document file")
+	f.StringVar(&kubeVersion, "kube-version", validate.BuiltinVersion, "The Kubernetes version the \""+schemaBuiltin+"\" schemas must correspond to. Only "+validate.BuiltinVersion+" is supported - use --schema "+schemaCluster+" or a document file to validate against other versions")
+}
+
+// schemaSource returns the source of the schemas resources are validated against
+// or nil if the validation is disabled.
+// The builtin schemas are rejected unless they correspond to --kube-version.
+func schemaSource(schema string) (k8spkg.SchemaSource, error) {
+	switch schema {
+	case schemaBuiltin:
+		return k8spkg.BuiltinSchemas, validate.CheckBuiltinVersion(kubeVersion)
+	case schemaCluster:
+		return k8spkg.ClusterSchemas, nil
+	case schemaNone, "":
+		return nil, nil
+	}
+	return k8spkg.FileSchemas(schema), nil
 }
 
 func addSourceNameFlags(f *pflag.FlagSet) {
@@ -74,12 +178,20 @@ func newContext() context.Context {
 }
 
 func sourcePackage(ctx context.Context) (pkg *k8spkg.K8sPackage, err error) {
+	strategy, err := resource.ParseDuplicateStrategy(onDuplicate)
+	if err != nil {
+		return
+	}
+	p, err := patches()
+	if err != nil {
+		return
+	}
 	reader, err := sourceReader(ctx)
 	if err != nil {
 		return
 	}
 	defer reader.Close()
-	pkg, err = k8spkg.PkgFromManifest(reader, namespace, pkgName)
+	pkg, err = k8spkg.PkgFromManifest(reader, namespace, pkgName, strategy, p)
 	return
 }
 
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/mgoltzsche/k8spkg/pkg/k8spkg"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+// clusterLogField is the log field the lines of a cluster are prefixed with
+const clusterLogField = "cluster"
+
+var (
+	kubeContexts       []string
+	kubeContextsFile   string
+	clusterConcurrency int
+)
+
+func addContextsFlags(f *pflag.FlagSet) {
+	f.StringSliceVar(&kubeContexts, "contexts", nil, "Applies the package to each of the provided kubeconfig contexts")
+	f.StringVar(&kubeContextsFile, "contexts-file", "", "Applies the package to each kubeconfig context listed within the file (one per line)")
+	f.IntVar(&clusterConcurrency, "cluster-concurrency", 4, "Max number of clusters the package is applied to in parallel (with --contexts or --contexts-file)")
+}
+
+// targetContexts returns the kubeconfig contexts provided with --contexts and --contexts-file
+func targetContexts() (contexts []string, err error) {
+	names := kubeContexts
+	if kubeContextsFile != "" {
+		f, err := os.Open(kubeContextsFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read contexts")
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				names = append(names, line)
+			}
+		}
+		if err = scanner.Err(); err != nil {
+			return nil, errors.Wrapf(err, "read contexts file %s", kubeContextsFile)
+		}
+	}
+	seen := map[string]bool{}
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" && !seen[name] {
+			seen[name] = true
+			contexts = append(contexts, name)
+		}
+	}
+	if len(contexts) > 0 {
+		if clientConfig.Context != "" {
+			return nil, errors.New("--context and --contexts/--contexts-file are mutually exclusive")
+		}
+		if recordSession != "" || replaySession != "" {
+			return nil, errors.New("sessions cannot be recorded or replayed for multiple contexts")
+		}
+	}
+	return
+}
+
+// forEachContext runs the function with a PackageManager per kubeconfig context
+// concurrently and logs a report of the clusters the function failed for.
+func forEachContext(contexts []string, fn func(*k8spkg.PackageManager) error) error {
+	concurrency := clusterConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	installClusterPrefixFormatter()
+	results := make([]error, len(contexts))
+	slots := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+	for i, kubeContext := range contexts {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, kubeContext string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			log := logrus.WithField(clusterLogField, kubeContext)
+			config := clientConfig
+			config.Context = kubeContext
+			config.Log = log
+			m := k8spkg.NewPackageManager(k8sClientFor(config), namespace)
+			m.SetLogger(log)
+			if results[i] = fn(m); results[i] != nil {
+				log.Error(results[i])
+			}
+		}(i, kubeContext)
+	}
+	wg.Wait()
+	var failed []string
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tSTATUS")
+	for i, kubeContext := range contexts {
+		status := "ready"
+		if results[i] != nil {
+			status = "failed"
+			failed = append(failed, kubeContext)
+		}
+		fmt.Fprintf(w, "%s\t%s\n", kubeContext, status)
+	}
+	w.Flush()
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		logrus.Info(line)
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("failed for %d of %d clusters: %s", len(failed), len(contexts), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// clusterPrefixFormatter prefixes the message of an entry with its cluster field
+type clusterPrefixFormatter struct {
+	logrus.Formatter
+}
+
+func (f *clusterPrefixFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	cluster, ok := entry.Data[clusterLogField]
+	if !ok {
+		return f.Formatter.Format(entry)
+	}
+	prefixed := *entry
+	prefixed.Data = make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		if k != clusterLogField {
+			prefixed.Data[k] = v
+		}
+	}
+	prefixed.Message = fmt.Sprintf("[%s] %s", cluster, entry.Message)
+	return f.Formatter.Format(&prefixed)
+}
+
+// installClusterPrefixFormatter makes the standard logger prefix the
+// messages of entries that have a cluster field
+func installClusterPrefixFormatter() {
+	std := logrus.StandardLogger()
+	if _, ok := std.Formatter.(*clusterPrefixFormatter); !ok {
+		logrus.SetFormatter(&clusterPrefixFormatter{std.Formatter})
+	}
+}
@@ -16,6 +16,7 @@ limitations under the License.
 package cmd
 
 import (
+	"github.com/mgoltzsche/k8spkg/pkg/client"
 	"github.com/mgoltzsche/k8spkg/pkg/resource"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -30,21 +31,35 @@ var (
 			if len(args) > 1 {
 				return errors.New("too many arguments provided")
 			}
+			dryRunMode, err := client.ParseDryRun(dryRun)
+			if err != nil {
+				return
+			}
+			sel, err := selection()
+			if err != nil {
+				return
+			}
+			opts := client.DeleteOptions{DryRun: dryRunMode}
 			ctx := newContext()
 			apiManager := pkgManager()
+			apiManager.SetSelection(sel)
 			if len(args) > 0 {
 				// Find and delete objects by package name
 				if sourceKustomize != "" || sourceFile != "" {
 					return errors.New("package name argument and -f or -k option are mutually exclusive but both provided")
 				}
 				for _, pkgName := range args {
-					if err = apiManager.Delete(ctx, pkgName); err != nil {
+					if err = apiManager.Delete(ctx, pkgName, opts); err != nil {
 						return
 					}
 				}
 				return
 			}
 			// Delete provided objects
+			strategy, err := resource.ParseDuplicateStrategy(onDuplicate)
+			if err != nil {
+				return
+			}
 			reader, err := sourceReader(ctx)
 			if err != nil {
 				return
@@ -54,13 +69,18 @@ var (
 			if err != nil {
 				return
 			}
+			if obj, err = obj.Deduplicate(strategy); err != nil {
+				return
+			}
 			// TODO: recover from wait error due to already removed object
-			return apiManager.DeleteResources(ctx, obj.Refs())
+			return apiManager.DeleteResources(ctx, obj.Refs(), opts)
 		},
 	}
 )
 
 func init() {
 	addSourceFlags(deleteCmd.Flags())
+	addDryRunFlag(deleteCmd.Flags())
+	addSelectorFlags(deleteCmd.Flags())
 	rootCmd.AddCommand(deleteCmd)
 }
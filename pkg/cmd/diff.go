@@ -0,0 +1,60 @@
+/*
+Copyright © 2019 Max Goltzsche <max.goltzsche@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffCmd = &cobra.Command{
+		Use:   "diff",
+		Short: "Compares a package with the cluster state",
+		Long: `Prints a unified diff per resource between the cluster state and the provided package.
+Live fields that are not specified by the package (e.g. server-side defaults) are ignored.
+Exits with code 0 if there are no differences, 1 if there are differences and 2 on error`,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if len(args) != 0 {
+				return &exitCodeError{fmt.Errorf("no arguments supported but provided %+v", args), 2}
+			}
+			ctx := newContext()
+			pkg, err := sourcePackage(ctx)
+			if err != nil {
+				return &exitCodeError{err, 2}
+			}
+			m := pkgManager()
+			m.SetShowSecrets(showSecrets)
+			changed, err := m.Diff(ctx, pkg, os.Stdout)
+			if err != nil {
+				return &exitCodeError{err, 2}
+			}
+			if changed {
+				return &exitCodeError{errors.Errorf("package %s differs from the cluster state", pkg.Name), 1}
+			}
+			return
+		},
+	}
+)
+
+func init() {
+	addSourceNameFlags(diffCmd.Flags())
+	addShowSecretsFlag(diffCmd.Flags())
+	rootCmd.AddCommand(diffCmd)
+}
@@ -16,31 +16,49 @@ limitations under the License.
 package cmd
 
 import (
+	"io"
+	"os"
+
 	"github.com/mgoltzsche/k8spkg/pkg/client"
 	"github.com/mgoltzsche/k8spkg/pkg/k8spkg"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	//homedir "github.com/mitchellh/go-homedir"
 	//viper "github.com/spf13/viper"
 )
 
+const (
+	clientTypeKubectl = "kubectl"
+	clientTypeAPI     = "api"
+)
+
 var (
-	debug          bool
-	kubeconfigFile string
-	clientFactory  = func(kubeconfigFile string) client.K8sClient {
-		return client.NewK8sClient(kubeconfigFile) // replaced during test
+	debug         bool
+	clientConfig  = client.Config{Retry: client.DefaultRetryPolicy}
+	clientType    = clientTypeKubectl
+	clientFactory = func(config client.Config) client.K8sClient {
+		// replaced during test
+		if clientType == clientTypeAPI {
+			return client.NewAPIClient(config)
+		}
+		return client.NewK8sClient(config)
 	}
+	recordSession string
+	replaySession string
+	sessionWriter io.Writer
+	replayClient  client.K8sClient
 	//cfgFile string
 )
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
-	Use:              "k8spkg",
-	Short:            "Manages kubernetes API objects in packages",
-	Long:             "Manages kubernetes API objects in packages",
-	PersistentPreRun: applyLogLevel,
-	SilenceUsage:     true,
-	SilenceErrors:    true,
+	Use:               "k8spkg",
+	Short:             "Manages kubernetes API objects in packages",
+	Long:              "Manages kubernetes API objects in packages",
+	PersistentPreRunE: preRun,
+	SilenceUsage:      true,
+	SilenceErrors:     true,
 }
 
 func pkgManager() *k8spkg.PackageManager {
@@ -48,20 +66,72 @@ func pkgManager() *k8spkg.PackageManager {
 }
 
 func k8sClient() client.K8sClient {
-	return clientFactory(kubeconfigFile)
+	return k8sClientFor(clientConfig)
 }
 
-func applyLogLevel(cmd *cobra.Command, args []string) {
+func k8sClientFor(config client.Config) client.K8sClient {
+	if replayClient != nil {
+		return replayClient
+	}
+	c := clientFactory(config)
+	if sessionWriter != nil {
+		c = client.NewRecordingClient(c, sessionWriter)
+	}
+	return c
+}
+
+func preRun(cmd *cobra.Command, args []string) error {
 	if debug {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
+	if clientType != clientTypeKubectl && clientType != clientTypeAPI {
+		return errors.Errorf("unsupported client %q provided, expected %s or %s", clientType, clientTypeKubectl, clientTypeAPI)
+	}
+	return openSession()
+}
+
+// openSession opens the session file to record client calls into or to replay them from
+func openSession() (err error) {
+	sessionWriter, replayClient = nil, nil
+	if recordSession != "" && replaySession != "" {
+		return errors.New("--record-session and --replay-session are mutually exclusive")
+	}
+	if recordSession != "" {
+		// The file is closed when the process terminates - calls are written when completed
+		f, err := os.OpenFile(recordSession, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return errors.Wrap(err, "record session")
+		}
+		sessionWriter = f
+	}
+	if replaySession != "" {
+		f, err := os.Open(replaySession)
+		if err != nil {
+			return errors.Wrap(err, "replay session")
+		}
+		defer f.Close()
+		replayClient, err = client.NewReplayClient(f)
+		return errors.Wrap(err, replaySession)
+	}
+	return
+}
+
+// exitCodeError makes the process terminate with a specific exit code
+type exitCodeError struct {
+	error
+	code int
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		logrus.Fatalf("k8spkg: %s", err)
+		code := 1
+		if e, ok := err.(*exitCodeError); ok {
+			code = e.code
+		}
+		logrus.Errorf("k8spkg: %s", err)
+		os.Exit(code)
 	}
 }
 
@@ -69,7 +139,16 @@ func init() {
 	//cobra.OnInitialize(initConfig)
 	//rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.k8spkg.yaml)")
 	rootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "enable debug log")
-	rootCmd.PersistentFlags().StringVar(&kubeconfigFile, "kubeconfig", "", "use a particular kubeconfig.yaml (overrides KUBECONFIG env var)")
+	rootCmd.PersistentFlags().StringVar(&clientConfig.KubeconfigFile, "kubeconfig", "", "use a particular kubeconfig.yaml (overrides KUBECONFIG env var)")
+	rootCmd.PersistentFlags().StringVar(&clientConfig.Context, "context", "", "the kubeconfig context to use")
+	rootCmd.PersistentFlags().StringVar(&clientConfig.Cluster, "cluster", "", "the kubeconfig cluster to use")
+	rootCmd.PersistentFlags().StringVar(&clientConfig.User, "user", "", "the kubeconfig user to use")
+	rootCmd.PersistentFlags().StringVar(&clientConfig.Impersonate, "as", "", "username to impersonate for the operation")
+	rootCmd.PersistentFlags().StringArrayVar(&clientConfig.ImpersonateGroups, "as-group", nil, "group to impersonate for the operation, can be repeated to specify multiple groups")
+	rootCmd.PersistentFlags().IntVar(&clientConfig.Retry.MaxRetries, "retries", client.DefaultRetryPolicy.MaxRetries, "max number of retries of an operation that failed with a transient error (throttled, server timeout, connection refused)")
+	rootCmd.PersistentFlags().StringVar(&recordSession, "record-session", "", "record all cluster calls with their results into a file that can be replayed using --replay-session")
+	rootCmd.PersistentFlags().StringVar(&replaySession, "replay-session", "", "serve cluster calls from a file recorded using --record-session instead of talking to a cluster")
+	rootCmd.PersistentFlags().StringVar(&clientType, "client", clientTypeKubectl, "Kubernetes client implementation: "+clientTypeKubectl+" (calls the kubectl binary) or "+clientTypeAPI+" (uses the API directly)")
 }
 
 /*
@@ -2,22 +2,31 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/mgoltzsche/k8spkg/pkg/client"
 	"github.com/mgoltzsche/k8spkg/pkg/client/mock"
 	"github.com/mgoltzsche/k8spkg/pkg/k8spkg"
 	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/mgoltzsche/k8spkg/pkg/validate"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// client config the last client has been created with during testRun
+var lastClientConfig client.Config
+
 func assertKubectlVerbsUsed(t *testing.T, args, expectedVerbs []string, callMap map[string]string) {
 	_, actualCalls, err := testRun(t, args)
 	require.NoError(t, err)
@@ -78,9 +87,110 @@ func TestBuild(t *testing.T) {
 	}
 }
 
+func TestBuildOutput(t *testing.T) {
+	for _, format := range []string{"yaml", "json", "jsonlist"} {
+		out, _, err := testRun(t, []string{"build", "-f", "../resource/test", "-o", format})
+		require.NoError(t, err, format)
+		obj, err := resource.FromReader(bytes.NewReader(out))
+		require.NoError(t, err, "decode %s output", format)
+		require.Equal(t, 9, len(obj), "%s object count", format)
+	}
+	out, _, err := testRun(t, []string{"build", "-k", "../resource/test/kustomize", "-o", "name"})
+	require.NoError(t, err)
+	require.Equal(t, "certificate.certmanager.k8s.io/mycert\ndeployment/mydeployment\n", string(out), "name output")
+	out, _, err = testRun(t, []string{"build", "-k", "../resource/test/kustomize", "-o", "table", "-n", "myns"})
+	require.NoError(t, err)
+	require.Contains(t, string(out), "KIND", "table header")
+	require.Regexp(t, "deployment +myns +mydeployment +kustomizedpkg", string(out), "table row")
+	_, _, err = testRun(t, []string{"build", "-f", "../resource/test", "-o", "unsupported"})
+	require.Error(t, err, "unsupported output format")
+}
+
+func TestBuildSelection(t *testing.T) {
+	out, _, err := testRun(t, []string{"build", "-f", "../resource/test", "-o", "name", "--only", "kind=Deployment", "--exclude", "namespace=mynamespace"})
+	require.NoError(t, err)
+	require.Equal(t, "deployment/mydeployment\ndeployment.apps/cert-manager-webhook\n", string(out))
+	out, _, err = testRun(t, []string{"build", "-f", "../resource/test", "-o", "name", "--only", "kind=pod,name=*-x", "--only", "kind=certificate.certmanager.k8s.io"})
+	require.NoError(t, err)
+	require.Equal(t, "pod/somedeployment-pod-x\ncertificate.certmanager.k8s.io/onemorecert\n", string(out))
+}
+
+func TestBuildDuplicates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8spkg-test-duplicates-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, file := range []string{"a.yaml", "b.yaml"} {
+		manifest := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: myconfig\ndata:\n  file: " + file + "\n"
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, file), []byte(manifest), 0644))
+	}
+	_, _, err = testRun(t, []string{"build", "-f", dir, "--name", "mypkg"})
+	require.Error(t, err, "duplicate")
+	require.Contains(t, err.Error(), filepath.Join(dir, "b.yaml"), "duplicate error")
+	out, _, err := testRun(t, []string{"build", "-f", dir, "--name", "mypkg", "--on-duplicate", "last"})
+	require.NoError(t, err)
+	require.Contains(t, string(out), "file: b.yaml")
+	require.NotContains(t, string(out), "file: a.yaml")
+}
+
+func TestBuildPatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8spkg-test-patches-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	manifest := filepath.Join(dir, "manifest.yaml")
+	smp := filepath.Join(dir, "smp.yaml")
+	merge := filepath.Join(dir, "merge.yaml")
+	for file, content := range map[string]string{
+		manifest: "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: mydeployment\nspec:\n  replicas: 1\n  template:\n    spec:\n      containers:\n      - name: app\n        image: app:1.0\n",
+		smp:      "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: mydeployment\nspec:\n  template:\n    spec:\n      containers:\n      - name: sidecar\n        image: sidecar:1.0\n",
+		merge:    "metadata:\n  annotations:\n    env: prod\n",
+	} {
+		require.NoError(t, ioutil.WriteFile(file, []byte(content), 0644))
+	}
+	out, _, err := testRun(t, []string{"build", "-f", manifest, "--name", "mypkg", "-n", "myns",
+		"--patch", smp,
+		"--patch", "kind=Deployment:" + merge,
+		"--patch-json", `name=my*:[{"op":"replace","path":"/spec/replicas","value":3}]`})
+	require.NoError(t, err)
+	for _, expected := range []string{"name: sidecar", "name: app", "env: prod", "replicas: 3", "namespace: myns", "app.kubernetes.io/part-of: mypkg"} {
+		require.Contains(t, string(out), expected)
+	}
+	out, _, err = testRun(t, []string{"build", "-f", manifest, "--name", "mypkg", "--patch", smp, "--patch-type", "merge"})
+	require.NoError(t, err)
+	require.Contains(t, string(out), "name: sidecar")
+	require.NotContains(t, string(out), "name: app", "merge patch should replace the containers")
+	for _, args := range [][]string{
+		{"--name", "mypkg", "--patch", smp, "--patch-type", "json"},
+		{"--patch", merge},
+		{"--patch", "kind=Service:" + merge},
+		{"--patch-json", `kind=Deployment:[{"op":"remove","path":"/spec/missing"}]`},
+		{"--patch-json", "kind=Deployment"},
+		{"--patch", filepath.Join(dir, "nonexisting.yaml")},
+	} {
+		_, _, err = testRun(t, append([]string{"build", "-f", manifest}, args...))
+		require.Error(t, err, "%v", args)
+	}
+}
+
+func TestBuildSecret(t *testing.T) {
+	f, err := ioutil.TempFile("", "k8spkg-test-secret-")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("apiVersion: v1\nkind: Secret\nmetadata:\n  name: mysecret\ndata:\n  password: cDRzc3cwcmQ=\n")
+	f.Close()
+	require.NoError(t, err)
+	args := []string{"build", "-f", f.Name(), "--name", "mypkg"}
+	out, _, err := testRun(t, args)
+	require.NoError(t, err)
+	require.NotContains(t, string(out), "cDRzc3cwcmQ=", "secret value")
+	require.Contains(t, string(out), "password: "+resource.RedactedValue)
+	out, _, err = testRun(t, append(args, "--show-secrets"))
+	require.NoError(t, err)
+	require.Contains(t, string(out), "password: cDRzc3cwcmQ=", "--show-secrets")
+}
+
 func TestCLI(t *testing.T) {
 	kubectlCallSets := map[string]string{}
-	applyKubectlVerbs := []string{"apply", "watch"}
+	applyKubectlVerbs := []string{"resourcetypes", "currentcontext", "getresource", "apply", "watch"}
 	awaitDeletion := "awaitdeletion"
 	for _, c := range []struct {
 		args                 []string
@@ -93,14 +203,25 @@ func TestCLI(t *testing.T) {
 		{[]string{"apply", "-f", "../resource/test/manifestdir", "-n", "myns", "--name", "renamedpkg"}, applyKubectlVerbs},
 		{[]string{"apply", "-k", "../resource/test/kustomize", "--timeout=3s"}, applyKubectlVerbs},
 		{[]string{"apply", "-k", "../resource/test/kustomize", "-n", "myns", "--name", "renamedpkg"}, applyKubectlVerbs},
-		{[]string{"apply", "-k", "../resource/test/kustomize", "-n", "myns", "--prune"}, applyKubectlVerbs},
-		{[]string{"delete", "-f", "../resource/test", "--timeout=3s"}, []string{"delete", awaitDeletion}},
-		{[]string{"delete", "-f", "../resource/test/manifestdir"}, []string{"delete", awaitDeletion}},
-		{[]string{"delete", "-f", "../resource/test", "-n", "myns"}, []string{"delete", awaitDeletion}},
-		{[]string{"delete", "-k", "../resource/test/kustomize", "--timeout=3s"}, []string{"delete", awaitDeletion}},
-		{[]string{"delete", "-k", "../resource/test/kustomize", "-n", "myns"}, []string{"delete", awaitDeletion}},
-		{[]string{"delete", "somepkg", "--timeout=3s"}, []string{"getresource", "delete", awaitDeletion}},
-		{[]string{"delete", "somepkg", "-n", "myns"}, []string{"getresource", "delete", awaitDeletion}},
+		{[]string{"apply", "-k", "../resource/test/kustomize", "-n", "myns", "--prune"}, []string{"resourcetypes", "currentcontext", "getresource", "get", "apply", "watch"}},
+		{[]string{"apply", "-k", "../resource/test/kustomize", "-n", "myns", "--create-namespace"}, applyKubectlVerbs},
+		{[]string{"apply", "-k", "../resource/test/kustomize", "-n", "myns", "--skip-unchanged=false"}, applyKubectlVerbs},
+		{[]string{"apply", "-k", "../resource/test/kustomize", "-n", "myns", "--only", "kind=Deployment"}, applyKubectlVerbs},
+		{[]string{"apply", "-k", "../resource/test/kustomize", "-n", "myns", "--server-side"}, applyKubectlVerbs},
+		{[]string{"apply", "-k", "../resource/test/kustomize", "-n", "myns", "--server-side", "--force-conflicts"}, applyKubectlVerbs},
+		{[]string{"apply", "-k", "../resource/test/kustomize", "-n", "myns", "--dry-run=client"}, []string{"apply"}},
+		{[]string{"apply", "-k", "../resource/test/kustomize", "-n", "myns", "--dry-run=server", "--prune"}, []string{"apply", "getresource", "resourcetypes", "get"}},
+		{[]string{"delete", "-f", "../resource/test", "--timeout=3s"}, []string{"resourcetypes", "delete", awaitDeletion}},
+		{[]string{"delete", "-f", "../resource/test/manifestdir"}, []string{"resourcetypes", "delete", awaitDeletion}},
+		{[]string{"delete", "-f", "../resource/test", "-n", "myns"}, []string{"resourcetypes", "delete", awaitDeletion}},
+		{[]string{"delete", "-k", "../resource/test/kustomize", "--timeout=3s"}, []string{"resourcetypes", "delete", awaitDeletion}},
+		{[]string{"delete", "-k", "../resource/test/kustomize", "-n", "myns"}, []string{"resourcetypes", "delete", awaitDeletion}},
+		{[]string{"delete", "somepkg", "--timeout=3s"}, []string{"getresource", "resourcetypes", "delete", awaitDeletion}},
+		{[]string{"delete", "somepkg", "-n", "myns"}, []string{"getresource", "resourcetypes", "delete", awaitDeletion}},
+		{[]string{"delete", "somepkg", "-n", "myns", "--dry-run=client"}, []string{"getresource", "delete"}},
+		{[]string{"delete", "-f", "../resource/test", "-n", "myns", "--dry-run=server"}, []string{"delete", "getresource"}},
+		{[]string{"delete", "-f", "../resource/test", "-n", "myns", "--exclude", "kind=Pod"}, []string{"resourcetypes", "delete", awaitDeletion}},
+		{[]string{"validate", "-f", "../resource/test"}, []string{}},
 		{[]string{"list"}, []string{"get"}},
 		{[]string{"list", "-n", "myns"}, []string{"get"}},
 		//TODO:{[]string{"list", "--all-namespaces"}, []string{"get"}},
@@ -109,6 +230,133 @@ func TestCLI(t *testing.T) {
 	}
 }
 
+func TestClientConfigFlags(t *testing.T) {
+	_, _, err := testRun(t, []string{"list", "--kubeconfig", "kubeconfig.yaml", "--context", "myctx", "--cluster", "mycluster", "--user", "myuser", "--as", "someone", "--as-group", "group1", "--as-group", "group2"})
+	require.NoError(t, err)
+	expected := client.Config{
+		KubeconfigFile:    "kubeconfig.yaml",
+		Context:           "myctx",
+		Cluster:           "mycluster",
+		User:              "myuser",
+		Impersonate:       "someone",
+		ImpersonateGroups: []string{"group1", "group2"},
+	}
+	require.Equal(t, expected, lastClientConfig)
+}
+
+func TestRecordReplaySession(t *testing.T) {
+	f, err := ioutil.TempFile("", "k8spkg-test-session-")
+	require.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+	args := []string{"apply", "-k", "../resource/test/kustomize", "-n", "myns"}
+	_, calls, err := testRun(t, append(args, "--record-session", f.Name()))
+	require.NoError(t, err, "record")
+	require.NotEmpty(t, calls, "recorded client calls")
+	_, calls, err = testRun(t, append(args, "--replay-session", f.Name()))
+	require.NoError(t, err, "replay")
+	require.Empty(t, calls, "client calls during replay")
+	_, _, err = testRun(t, []string{"delete", "somepkg", "--replay-session", f.Name()})
+	require.Error(t, err, "replay of calls that have not been recorded")
+	_, _, err = testRun(t, append(args, "--record-session", f.Name(), "--replay-session", f.Name()))
+	require.Error(t, err, "record and replay at the same time")
+}
+
+func TestApplyContexts(t *testing.T) {
+	f, err := ioutil.TempFile("", "k8spkg-test-contexts-")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("# clusters\nctx-c\n\nctx-a\n")
+	f.Close()
+	require.NoError(t, err)
+	args := []string{"apply", "-k", "../resource/test/kustomize", "-n", "myns", "--contexts", "ctx-a,ctx-b", "--contexts-file", f.Name(), "--cluster-concurrency", "2"}
+	_, calls, err := testRun(t, args)
+	require.NoError(t, err)
+	count := 0
+	for _, call := range calls {
+		if call == "currentcontext" {
+			count++
+		}
+	}
+	require.Equal(t, 3, count, "applied packages")
+	_, _, err = testRun(t, append(args, "--context", "ctx-a"))
+	require.Error(t, err, "--context and --contexts provided")
+
+	// failure of a single cluster
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+	var clientLogMutex sync.Mutex
+	clientLogs := map[string]interface{}{}
+	clientFactory = func(config client.Config) client.K8sClient {
+		clientLogMutex.Lock()
+		if entry, ok := config.Log.(*logrus.Entry); ok {
+			clientLogs[config.Context] = entry.Data[clusterLogField]
+		}
+		clientLogMutex.Unlock()
+		c := mock.NewClientMock()
+		c.MockResource = mock.MockResourceList("../k8spkg/app-example.yaml")[0]
+		if config.Context == "ctx-b" {
+			c.MockErr = errors.New("error mock")
+		}
+		return c
+	}
+	err = forEachContext([]string{"ctx-a", "ctx-b", "ctx-c"}, func(m *k8spkg.PackageManager) error {
+		return m.Delete(context.Background(), "somepkg", client.DeleteOptions{DryRun: client.DryRunClient})
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed for 1 of 3 clusters: ctx-b")
+	var failed *logrus.Entry
+	for _, e := range hook.AllEntries() {
+		if e.Level == logrus.ErrorLevel {
+			failed = e
+		}
+	}
+	require.NotNil(t, failed, "error log entry")
+	b, err := logrus.StandardLogger().Formatter.Format(failed)
+	require.NoError(t, err)
+	require.Contains(t, string(b), "[ctx-b] ", "log line prefix")
+	require.Equal(t, map[string]interface{}{"ctx-a": "ctx-a", "ctx-b": "ctx-b", "ctx-c": "ctx-c"}, clientLogs, "client loggers")
+}
+
+func TestValidate(t *testing.T) {
+	f, err := ioutil.TempFile("", "k8spkg-test-invalid-")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("apiVersion: v1\nkind: Pod\nmetadata:\n  name: mypod\nspec:\n  contianers: []\n")
+	f.Close()
+	require.NoError(t, err)
+	_, _, err = testRun(t, []string{"validate", "-f", f.Name(), "-n", "myns", "--name", "mypkg"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "pod/mypod -n myns: spec.contianers: unknown field")
+	_, calls, err := testRun(t, []string{"apply", "-f", f.Name(), "-n", "myns", "--name", "mypkg", "--schema", "builtin"})
+	require.Error(t, err, "apply invalid package with --schema builtin")
+	require.Empty(t, calls, "client calls")
+	_, calls, err = testRun(t, []string{"apply", "-f", f.Name(), "-n", "myns", "--name", "mypkg"})
+	require.NoError(t, err, "apply should not validate by default")
+	require.NotEmpty(t, calls, "client calls")
+	_, _, err = testRun(t, []string{"validate", "-f", f.Name(), "-n", "myns", "--name", "mypkg", "--kube-version", "v1.20"})
+	require.Error(t, err, "validate with unsupported --kube-version")
+	require.Contains(t, err.Error(), "unsupported Kubernetes version")
+	_, calls, err = testRun(t, []string{"apply", "-f", f.Name(), "-n", "myns", "--name", "mypkg", "--schema", "builtin", "--kube-version", "v1.20"})
+	require.Error(t, err, "apply with unsupported --kube-version")
+	require.Empty(t, calls, "client calls")
+}
+
+func TestDiff(t *testing.T) {
+	out, calls, err := testRun(t, []string{"diff", "-f", "../resource/test", "-n", "myns"})
+	require.Error(t, err, "diff should return error when there are differences")
+	exitErr, ok := errors.Cause(err).(*exitCodeError)
+	require.True(t, ok, "exitCodeError expected but was %T", errors.Cause(err))
+	require.Equal(t, 1, exitErr.code, "exit code")
+	require.Contains(t, string(out), "\n--- live/", "diff output")
+	require.Equal(t, "getresource", strings.Split(calls[0], " ")[0], "client call")
+
+	_, _, err = testRun(t, []string{"diff"})
+	exitErr, ok = errors.Cause(err).(*exitCodeError)
+	require.True(t, ok, "exitCodeError expected but was %T", errors.Cause(err))
+	require.Equal(t, 2, exitErr.code, "exit code on error")
+}
+
 func TestCLIErrorHandling(t *testing.T) {
 	for _, args := range [][]string{
 		{"unsupported"},
@@ -118,8 +366,21 @@ func TestCLIErrorHandling(t *testing.T) {
 		{"apply", "../resource/test", "-n", "myns"},
 		{"apply", "../resource/test", "--name", "renamedpkg"},
 		{"apply", "../resource/test", "-n", "myns", "--name", "renamedpkg"},
+		{"apply", "-f", "../resource/test", "--force-conflicts"},
+		{"apply", "-f", "../resource/test", "--dry-run=invalid"},
+		{"delete", "somepkg", "--dry-run=invalid"},
+		{"delete", "somepkg", "--only", "kind"},
+		{"build", "-f", "../resource/test", "--on-duplicate", "unsupported"},
+		{"delete", "-f", "../resource/test", "--on-duplicate", "unsupported"},
+		{"build", "-f", "../resource/test", "--exclude", "name=["},
+		{"status", "-f", "../resource/test", "--only", "=Deployment"},
+		{"apply", "-f", "../resource/test", "--patch-json", "kind=Deployment"},
 		{"delete"},
 		{"list", "--all-namespaces", "-n", "myns"},
+		{"list", "--client", "unsupported"},
+		{"validate", "-f", "../resource/test", "--schema", "none"},
+		{"validate", "-f", "../resource/test", "--schema", "cluster"},
+		{"validate", "-f", "../resource/test", "--schema", "nonexisting.json"},
 	} {
 		_, _, err := testRun(t, args)
 		require.Error(t, err, "%+v", args)
@@ -128,15 +389,39 @@ func TestCLIErrorHandling(t *testing.T) {
 
 func testRun(t *testing.T, args []string) (b []byte, actualCalls []string, err error) {
 	// reset state
-	kubeconfigFile = ""
+	clientConfig = client.Config{}
+	clientType = clientTypeKubectl
 	sourceKustomize = ""
 	sourceFile = ""
 	namespace = ""
 	pkgName = ""
 	prune = false
+	serverSide = false
+	forceConflicts = false
+	dryRun = ""
+	schema = schemaBuiltin
+	applySchema = schemaNone
+	kubeVersion = validate.BuiltinVersion
+	showSecrets = false
+	output = "yaml"
+	batchOpts = k8spkg.DefaultBatchOptions
+	createNs = false
+	skipUnchanged = true
+	only = nil
+	exclude = nil
+	onDuplicate = string(resource.DuplicateError)
+	patchFiles = nil
+	patchJSON = nil
+	patchType = string(resource.PatchStrategicMerge)
+	kubeContexts = nil
+	kubeContextsFile = ""
+	clusterConcurrency = 4
+	recordSession = ""
+	replaySession = ""
 	clientMock := mock.NewClientMock()
 	clientMock.MockResource = mock.MockResourceList("../k8spkg/app-example.yaml")[0]
-	clientFactory = func(kubeconfigFile string) client.K8sClient {
+	clientFactory = func(config client.Config) client.K8sClient {
+		lastClientConfig = config
 		return clientMock
 	}
 
@@ -156,12 +441,9 @@ func testRun(t *testing.T, args []string) (b []byte, actualCalls []string, err e
 		err = errors.Wrapf(err, "%+v", args)
 	}
 	f.Close()
-	if err == nil {
-		var e error
-		b, e = ioutil.ReadFile(fileName)
-		if e != nil {
-			panic(e)
-		}
+	b, e := ioutil.ReadFile(fileName)
+	if e != nil {
+		panic(e)
 	}
 	actualCalls = clientMock.Calls
 	return
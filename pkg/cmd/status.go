@@ -25,17 +25,25 @@ var (
 		Short: "Waits for a packge's components to become ready",
 		Long:  `Waits for a packge's components to become ready`,
 		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			sel, err := selection()
+			if err != nil {
+				return
+			}
 			ctx := newContext()
 			pkg, err := sourcePackage(ctx)
 			if err != nil {
 				return
 			}
-			return pkgManager().Status(ctx, pkg)
+			m := pkgManager()
+			m.SetSelection(sel)
+			return m.Status(ctx, pkg)
 		},
 	}
 )
 
 func init() {
 	addSourceNameFlags(statusCmd.Flags())
+	addSelectorFlags(statusCmd.Flags())
+	addPatchFlags(statusCmd.Flags())
 	rootCmd.AddCommand(statusCmd)
 }
@@ -0,0 +1,60 @@
+/*
+Copyright © 2019 Max Goltzsche <max.goltzsche@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mgoltzsche/k8spkg/pkg/validate"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	validateCmd = &cobra.Command{
+		Use:   "validate",
+		Short: "Validates a package against OpenAPI schemas",
+		Long: `Validates the provided source's resources against OpenAPI schemas.
+The schemas of CustomResourceDefinitions within the source are taken into account.
+The builtin schemas correspond to Kubernetes `+validate.BuiltinVersion+` only and do not check required fields.`,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if len(args) != 0 {
+				return fmt.Errorf("no arguments supported but provided %+v", args)
+			}
+			source, err := schemaSource(schema)
+			if err != nil {
+				return
+			}
+			if source == nil {
+				return errors.Errorf("unsupported --schema %q provided", schema)
+			}
+			ctx := newContext()
+			pkg, err := sourcePackage(ctx)
+			if err != nil {
+				return
+			}
+			m := pkgManager()
+			m.SetSchemaSource(source)
+			return m.Validate(ctx, pkg)
+		},
+	}
+)
+
+func init() {
+	addSourceNameFlags(validateCmd.Flags())
+	addSchemaFlag(validateCmd.Flags(), &schema, schemaBuiltin)
+	rootCmd.AddCommand(validateCmd)
+}
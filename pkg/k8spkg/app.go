@@ -7,7 +7,15 @@ import (
 type App struct {
 	Name      string
 	Namespace string
+	// Context is the kubeconfig context the package has been applied with
+	Context   string
 	Resources resource.K8sResourceRefList
+	// Namespaces lists the namespaces the package created - they are deleted with it
+	Namespaces []string
+	// Digest is the digest of the last successfully applied package revision
+	Digest string
+	// Digests maps the IDs of the resources that have been applied successfully to their digest
+	Digests map[string]string
 }
 
 type AppResourceRef struct {
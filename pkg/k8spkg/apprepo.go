@@ -7,9 +7,13 @@ import (
 	"github.com/mgoltzsche/k8spkg/pkg/client"
 	"github.com/mgoltzsche/k8spkg/pkg/resource"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
+// Number of retries when the Application record has been modified concurrently
+const appPutConflictRetries = 3
+
 var (
 	CrdAPIGroup   = "k8spkg.mgoltzsche.github.com"
 	CrdAPIVersion = "v1alpha1"
@@ -72,12 +76,19 @@ func (m *AppRepo) Get(ctx context.Context, namespace, name string) (app *App, er
 func (m *AppRepo) Put(ctx context.Context, app *App) (err error) {
 	// TODO: do optimistic locking and merge resources
 	appRes := resourceFromApp(app)
-	_, err = m.client.Apply(ctx, app.Namespace, []*resource.K8sResource{appRes}, false, nil)
+	for i := 0; ; i++ {
+		_, err = m.client.Apply(ctx, app.Namespace, []*resource.K8sResource{appRes}, client.ApplyOptions{})
+		if !client.IsConflict(err) || i >= appPutConflictRetries {
+			break
+		}
+		// the record has been modified concurrently
+		logrus.Debugf("retrying app resource update: %s", err)
+	}
 	return errors.Wrapf(err, "put app resource %s:%s", app.Namespace, app.Name)
 }
 
 func (m *AppRepo) Delete(ctx context.Context, app *App) (err error) {
-	err = m.client.Delete(ctx, app.Namespace, []resource.K8sResourceRef{resourceFromApp(app)})
+	err = m.client.Delete(ctx, app.Namespace, []resource.K8sResourceRef{resourceFromApp(app)}, client.DeleteOptions{})
 	return errors.Wrapf(err, "delete app resource %s:%s", app.Namespace, app.Name)
 }
 
@@ -92,6 +103,7 @@ func appFromResource(obj *resource.K8sResource) (a *App, err error) {
 		return
 	}
 	resources := make([]resource.K8sResourceRef, 0, len(rawRefs))
+	var digests map[string]string
 	if resOk && len(rawRefs) > 0 {
 		for _, rawRef := range rawRefs {
 			if refMap, refOk = rawRef.(map[string]interface{}); refOk {
@@ -112,26 +124,62 @@ func appFromResource(obj *resource.K8sResource) (a *App, err error) {
 				}
 				continue
 			}
-			resources = append(resources,
-				resource.ResourceRef(apiVersion, kind, namespace, name))
+			ref := resource.ResourceRef(apiVersion, kind, namespace, name)
+			resources = append(resources, ref)
+			if digest, ok := refMap["digest"].(string); ok && digest != "" {
+				if digests == nil {
+					digests = map[string]string{}
+				}
+				digests[ref.ID()] = digest
+			}
 		}
 	} else {
 		err = errors.Errorf("app spec does not specify resources: %#v", obj.Raw())
 	}
+	kubeContext, _, e := unstructured.NestedString(obj.Raw(), "spec", "context")
+	if e != nil && err == nil {
+		err = e
+	}
+	namespaces, _, e := unstructured.NestedStringSlice(obj.Raw(), "spec", "namespaces")
+	if e != nil && err == nil {
+		err = e
+	}
+	digest, _, e := unstructured.NestedString(obj.Raw(), "spec", "digest")
+	if e != nil && err == nil {
+		err = e
+	}
 	err = errors.WithMessagef(err, "read app resource %s", obj.Name())
-	return &App{Name: obj.Name(), Namespace: obj.Namespace(), Resources: resources}, err
+	return &App{Name: obj.Name(), Namespace: obj.Namespace(), Context: kubeContext, Resources: resources, Namespaces: namespaces, Digest: digest, Digests: digests}, err
 }
 
 func resourceFromApp(app *App) (r *resource.K8sResource) {
 	ref := resource.ResourceRef(CrdAPIGroup+"/"+CrdAPIVersion, CrdKind, app.Namespace, app.Name)
 	res := make([]interface{}, len(app.Resources))
 	for i, r := range app.Resources {
-		res[i] = map[string]interface{}{
+		ref := map[string]interface{}{
 			"apiVersion": r.APIVersion(),
 			"kind":       r.Kind(),
 			"name":       r.Name(),
 			"namespace":  r.Namespace(),
 		}
+		if digest := app.Digests[r.ID()]; digest != "" {
+			ref["digest"] = digest
+		}
+		res[i] = ref
+	}
+	spec := map[string]interface{}{"resources": res}
+	if app.Context != "" {
+		spec["context"] = app.Context
+	}
+	if app.Digest != "" {
+		spec["digest"] = app.Digest
+	}
+	if len(app.Namespaces) > 0 {
+		namespaces := make([]interface{}, len(app.Namespaces))
+		for i, ns := range app.Namespaces {
+			namespaces[i] = ns
+		}
+		spec["namespaces"] = namespaces
 	}
-	return resource.Resource(ref, map[string]interface{}{"spec": map[string]interface{}{"resources": res}})
+	return resource.Resource(ref, map[string]interface{}{"spec": spec})
 }
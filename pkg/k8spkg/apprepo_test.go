@@ -9,6 +9,8 @@ import (
 	"github.com/mgoltzsche/k8spkg/pkg/client/mock"
 	"github.com/mgoltzsche/k8spkg/pkg/resource"
 	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 var testApp = &App{Name: "myapp", Namespace: "myns", Resources: []resource.K8sResourceRef{
@@ -41,7 +43,11 @@ func testAppResource(t *testing.T, apps ...*App) resource.K8sResourceList {
 				"namespace":  r.Namespace(),
 			}
 		}
-		appRes[i] = resource.Resource(ref, map[string]interface{}{"spec": map[string]interface{}{"resources": res}})
+		spec := map[string]interface{}{"resources": res}
+		if app.Context != "" {
+			spec["context"] = app.Context
+		}
+		appRes[i] = resource.Resource(ref, map[string]interface{}{"spec": spec})
 	}
 	return appRes
 }
@@ -77,6 +83,37 @@ func TestAppRepoGet(t *testing.T) {
 	})
 }
 
+func TestAppResourceDigests(t *testing.T) {
+	app := *testApp
+	app.Digest = "sha256:pkg"
+	app.Digests = map[string]string{app.Resources[0].ID(): "sha256:res"}
+	converted, err := appFromResource(resourceFromApp(&app))
+	require.NoError(t, err)
+	require.Equal(t, &app, converted)
+}
+
+func TestAppRepoPutConflict(t *testing.T) {
+	c := mock.NewClientMock()
+	c.MockErr = apierrors.NewConflict(schema.GroupResource{Group: CrdAPIGroup, Resource: "applications"}, testApp.Name, fmt.Errorf("the object has been modified"))
+	err := NewAppRepo(c).Put(context.Background(), testApp)
+	require.Error(t, err)
+	require.Equal(t, appPutConflictRetries+1, len(c.Calls), "apply calls")
+}
+
+func TestAppRepoContext(t *testing.T) {
+	app := *testApp
+	app.Context = "myctx"
+	c := mock.NewClientMock()
+	testee := NewAppRepo(c)
+	err := testee.Put(context.Background(), &app)
+	require.NoError(t, err)
+	require.Equal(t, testAppResource(t, &app), c.Applied, "put")
+	c.MockResource = c.Applied[0]
+	retrieved, err := testee.Get(context.Background(), app.Namespace, app.Name)
+	require.NoError(t, err)
+	require.Equal(t, &app, retrieved, "get")
+}
+
 func TestAppRepoGetAll(t *testing.T) {
 	testApp2 := &App{
 		Name:      "anotherapp",
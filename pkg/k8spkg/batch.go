@@ -0,0 +1,167 @@
+package k8spkg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/mgoltzsche/k8spkg/pkg/client"
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/pkg/errors"
+)
+
+// BatchOptions specifies how the resources of a package are split up and applied
+type BatchOptions struct {
+	// Size is the max number of resources per batch - 0 puts all resources of a kind into one batch
+	Size int
+	// Concurrency is the max number of batches that are applied in parallel
+	Concurrency int
+}
+
+// DefaultBatchOptions applies up to 4 batches of 50 resources in parallel
+var DefaultBatchOptions = BatchOptions{Size: 50, Concurrency: 4}
+
+// applyBatch is a set of resources of the same kind that is applied at once
+type applyBatch struct {
+	kind      string
+	resources resource.K8sResourceList
+}
+
+// applyStages splits the resources into stages per kind in install order.
+// Each stage contains the kind's resources in batches of the max size.
+func applyStages(resources resource.K8sResourceList, size int) (stages [][]*applyBatch) {
+	byKind := map[string]int{}
+	var kinds []string
+	var resByKind []resource.K8sResourceList
+	for _, res := range sortForInstall(resources) {
+		kind := res.QualifiedKind()
+		i, ok := byKind[kind]
+		if !ok {
+			i = len(kinds)
+			byKind[kind] = i
+			kinds = append(kinds, kind)
+			resByKind = append(resByKind, nil)
+		}
+		resByKind[i] = append(resByKind[i], res)
+	}
+	for i, l := range resByKind {
+		var stage []*applyBatch
+		for len(l) > 0 {
+			n := len(l)
+			if size > 0 && n > size {
+				n = size
+			}
+			stage = append(stage, &applyBatch{kinds[i], l[:n]})
+			l = l[n:]
+		}
+		stages = append(stages, stage)
+	}
+	return
+}
+
+// applyBatches applies the resources stage by stage, the batches of a stage
+// concurrently, and reports the progress per batch.
+// No further batch is started when the context is cancelled or a stage failed.
+// Pruning is not supported since it requires all resources to be applied at once.
+func (m *PackageManager) applyBatches(ctx context.Context, namespace string, resources resource.K8sResourceList, opts client.ApplyOptions) (results client.ApplyResults, err error) {
+	stages := applyStages(resources, m.batch.Size)
+	total := 0
+	for _, stage := range stages {
+		total += len(stage)
+	}
+	concurrency := m.batch.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var (
+		conflicts []*client.FieldConflict
+		failed    bool
+		done      int
+		mutex     sync.Mutex
+	)
+	for _, stage := range stages {
+		stageResults := make([]client.ApplyResults, len(stage))
+		slots := make(chan struct{}, concurrency)
+		wg := sync.WaitGroup{}
+		for i, batch := range stage {
+			slots <- struct{}{}
+			if e := ctx.Err(); e != nil {
+				mutex.Lock()
+				err = errors.WithStack(e)
+				mutex.Unlock()
+				break
+			}
+			wg.Add(1)
+			go func(i int, batch *applyBatch) {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				r, e := m.client.Apply(ctx, namespace, batch.resources, opts)
+				mutex.Lock()
+				defer mutex.Unlock()
+				done++
+				stageResults[i] = r
+				if e == nil {
+					m.log.Infof("Applied batch %d/%d: %d %s (%s)", done, total, len(batch.resources), batch.kind, summarizeResults(r))
+					return
+				}
+				m.log.Warnf("Failed to apply batch %d/%d: %d %s (%s)", done, total, len(batch.resources), batch.kind, summarizeResults(r))
+				switch cause := errors.Cause(e).(type) {
+				case *client.ApplyError:
+					failed = true
+				case *client.ConflictError:
+					conflicts = append(conflicts, cause.Conflicts...)
+				default:
+					if err == nil {
+						err = e
+					}
+				}
+			}(i, batch)
+		}
+		wg.Wait()
+		for _, r := range stageResults {
+			results = append(results, r...)
+		}
+		if err != nil || failed || len(conflicts) > 0 {
+			break
+		}
+	}
+	if err == nil && (failed || len(conflicts) > 0) {
+		err = &client.ApplyError{Results: results}
+		if len(conflicts) > 0 && onlyConflicts(results.Failed()) {
+			err = &client.ConflictError{Conflicts: conflicts}
+		}
+	}
+	return
+}
+
+func onlyConflicts(failed client.ApplyResults) bool {
+	for _, r := range failed {
+		if !client.IsConflict(r.Error) {
+			return false
+		}
+	}
+	return true
+}
+
+// summarizeResults returns the number of resources per action
+func summarizeResults(results client.ApplyResults) string {
+	var actions []client.ApplyAction
+	counts := map[client.ApplyAction]int{}
+	for _, r := range results {
+		if counts[r.Action] == 0 {
+			actions = append(actions, r.Action)
+		}
+		counts[r.Action]++
+	}
+	if len(actions) == 0 {
+		return "no changes"
+	}
+	summary := make([]string, len(actions))
+	for i, a := range actions {
+		summary[i] = fmt.Sprintf("%d %s", counts[a], a)
+	}
+	return strings.Join(summary, ", ")
+}
@@ -0,0 +1,71 @@
+package k8spkg
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/mgoltzsche/k8spkg/pkg/client"
+	"github.com/mgoltzsche/k8spkg/pkg/client/mock"
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfigMaps(n int) (l resource.K8sResourceList) {
+	for i := 0; i < n; i++ {
+		l = append(l, resource.FromMap(map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": fmt.Sprintf("cm%d", i)},
+		}))
+	}
+	return
+}
+
+func TestApplyStages(t *testing.T) {
+	obj := mock.MockResourceList("../client/mock/get-list.json")
+	resources := append(testConfigMaps(5), obj...)
+	configMaps := 0
+	for _, res := range resources {
+		if res.QualifiedKind() == "configmap" {
+			configMaps++
+		}
+	}
+	stages := applyStages(resources, 2)
+	var kinds []string
+	var sizes [][]int
+	count := 0
+	for _, stage := range stages {
+		var stageSizes []int
+		for _, batch := range stage {
+			require.Equal(t, stage[0].kind, batch.kind, "kind within stage")
+			stageSizes = append(stageSizes, len(batch.resources))
+			count += len(batch.resources)
+		}
+		kinds = append(kinds, stage[0].kind)
+		sizes = append(sizes, stageSizes)
+	}
+	require.Equal(t, "configmap", kinds[0], "first stage kind")
+	require.Equal(t, (configMaps+1)/2, len(sizes[0]), "batches of first stage")
+	require.Equal(t, 2, sizes[0][0], "batch size")
+	require.Equal(t, len(resources), count, "resource count")
+	require.Equal(t, 1, len(applyStages(resources[:5], 0)[0]), "batches without size limit")
+}
+
+func TestApplyBatches(t *testing.T) {
+	c := mock.NewClientMock()
+	testee := NewPackageManager(c, "myns")
+	testee.SetBatchOptions(BatchOptions{Size: 3, Concurrency: 2})
+	resources := testConfigMaps(7)
+	results, err := testee.applyBatches(context.Background(), "myns", resources, client.ApplyOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(c.Calls), "apply calls")
+	require.Equal(t, len(resources), len(results), "results")
+
+	c = mock.NewClientMock()
+	c.MockErr = fmt.Errorf("error mock")
+	testee = NewPackageManager(c, "myns")
+	_, err = testee.applyBatches(context.Background(), "myns", append(testConfigMaps(2), mock.MockResourceList("../client/mock/get-list.json")...), client.ApplyOptions{})
+	require.Error(t, err)
+	require.Equal(t, 1, len(c.Calls), "apply calls after failed stage")
+}
@@ -0,0 +1,285 @@
+package k8spkg
+
+import (
+	"strings"
+
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// podSpecPaths maps the workload kinds to the path of their pod spec
+var podSpecPaths = map[string][]string{
+	"Pod":                   {"spec"},
+	"Deployment":            {"spec", "template", "spec"},
+	"StatefulSet":           {"spec", "template", "spec"},
+	"DaemonSet":             {"spec", "template", "spec"},
+	"ReplicaSet":            {"spec", "template", "spec"},
+	"ReplicationController": {"spec", "template", "spec"},
+	"Job":                   {"spec", "template", "spec"},
+	"CronJob":               {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// dependencyGraph maps each resource's index to the indices of the resources it depends on
+type dependencyGraph struct {
+	resources resource.K8sResourceList
+	deps      []map[int]bool
+	byName    map[string]int
+}
+
+// dependencyStages splits the resources into stages that can be applied one after another
+// so that each resource is applied after the resources it depends on became ready:
+//   - resources within a namespace depend on the Namespace
+//   - custom resources depend on their CustomResourceDefinition,
+//     the APIService that serves their group and the webhooks that intercept it
+//   - webhooks and APIServices depend on their Service and the workloads it selects
+//   - workloads depend on the ServiceAccount, ConfigMaps and Secrets their pods refer to
+//
+// Only dependencies within the package are considered.
+// The resources within each stage are sorted in install order.
+func dependencyStages(resources resource.K8sResourceList, namespace string) (stages []resource.K8sResourceList, err error) {
+	g := newDependencyGraph(resources, namespace)
+	levels := make([]int, len(resources))
+	for i := range levels {
+		levels[i] = -1
+	}
+	visiting := map[int]bool{}
+	var level func(i int) (int, error)
+	level = func(i int) (int, error) {
+		if levels[i] >= 0 {
+			return levels[i], nil
+		}
+		if visiting[i] {
+			return 0, errors.Errorf("dependency cycle at %s", displayName(resources[i]))
+		}
+		visiting[i] = true
+		l := 0
+		for dep := range g.deps[i] {
+			depLevel, err := level(dep)
+			if err != nil {
+				return 0, err
+			}
+			if depLevel+1 > l {
+				l = depLevel + 1
+			}
+		}
+		visiting[i] = false
+		levels[i] = l
+		return l, nil
+	}
+	for i := range resources {
+		l, err := level(i)
+		if err != nil {
+			return nil, err
+		}
+		for len(stages) <= l {
+			stages = append(stages, nil)
+		}
+		stages[l] = append(stages[l], resources[i])
+	}
+	for i, stage := range stages {
+		stages[i] = sortForInstall(stage)
+	}
+	return
+}
+
+func newDependencyGraph(resources resource.K8sResourceList, namespace string) *dependencyGraph {
+	g := &dependencyGraph{resources, make([]map[int]bool, len(resources)), map[string]int{}}
+	crds := map[string]int{}
+	apiServices := map[string][]int{}
+	var webhooks []int
+	for i, res := range resources {
+		g.deps[i] = map[int]bool{}
+		g.byName[nameKey(res.Kind(), resourceNamespace(res, namespace), res.Name())] = i
+		obj := res.Raw()
+		switch res.Kind() {
+		case "CustomResourceDefinition":
+			group, _, _ := unstructured.NestedString(obj, "spec", "group")
+			kind, _, _ := unstructured.NestedString(obj, "spec", "names", "kind")
+			crds[kind+"."+group] = i
+		case "APIService":
+			// only APIServices that are served by an extension API server
+			group, _, _ := unstructured.NestedString(obj, "spec", "group")
+			if svc, _, _ := unstructured.NestedString(obj, "spec", "service", "name"); svc != "" && group != "" {
+				apiServices[group] = append(apiServices[group], i)
+			}
+		case "MutatingWebhookConfiguration", "ValidatingWebhookConfiguration":
+			webhooks = append(webhooks, i)
+		}
+	}
+	for i, res := range resources {
+		ns := resourceNamespace(res, namespace)
+		group := apiGroup(res.APIVersion())
+		if res.Kind() != "Namespace" && ns != "" {
+			g.dependOn(i, "Namespace", "", ns)
+		}
+		if crd, ok := crds[res.Kind()+"."+group]; ok {
+			g.deps[i][crd] = true
+		}
+		if res.Kind() != "APIService" {
+			for _, svc := range apiServices[group] {
+				g.deps[i][svc] = true
+			}
+		}
+		if installRank(res) == len(installOrder) {
+			for _, webhook := range webhooks {
+				if interceptsGroup(resources[webhook], group) {
+					g.deps[i][webhook] = true
+				}
+			}
+		}
+		switch res.Kind() {
+		case "APIService":
+			g.dependOnService(i, res.Raw(), namespace, "spec", "service")
+		case "MutatingWebhookConfiguration", "ValidatingWebhookConfiguration":
+			hooks, _, _ := unstructured.NestedSlice(res.Raw(), "webhooks")
+			for _, hook := range hooks {
+				if h, ok := hook.(map[string]interface{}); ok {
+					g.dependOnService(i, h, namespace, "clientConfig", "service")
+				}
+			}
+		}
+		if path, ok := podSpecPaths[res.Kind()]; ok {
+			if podSpec, ok, _ := unstructured.NestedMap(res.Raw(), path...); ok {
+				for _, ref := range podSpecRefs(podSpec) {
+					g.dependOn(i, ref[0], ns, ref[1])
+				}
+			}
+		}
+	}
+	for i := range g.deps {
+		delete(g.deps[i], i)
+	}
+	return g
+}
+
+// dependOn adds a dependency to the identified resource if it is part of the package
+func (g *dependencyGraph) dependOn(i int, kind, namespace, name string) {
+	if dep, ok := g.byName[nameKey(kind, namespace, name)]; ok {
+		g.deps[i][dep] = true
+	}
+}
+
+// dependOnService adds a dependency to the service referenced at the path within obj
+// and the workloads it selects
+func (g *dependencyGraph) dependOnService(i int, obj map[string]interface{}, namespace string, path ...string) {
+	name, _, _ := unstructured.NestedString(obj, append(path, "name")...)
+	ns, _, _ := unstructured.NestedString(obj, append(path, "namespace")...)
+	if ns == "" {
+		ns = namespace
+	}
+	svc, ok := g.byName[nameKey("Service", ns, name)]
+	if !ok {
+		return
+	}
+	g.deps[i][svc] = true
+	selector, ok, _ := unstructured.NestedStringMap(g.resources[svc].Raw(), "spec", "selector")
+	if !ok || len(selector) == 0 {
+		return
+	}
+	for j, res := range g.resources {
+		path, ok := podSpecPaths[res.Kind()]
+		if !ok || resourceNamespace(res, namespace) != ns {
+			continue
+		}
+		labelsPath := append(append([]string{}, path[:len(path)-1]...), "metadata", "labels")
+		labels, _, _ := unstructured.NestedStringMap(res.Raw(), labelsPath...)
+		if matchesSelector(labels, selector) {
+			g.deps[i][j] = true
+		}
+	}
+}
+
+// podSpecRefs returns the kind and name of the ServiceAccount, ConfigMaps and Secrets a pod spec refers to
+func podSpecRefs(podSpec map[string]interface{}) (refs [][2]string) {
+	add := func(kind string, obj map[string]interface{}, path ...string) {
+		if name, _, _ := unstructured.NestedString(obj, path...); name != "" {
+			refs = append(refs, [2]string{kind, name})
+		}
+	}
+	add("ServiceAccount", podSpec, "serviceAccountName")
+	for _, secret := range nestedMaps(podSpec, "imagePullSecrets") {
+		add("Secret", secret, "name")
+	}
+	for _, vol := range nestedMaps(podSpec, "volumes") {
+		add("ConfigMap", vol, "configMap", "name")
+		add("Secret", vol, "secret", "secretName")
+		for _, src := range nestedMaps(vol, "projected", "sources") {
+			add("ConfigMap", src, "configMap", "name")
+			add("Secret", src, "secret", "name")
+		}
+	}
+	for _, containers := range []string{"initContainers", "containers"} {
+		for _, c := range nestedMaps(podSpec, containers) {
+			for _, env := range nestedMaps(c, "env") {
+				add("ConfigMap", env, "valueFrom", "configMapKeyRef", "name")
+				add("Secret", env, "valueFrom", "secretKeyRef", "name")
+			}
+			for _, envFrom := range nestedMaps(c, "envFrom") {
+				add("ConfigMap", envFrom, "configMapRef", "name")
+				add("Secret", envFrom, "secretRef", "name")
+			}
+		}
+	}
+	return
+}
+
+// interceptsGroup returns true if a rule of the webhook configuration matches the API group
+func interceptsGroup(webhookConfig *resource.K8sResource, group string) bool {
+	for _, hook := range nestedMaps(webhookConfig.Raw(), "webhooks") {
+		for _, rule := range nestedMaps(hook, "rules") {
+			groups, _, _ := unstructured.NestedStringSlice(rule, "apiGroups")
+			for _, g := range groups {
+				if g == group || g == "*" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func nestedMaps(obj map[string]interface{}, path ...string) (l []map[string]interface{}) {
+	items, _, _ := unstructured.NestedSlice(obj, path...)
+	for _, item := range items {
+		if m, ok := item.(map[string]interface{}); ok {
+			l = append(l, m)
+		}
+	}
+	return
+}
+
+func matchesSelector(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func nameKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// resourceNamespace returns the resource's namespace or the default namespace if it has none.
+// Cluster-scoped kinds the order knows about always return an empty namespace.
+func resourceNamespace(res *resource.K8sResource, namespace string) string {
+	switch res.Kind() {
+	case "Namespace", "CustomResourceDefinition", "APIService", "ClusterRole", "ClusterRoleBinding",
+		"MutatingWebhookConfiguration", "ValidatingWebhookConfiguration",
+		"PriorityClass", "StorageClass", "PersistentVolume", "PodSecurityPolicy":
+		return ""
+	}
+	if ns := res.Namespace(); ns != "" {
+		return ns
+	}
+	return namespace
+}
+
+func apiGroup(apiVersion string) string {
+	if i := strings.Index(apiVersion, "/"); i > 0 {
+		return apiVersion[:i]
+	}
+	return ""
+}
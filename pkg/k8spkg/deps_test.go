@@ -0,0 +1,142 @@
+package k8spkg
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/mgoltzsche/k8spkg/pkg/client"
+	"github.com/mgoltzsche/k8spkg/pkg/client/fakecluster"
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/stretchr/testify/require"
+)
+
+const dependentManifest = `
+apiVersion: certmanager.k8s.io/v1alpha1
+kind: Issuer
+metadata:
+  name: myissuer
+---
+apiVersion: admissionregistration.k8s.io/v1beta1
+kind: ValidatingWebhookConfiguration
+metadata:
+  name: mywebhook
+webhooks:
+- name: webhook.certmanager.k8s.io
+  rules:
+  - apiGroups: ["certmanager.k8s.io"]
+    apiVersions: ["v1alpha1"]
+    operations: ["CREATE", "UPDATE"]
+    resources: ["*/*"]
+  clientConfig:
+    service:
+      name: mywebhook
+      namespace: myns
+---
+apiVersion: apiregistration.k8s.io/v1beta1
+kind: APIService
+metadata:
+  name: v1beta1.webhook.certmanager.k8s.io
+spec:
+  group: webhook.certmanager.k8s.io
+  version: v1beta1
+  service:
+    name: mywebhook
+    namespace: myns
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: mywebhook
+spec:
+  selector:
+    app: mywebhook
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: mywebhook
+spec:
+  template:
+    metadata:
+      labels:
+        app: mywebhook
+    spec:
+      serviceAccountName: mysa
+      containers:
+      - name: webhook
+        envFrom:
+        - configMapRef:
+            name: myconfig
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: myconfig
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: mysa
+---
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: issuers.certmanager.k8s.io
+spec:
+  group: certmanager.k8s.io
+  version: v1alpha1
+  scope: Namespaced
+  names:
+    kind: Issuer
+    plural: issuers
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: myns
+`
+
+func TestDependencyStages(t *testing.T) {
+	resources, err := resource.FromReader(bytes.NewReader([]byte(dependentManifest)))
+	require.NoError(t, err)
+	stages, err := dependencyStages(resources, "myns")
+	require.NoError(t, err)
+	var names [][]string
+	for _, stage := range stages {
+		names = append(names, stage.Refs().Names())
+	}
+	expected := [][]string{
+		{"namespace/myns", "customresourcedefinition.apiextensions.k8s.io/issuers.certmanager.k8s.io"},
+		{"serviceaccount/mysa", "configmap/myconfig", "service/mywebhook"},
+		{"deployment.apps/mywebhook"},
+		{"validatingwebhookconfiguration.admissionregistration.k8s.io/mywebhook", "apiservice.apiregistration.k8s.io/v1beta1.webhook.certmanager.k8s.io"},
+		{"issuer.certmanager.k8s.io/myissuer"},
+	}
+	require.Equal(t, expected, names, "stages")
+}
+
+func TestPackageManagerApplyStages(t *testing.T) {
+	ctx := context.Background()
+	cluster := fakecluster.New(fakecluster.Options{})
+	crd := resource.FromMap(map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1beta1",
+		"kind":       "CustomResourceDefinition",
+		"metadata":   map[string]interface{}{"name": "things.example.org"},
+		"spec": map[string]interface{}{
+			"group":   "example.org",
+			"version": "v1",
+			"scope":   "Namespaced",
+			"names":   map[string]interface{}{"kind": "Thing", "plural": "things"},
+		},
+	})
+	cr := resource.Resource(resource.ResourceRef("example.org/v1", "Thing", "", "mything"), map[string]interface{}{})
+	pkg := &K8sPackage{"somepkg", resource.K8sResourceList{cr, crd}}
+	for _, res := range pkg.Resources {
+		res.Raw()["metadata"].(map[string]interface{})["labels"] = map[string]interface{}{PKG_NAME_LABEL: pkg.Name}
+	}
+	err := NewPackageManager(cluster, "myns").Apply(ctx, pkg, client.ApplyOptions{})
+	require.NoError(t, err, "custom resource should be applied after its CRD is established")
+	_, err = cluster.GetResource(ctx, "thing.example.org", "myns", "mything")
+	require.NoError(t, err, "get custom resource")
+}
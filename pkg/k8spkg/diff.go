@@ -0,0 +1,295 @@
+package k8spkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/mgoltzsche/k8spkg/pkg/client"
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Diff writes a unified diff between the live state of the package's resources
+// and the provided package to writer.
+// Resources that would be added or removed (previously installed but not
+// contained within the package anymore) are flagged as such.
+// Returns true if any difference has been found.
+func (m *PackageManager) Diff(ctx context.Context, pkg *K8sPackage, writer io.Writer) (changed bool, err error) {
+	for _, res := range pkg.Resources {
+		ns := res.Namespace()
+		if ns == "" {
+			ns = m.namespace
+		}
+		live, e := m.client.GetResource(ctx, res.QualifiedKind(), ns, res.Name())
+		if e != nil && !client.IsNotFound(e) {
+			return changed, errors.Wrapf(e, "diff %s", res.ID())
+		}
+		c, e := writeDiff(writer, live, res, m.showSecrets)
+		if e != nil {
+			return changed, errors.Wrapf(e, "diff %s", res.ID())
+		}
+		changed = changed || c
+	}
+	app, err := m.installedApps.Get(ctx, m.namespace, pkg.Name)
+	if err != nil {
+		if client.IsNotFound(err) {
+			err = nil
+		}
+		return
+	}
+	if err = m.loadResourceTypes(ctx); err != nil {
+		return
+	}
+	for _, ref := range app.Resources {
+		if m.containsResource(pkg.Resources, ref) {
+			continue
+		}
+		live, e := m.client.GetResource(ctx, ref.QualifiedKind(), ref.Namespace(), ref.Name())
+		if e != nil {
+			if client.IsNotFound(e) {
+				continue
+			}
+			return changed, errors.Wrapf(e, "diff %s", ref.ID())
+		}
+		if _, e = writeDiff(writer, live, nil, m.showSecrets); e != nil {
+			return changed, errors.Wrapf(e, "diff %s", ref.ID())
+		}
+		changed = true
+	}
+	return
+}
+
+// unprunedFields are never defaulted by the server and therefore compared entirely
+var unprunedFields = [][]string{
+	{"metadata", "labels"},
+	{"metadata", "annotations"},
+}
+
+// writeDiff writes the unified diff between the live and the desired resource.
+// Either of both can be nil to indicate that the resource is added or removed.
+// When both are provided only the live fields that are specified by the
+// desired resource or its last applied configuration are compared in order
+// to ignore fields the server populates with default values.
+func writeDiff(writer io.Writer, live, desired *resource.K8sResource, showSecrets bool) (changed bool, err error) {
+	var (
+		liveObj, desiredObj map[string]interface{}
+		fromFile, toFile    = "/dev/null", "/dev/null"
+		ref                 resource.K8sResourceRef
+		state               = "changed"
+	)
+	if live != nil {
+		ref = live
+		fromFile = "live/" + diffFileName(live)
+		if liveObj, err = normalizedObject(live, "", showSecrets); err != nil {
+			return
+		}
+	} else {
+		state = "added"
+	}
+	if desired != nil {
+		ref = desired
+		toFile = "package/" + diffFileName(desired)
+		ns := ""
+		if live != nil {
+			ns = live.Namespace()
+		}
+		if desiredObj, err = normalizedObject(desired, ns, showSecrets); err != nil {
+			return
+		}
+	} else {
+		state = "removed"
+	}
+	if liveObj != nil && desiredObj != nil {
+		masks := []interface{}{desiredObj}
+		if lastApplied := lastAppliedConfig(live); lastApplied != nil {
+			masks = append(masks, lastApplied)
+		}
+		pruned := pruneFields(liveObj, masks).(map[string]interface{})
+		for _, field := range unprunedFields {
+			if v, ok, _ := unstructured.NestedFieldNoCopy(liveObj, field...); ok {
+				unstructured.SetNestedField(pruned, v, field...)
+			}
+		}
+		liveObj = pruned
+	}
+	from, err := toYaml(liveObj)
+	if err != nil {
+		return
+	}
+	to, err := toYaml(desiredObj)
+	if err != nil {
+		return
+	}
+	if from == to {
+		return false, nil
+	}
+	if _, err = fmt.Fprintf(writer, "# %s %s\n", displayName(ref), state); err != nil {
+		return
+	}
+	err = difflib.WriteUnifiedDiff(writer, difflib.UnifiedDiff{
+		A:        difflib.SplitLines(from),
+		B:        difflib.SplitLines(to),
+		FromFile: fromFile,
+		ToFile:   toFile,
+		Context:  3,
+	})
+	return true, err
+}
+
+// lastAppliedConfig returns the object stored within the resource's
+// last-applied-configuration annotation or nil if there is none.
+func lastAppliedConfig(res *resource.K8sResource) (obj map[string]interface{}) {
+	s, _, _ := unstructured.NestedString(res.Raw(), "metadata", "annotations", corev1.LastAppliedConfigAnnotation)
+	if s == "" || json.Unmarshal([]byte(s), &obj) != nil {
+		return nil
+	}
+	return
+}
+
+// pruneFields returns a copy of the live value that contains only the fields
+// that are also specified within at least one of the provided masks.
+// List items are matched by their name if they have one, otherwise by index.
+// Live list items without counterpart are kept entirely.
+func pruneFields(live interface{}, masks []interface{}) interface{} {
+	switch l := live.(type) {
+	case map[string]interface{}:
+		r := map[string]interface{}{}
+		for k, v := range l {
+			var sub []interface{}
+			for _, mask := range masks {
+				if m, ok := mask.(map[string]interface{}); ok {
+					if mv, ok := m[k]; ok {
+						sub = append(sub, mv)
+					}
+				}
+			}
+			if len(sub) > 0 {
+				r[k] = pruneFields(v, sub)
+			}
+		}
+		return r
+	case []interface{}:
+		r := make([]interface{}, len(l))
+		for i, item := range l {
+			var sub []interface{}
+			for _, mask := range masks {
+				if m, ok := mask.([]interface{}); ok {
+					if mv := matchingListItem(m, item, i); mv != nil {
+						sub = append(sub, mv)
+					}
+				}
+			}
+			if len(sub) > 0 {
+				item = pruneFields(item, sub)
+			}
+			r[i] = item
+		}
+		return r
+	default:
+		return live
+	}
+}
+
+// matchingListItem returns the list item that has the same name as the
+// provided item or, if it has no name, the item at the provided index.
+func matchingListItem(list []interface{}, item interface{}, index int) interface{} {
+	if m, ok := item.(map[string]interface{}); ok {
+		if name, ok := m["name"]; ok {
+			for _, c := range list {
+				if cm, ok := c.(map[string]interface{}); ok && cm["name"] == name {
+					return c
+				}
+			}
+			return nil
+		}
+	}
+	if index < len(list) {
+		return list[index]
+	}
+	return nil
+}
+
+func diffFileName(ref resource.K8sResourceRef) string {
+	ns := ref.Namespace()
+	if ns == "" {
+		ns = "_"
+	}
+	return fmt.Sprintf("%s/%s/%s", ref.QualifiedKind(), ns, ref.Name())
+}
+
+// normalizedObject returns the resource's content without server-populated fields.
+// The namespace is set to the provided one if the resource does not specify it.
+func normalizedObject(res *resource.K8sResource, namespace string, showSecrets bool) (map[string]interface{}, error) {
+	obj, err := res.Normalized()
+	if err != nil {
+		return nil, err
+	}
+	if res.IsSecret() {
+		normalizeSecret(obj, showSecrets)
+	}
+	if namespace != "" && res.Namespace() == "" {
+		unstructured.SetNestedField(obj, namespace, "metadata", "namespace")
+	}
+	return obj, nil
+}
+
+func toYaml(obj map[string]interface{}) (string, error) {
+	if obj == nil {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	err := yaml.NewEncoder(&buf).Encode(obj)
+	return buf.String(), err
+}
+
+// secretHashKey is generated randomly per process so that the hashes of
+// Secret values cannot be brute-forced or correlated across runs.
+var secretHashKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(errors.Wrap(err, "generate secret hash key"))
+	}
+	return key
+}()
+
+// normalizeSecret merges a Secret's stringData into its data and replaces
+// each value with the HMAC of its decoded value in order to compare the
+// values without revealing them - or with the decoded value if showSecrets is enabled.
+func normalizeSecret(obj map[string]interface{}, showSecrets bool) {
+	values := map[string]interface{}{}
+	data, _, _ := unstructured.NestedMap(obj, "data")
+	for k, v := range data {
+		s, _ := v.(string)
+		if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+			s = string(b)
+		}
+		values[k] = s
+	}
+	stringData, _, _ := unstructured.NestedMap(obj, "stringData")
+	for k, v := range stringData {
+		values[k] = fmt.Sprintf("%v", v)
+	}
+	if !showSecrets {
+		for k, v := range values {
+			mac := hmac.New(sha256.New, secretHashKey)
+			mac.Write([]byte(v.(string)))
+			values[k] = fmt.Sprintf("hmac-sha256:%x", mac.Sum(nil))
+		}
+	}
+	delete(obj, "stringData")
+	delete(obj, "data")
+	if len(values) > 0 {
+		obj["data"] = values
+	}
+}
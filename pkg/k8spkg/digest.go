@@ -0,0 +1,121 @@
+package k8spkg
+
+import (
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+)
+
+// revision holds the digests of the package revision that is being applied
+type revision struct {
+	// digest is the package digest
+	digest string
+	// digests maps the resolved resource IDs to the resource digests
+	digests map[string]string
+	// unchanged contains the IDs of the resources that equal the installed revision
+	unchanged map[string]bool
+	// excluded contains the IDs of the resources that are not selected to be applied
+	excluded map[string]bool
+	// installed maps the resource IDs to the digests of the installed revision
+	installed map[string]string
+	ids       map[*resource.K8sResource]string
+}
+
+// packageRevision computes the digests of the package and its resources.
+// A resource is unchanged if its digest equals the one recorded when the
+// installed revision has been applied successfully.
+// A resource is excluded if the selection doesn't match it.
+func packageRevision(pkg *K8sPackage, refs resource.K8sResourceRefList, installed *App, selection *resource.Selection) (r *revision, err error) {
+	r = &revision{
+		digests:   map[string]string{},
+		unchanged: map[string]bool{},
+		excluded:  map[string]bool{},
+		ids:       map[*resource.K8sResource]string{},
+	}
+	if installed != nil {
+		r.installed = installed.Digests
+	}
+	if r.digest, err = pkg.Resources.Digest(); err != nil {
+		return
+	}
+	for i, res := range pkg.Resources {
+		id := refs[i].ID()
+		r.ids[res] = id
+		if r.digests[id], err = res.Digest(); err != nil {
+			return
+		}
+		if d, ok := r.installed[id]; ok && d == r.digests[id] {
+			r.unchanged[id] = true
+		}
+		if !selection.Match(res) {
+			r.excluded[id] = true
+		}
+	}
+	return
+}
+
+// hasChanges returns true if any resource differs from the installed revision
+func (r *revision) hasChanges() bool {
+	return len(r.unchanged) < len(r.ids)
+}
+
+// unchangedDigests returns the digests of the unchanged resources only.
+// The digests of the changed resources are recorded after they have been
+// applied successfully in order to apply them again when the apply fails.
+// Excluded resources keep the digest of the installed revision.
+func (r *revision) unchangedDigests() map[string]string {
+	digests := map[string]string{}
+	for id := range r.unchanged {
+		digests[id] = r.digests[id]
+	}
+	for id := range r.excluded {
+		if d, ok := r.installed[id]; ok {
+			digests[id] = d
+		}
+	}
+	return digests
+}
+
+// appliedDigests returns the package and resource digests that are recorded
+// after the selected resources have been applied successfully.
+// The package digest is returned only if no changed resource is excluded.
+func (r *revision) appliedDigests() (digest string, digests map[string]string) {
+	digests = r.unchangedDigests()
+	complete := true
+	for id, d := range r.digests {
+		if !r.excluded[id] {
+			digests[id] = d
+		} else if !r.unchanged[id] {
+			complete = false
+		}
+	}
+	if complete {
+		digest = r.digest
+	}
+	return
+}
+
+// changedStages removes the unchanged resources from the stages
+// dropping the stages that don't contain any changed resource.
+func (r *revision) changedStages(stages []resource.K8sResourceList) []resource.K8sResourceList {
+	return r.filterStages(stages, r.unchanged)
+}
+
+// selectedStages removes the excluded resources from the stages
+// dropping the stages that don't contain any selected resource.
+func (r *revision) selectedStages(stages []resource.K8sResourceList) []resource.K8sResourceList {
+	return r.filterStages(stages, r.excluded)
+}
+
+func (r *revision) filterStages(stages []resource.K8sResourceList, skip map[string]bool) (filtered []resource.K8sResourceList) {
+	for _, stage := range stages {
+		var l resource.K8sResourceList
+		for _, res := range stage {
+			if !skip[r.ids[res]] {
+				l = append(l, res)
+			}
+		}
+		if len(l) > 0 {
+			filtered = append(filtered, l)
+		}
+	}
+	return
+}
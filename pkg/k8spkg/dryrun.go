@@ -0,0 +1,156 @@
+package k8spkg
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mgoltzsche/k8spkg/pkg/client"
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// dryRunApply simulates the apply and logs the resources that would be created, configured or pruned
+func (m *PackageManager) dryRunApply(ctx context.Context, pkg *K8sPackage, opts client.ApplyOptions) (err error) {
+	m.log.Infof("Applying package %s (%s dry run)...", pkg.Name, opts.DryRun)
+	if m.createNs {
+		missing, e := m.missingNamespaces(ctx, m.targetNamespaces(pkg))
+		if e != nil {
+			return e
+		}
+		for _, ns := range missing {
+			m.logDryRun(namespaceRef(ns), "created", opts.DryRun)
+		}
+	}
+	results, err := m.client.Apply(ctx, m.namespace, sortForInstall(pkg.Resources.Filter(m.selection.Match)), opts)
+	if err != nil {
+		return
+	}
+	for _, r := range results {
+		m.logDryRun(r.Resource, string(r.Action), opts.DryRun)
+	}
+	if opts.Prune {
+		installed, e := m.installedApp(ctx, pkg.Name)
+		if e != nil {
+			return e
+		}
+		pruned, e := m.pruneCandidates(ctx, pkg, installed, opts.Labels)
+		if e != nil {
+			return e
+		}
+		for _, ref := range pruned {
+			m.logDryRun(ref, "pruned", opts.DryRun)
+		}
+	}
+	return
+}
+
+// dryRunDelete simulates the deletion and logs the resources that would be deleted
+func (m *PackageManager) dryRunDelete(ctx context.Context, refs resource.K8sResourceRefList, opts client.DeleteOptions) (err error) {
+	if err = m.client.Delete(ctx, m.namespace, refs, opts); err != nil {
+		return
+	}
+	for _, ref := range refs {
+		exists, e := m.exists(ctx, ref)
+		if e != nil {
+			return e
+		}
+		if exists {
+			m.logDryRun(ref, "deleted", opts.DryRun)
+		}
+	}
+	return
+}
+
+func (m *PackageManager) exists(ctx context.Context, ref resource.K8sResourceRef) (bool, error) {
+	ns := ref.Namespace()
+	if ns == "" {
+		ns = m.namespace
+	}
+	_, err := m.client.GetResource(ctx, ref.QualifiedKind(), ns, ref.Name())
+	if client.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// pruneCandidates returns the selected live resources labeled with the package that are not contained within the package.
+// Their kinds are derived from the package and its installed Application record (if any).
+func (m *PackageManager) pruneCandidates(ctx context.Context, pkg *K8sPackage, installed *App, labels []string) (pruned resource.K8sResourceRefList, err error) {
+	if err = m.loadResourceTypes(ctx); err != nil {
+		return
+	}
+	refs := pkg.Resources.Refs()
+	if installed != nil {
+		refs = append(refs, installed.Resources...)
+	}
+	var kinds []string
+	kindSet := map[string]bool{}
+	for _, ref := range refs {
+		// each group-kind is queried once since aliases (e.g. legacy API groups) serve the same objects
+		if gk := m.groupKind(ref); !kindSet[gk] {
+			kindSet[gk] = true
+			kinds = append(kinds, ref.QualifiedKind())
+		}
+	}
+	for evt := range m.client.Get(ctx, kinds, m.namespace, labels) {
+		if evt.Error != nil {
+			if err == nil {
+				err = evt.Error
+			}
+			continue
+		}
+		if !m.containsResource(pkg.Resources, evt.Resource) && m.selection.Match(evt.Resource) && isPrunable(evt.Resource) {
+			pruned = append(pruned, evt.Resource)
+		}
+	}
+	return
+}
+
+// isPrunable returns true if the live resource has been applied by k8spkg or kubectl
+// and is not owned by another object.
+// Objects created by controllers may carry the package label as well since
+// it is also added to pod and job templates.
+func isPrunable(res *resource.K8sResource) bool {
+	obj := res.Raw()
+	if owners, _, _ := unstructured.NestedSlice(obj, "metadata", "ownerReferences"); len(owners) > 0 {
+		return false
+	}
+	if lastApplied, _, _ := unstructured.NestedString(obj, "metadata", "annotations", corev1.LastAppliedConfigAnnotation); lastApplied != "" {
+		return true
+	}
+	managedFields, _, _ := unstructured.NestedSlice(obj, "metadata", "managedFields")
+	for _, f := range managedFields {
+		if f, ok := f.(map[string]interface{}); ok && f["manager"] == client.FieldManager && f["operation"] == string(metav1.ManagedFieldsOperationApply) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsResource returns true if the list contains a resource with the same group-kind and name.
+// The namespace is compared only if specified on both sides since the input may not specify it.
+func (m *PackageManager) containsResource(l resource.K8sResourceList, ref resource.K8sResourceRef) bool {
+	gk := m.groupKind(ref)
+	for _, res := range l {
+		if m.groupKind(res) == gk && res.Name() == ref.Name() &&
+			(res.Namespace() == "" || ref.Namespace() == "" || res.Namespace() == ref.Namespace()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *PackageManager) logDryRun(ref resource.K8sResourceRef, action string, dryRun client.DryRun) {
+	m.log.Infof("%s %s (%s dry run)", displayName(ref), action, dryRun)
+}
+
+// displayName returns the resource's qualified kind and name and its namespace if set
+func displayName(ref resource.K8sResourceRef) (name string) {
+	name = fmt.Sprintf("%s/%s", ref.QualifiedKind(), ref.Name())
+	if ref.Namespace() != "" {
+		name += " -n " + ref.Namespace()
+	}
+	return
+}
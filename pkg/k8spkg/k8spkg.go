@@ -17,8 +17,8 @@ type K8sPackage struct {
 	Resources resource.K8sResourceList
 }
 
-func PkgFromManifest(reader io.Reader, namespace, name string) (pkg *K8sPackage, err error) {
-	obj, err := transformedObjects(reader, namespace, name)
+func PkgFromManifest(reader io.Reader, namespace, name string, onDuplicate resource.DuplicateStrategy, patches []*resource.Patch) (pkg *K8sPackage, err error) {
+	obj, err := transformedObjects(reader, namespace, name, onDuplicate, patches)
 	if err != nil {
 		return
 	}
@@ -38,12 +38,21 @@ func PkgFromManifest(reader io.Reader, namespace, name string) (pkg *K8sPackage,
 	return &K8sPackage{name, obj}, nil
 }
 
-// transformedObjects read API objects from reader and modify their name and namespace if provided
-func transformedObjects(reader io.Reader, namespace, name string) (obj resource.K8sResourceList, err error) {
-	if obj, err = resource.FromReader(reader); err != nil {
+// transformedObjects read API objects from reader and modify their name and namespace if provided.
+// Resources that occur multiple times are handled according to the duplicate strategy.
+// The patches are applied before the package labels and namespace.
+func transformedObjects(reader io.Reader, namespace, name string, onDuplicate resource.DuplicateStrategy, patches []*resource.Patch) (obj resource.K8sResourceList, err error) {
+	original, err := resource.FromReader(reader)
+	if err != nil {
+		return
+	}
+	if original, err = original.Deduplicate(onDuplicate); err != nil {
 		return
 	}
-	readCloser := obj.YamlReader()
+	if original, err = original.Patch(patches); err != nil {
+		return
+	}
+	readCloser := original.YamlReader()
 	reader = readCloser
 	defer readCloser.Close()
 	transformed := manifest2pkgobjects(reader, name, namespace)
@@ -55,7 +64,31 @@ func transformedObjects(reader io.Reader, namespace, name string) (obj resource.
 	if len(obj) == 0 {
 		return nil, errors.New("no objects found in the provided manifest")
 	}
-	return
+	return preserveFormatting(original, obj), nil
+}
+
+// preserveFormatting makes the transformed resources keep the key order and
+// comments of the resources they have been derived from and restores their order.
+// Resources are matched by kind and name since the transformation may change the namespace.
+func preserveFormatting(original, transformed resource.K8sResourceList) resource.K8sResourceList {
+	indices := map[string][]int{}
+	for i, o := range original {
+		key := o.QualifiedKind() + "/" + o.Name()
+		indices[key] = append(indices[key], i)
+	}
+	order := map[*resource.K8sResource]int{}
+	for i, o := range transformed {
+		order[o] = len(original) + i
+		if matched := indices[o.QualifiedKind()+"/"+o.Name()]; len(matched) == 1 {
+			o.PreserveFormatting(original[matched[0]])
+			order[o] = matched[0]
+		}
+	}
+	sorted := append(resource.K8sResourceList(nil), transformed...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return order[sorted[i]] < order[sorted[j]]
+	})
+	return sorted
 }
 
 func manifest2pkgobjects(reader io.Reader, name, namespace string) io.ReadCloser {
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"testing"
 
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
 	"github.com/stretchr/testify/require"
 )
 
@@ -22,20 +23,20 @@ metadata:
   name: ca-issuer
   namespace: kube-system
 `
-	pkg, err := PkgFromManifest(bytes.NewReader([]byte(plainManifest)), "myns", "somepkg")
+	pkg, err := PkgFromManifest(bytes.NewReader([]byte(plainManifest)), "myns", "somepkg", resource.DuplicateError, nil)
 	require.NoError(t, err)
 	for _, o := range pkg.Resources {
 		require.Equal(t, "somepkg", o.Labels()[PKG_NAME_LABEL], "pkg name")
 		require.Equal(t, "myns", o.Namespace(), "pkg namespace")
 		require.Equal(t, "myns", o.Labels()[PKG_NS_LABEL], "pkg namespaces")
 	}
-	pkg, err = PkgFromManifest(bytes.NewReader([]byte(plainManifest)), "", "somepkg")
+	pkg, err = PkgFromManifest(bytes.NewReader([]byte(plainManifest)), "", "somepkg", resource.DuplicateError, nil)
 	require.NoError(t, err)
 	require.Equal(t, 2, len(pkg.Resources), "len(pkg.Objects)")
 	for _, o := range pkg.Resources {
 		require.True(t, o.Namespace() == "cert-manager" || o.Namespace() == "kube-system", "unexpected namespace: "+o.Namespace())
 	}
-	pkg, err = PkgFromManifest(bytes.NewReader([]byte(plainManifest)), "", "")
+	pkg, err = PkgFromManifest(bytes.NewReader([]byte(plainManifest)), "", "", resource.DuplicateError, nil)
 	require.Error(t, err, "unlabeled package objects should yield error")
 
 	// test k8spkg manifest
@@ -56,7 +57,7 @@ metadata:
     labels:
         ` + PKG_NAME_LABEL + `: somepkg
 `
-	pkg, err = PkgFromManifest(bytes.NewReader([]byte(pkgManifest)), "", "")
+	pkg, err = PkgFromManifest(bytes.NewReader([]byte(pkgManifest)), "", "", resource.DuplicateError, nil)
 	require.NoError(t, err)
 	require.Equal(t, 2, len(pkg.Resources), "len(pkg.Objects)")
 	for _, o := range pkg.Resources {
@@ -64,3 +65,60 @@ metadata:
 		require.True(t, o.Namespace() == "cert-manager" || o.Namespace() == "kube-system", "unexpected namespace: "+o.Namespace())
 	}
 }
+
+func TestPkgFromManifestPreservesFormatting(t *testing.T) {
+	manifest := `---
+# the service
+kind: Service
+apiVersion: v1
+metadata:
+  name: mysvc
+spec:
+  ports:
+    - port: 80 # http
+---
+kind: ConfigMap
+apiVersion: v1
+metadata:
+  name: myconfig
+data:
+  key: value
+`
+	pkg, err := PkgFromManifest(bytes.NewReader([]byte(manifest)), "myns", "somepkg", resource.DuplicateError, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"service/mysvc", "configmap/myconfig"}, pkg.Resources.Refs().Names(), "resource order")
+	var buf bytes.Buffer
+	require.NoError(t, pkg.Resources.WriteYaml(&buf))
+	expected := `---
+# the service
+kind: Service
+apiVersion: v1
+metadata:
+  name: mysvc
+  labels:
+    app.kubernetes.io/managed-by: k8spkg
+    app.kubernetes.io/part-of: somepkg
+    k8spkg.mgoltzsche.github.com/namespace: myns
+  namespace: myns
+spec:
+  ports:
+    - port: 80 # http
+  selector:
+    app.kubernetes.io/managed-by: k8spkg
+    app.kubernetes.io/part-of: somepkg
+    k8spkg.mgoltzsche.github.com/namespace: myns
+---
+kind: ConfigMap
+apiVersion: v1
+metadata:
+  name: myconfig
+  labels:
+    app.kubernetes.io/managed-by: k8spkg
+    app.kubernetes.io/part-of: somepkg
+    k8spkg.mgoltzsche.github.com/namespace: myns
+  namespace: myns
+data:
+  key: value
+`
+	require.Equal(t, expected, buf.String())
+}
@@ -1,12 +1,13 @@
 package k8spkg
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"regexp"
-	"sort"
 	"strings"
 	"sync"
+	"text/tabwriter"
 
 	"github.com/mgoltzsche/k8spkg/pkg/client"
 	"github.com/mgoltzsche/k8spkg/pkg/resource"
@@ -34,11 +35,63 @@ type PackageManager struct {
 	namespace     string
 	client        client.K8sClient
 	installedApps *AppRepo
-	resourceTypes []*client.APIResourceType
+	resourceTypes map[string]*client.APIResourceType
+	groupKinds    map[string]string
+	batch         BatchOptions
+	createNs      bool
+	schemas       SchemaSource
+	skipUnchanged bool
+	showSecrets   bool
+	selection     *resource.Selection
+	log           logrus.FieldLogger
 }
 
 func NewPackageManager(client client.K8sClient, namespace string) *PackageManager {
-	return &PackageManager{namespace, client, NewAppRepo(client), nil}
+	return &PackageManager{namespace, client, NewAppRepo(client), nil, nil, DefaultBatchOptions, false, nil, true, false, nil, logrus.StandardLogger()}
+}
+
+// SetLogger sets the logger the progress is reported to.
+// This allows to tell the output of multiple managers apart.
+func (m *PackageManager) SetLogger(log logrus.FieldLogger) {
+	m.log = log
+}
+
+// SetBatchOptions specifies how Apply splits up the package
+func (m *PackageManager) SetBatchOptions(opts BatchOptions) {
+	m.batch = opts
+}
+
+// SetCreateNamespaces makes Apply create the missing namespaces the package refers to.
+// The created namespaces are owned by the package and deleted with it.
+func (m *PackageManager) SetCreateNamespaces(create bool) {
+	m.createNs = create
+}
+
+// SetSkipUnchanged specifies whether Apply skips the resources that did not
+// change since the last successfully applied revision (enabled by default).
+// Disabling it applies all resources again, e.g. to revert manual changes.
+func (m *PackageManager) SetSkipUnchanged(skip bool) {
+	m.skipUnchanged = skip
+}
+
+// SetShowSecrets makes Diff print the decoded values of Secrets instead of their hashes
+func (m *PackageManager) SetShowSecrets(show bool) {
+	m.showSecrets = show
+}
+
+// SetSelection restricts Apply, Status and Delete to the selected resources of a package.
+// A partial apply neither prunes nor forgets the resources that are not selected.
+func (m *PackageManager) SetSelection(selection *resource.Selection) {
+	m.selection = selection
+}
+
+// installedApp returns the package's Application record or nil if it is not installed
+func (m *PackageManager) installedApp(ctx context.Context, name string) (app *App, err error) {
+	app, err = m.installedApps.Get(ctx, m.namespace, name)
+	if client.IsNotFound(err) {
+		return nil, nil
+	}
+	return
 }
 
 func (m *PackageManager) List(ctx context.Context, namespace string) <-chan AppEvent {
@@ -50,7 +103,7 @@ func (m *PackageManager) labelSelector(pkgName string) []string {
 }
 
 func (m *PackageManager) Status(ctx context.Context, pkg *K8sPackage) (err error) {
-	return m.await(ctx, pkg.Name, pkg.Resources, status.RolloutConditions)
+	return m.await(ctx, pkg.Name, pkg.Resources.Filter(m.selection.Match), status.RolloutConditions)
 }
 
 func (m *PackageManager) await(ctx context.Context, appName string, resources resource.K8sResourceList, conditions map[string]status.Condition) (err error) {
@@ -62,6 +115,7 @@ func (m *PackageManager) await(ctx context.Context, appName string, resources re
 		}
 	}
 	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	evts := Events(watchCtx, refs, m.client)
 	resEvts := m.watch(watchCtx, appName, conditional)
 	statusEvts := status.Emitter(resEvts, conditions)
@@ -85,9 +139,9 @@ func (m *PackageManager) await(ctx context.Context, appName string, resources re
 			if evt.Err == nil {
 				msg := fmt.Sprintf("%s/%s: %s", strings.ToLower(evt.Resource.Kind()), evt.Resource.Name(), evt.Status.Description)
 				if evt.Status.Status {
-					logrus.Info(msg)
+					m.log.Info(msg)
 				} else {
-					logrus.Warn(msg)
+					m.log.Warn(msg)
 				}
 			} else if err == nil {
 				if errors.Cause(evt.Err) != context.Canceled {
@@ -111,13 +165,13 @@ func (m *PackageManager) await(ctx context.Context, appName string, resources re
 					msg += ": " + evt.Message
 				}
 				if evt.Reason == "BackOff" {
-					logrus.Error(msg)
+					m.log.Error(msg)
 					container := containerNameFromFieldPath(evt.InvolvedFieldPath)
 					if evt.InvolvedObject.Kind() == "Pod" && container != "" {
 						m.logPodError(watchCtx, evt.InvolvedObject, container)
 					}
 				} else {
-					logrus.Warn(msg)
+					m.log.Warn(msg)
 				}
 			}
 		case _, ok := <-ready:
@@ -132,7 +186,7 @@ func (m *PackageManager) await(ctx context.Context, appName string, resources re
 	summary := <-result
 	for _, o := range summary.Resources {
 		if !o.Status.Status {
-			logrus.Errorf("%s/%s: %s", strings.ToLower(o.Resource.Kind()), o.Resource.Name(), o.Status.Description)
+			m.log.Errorf("%s/%s: %s", strings.ToLower(o.Resource.Kind()), o.Resource.Name(), o.Status.Description)
 		}
 	}
 	if err == nil && !summary.Ready {
@@ -158,7 +212,7 @@ func (m *PackageManager) logPodError(ctx context.Context, pod resource.K8sResour
 	writer := newChanWriter()
 	go func() {
 		if e := m.client.ContainerLogs(ctx, pod.Namespace(), pod.Name(), container, false, true, writer); e != nil {
-			logrus.Debug(e)
+			m.log.Debug(e)
 		}
 		writer.Close()
 	}()
@@ -166,9 +220,9 @@ func (m *PackageManager) logPodError(ctx context.Context, pod resource.K8sResour
 	for logLine := range writer.Chan() {
 		if !ctxLogged {
 			ctxLogged = true
-			logrus.Errorf("pod/%s: container %s logs:", pod.Name(), container)
+			m.log.Errorf("pod/%s: container %s logs:", pod.Name(), container)
 		}
-		logrus.Error(" " + logLine)
+		m.log.Error(" " + logLine)
 	}
 }
 
@@ -176,19 +230,24 @@ func (m *PackageManager) watch(ctx context.Context, appName string, resources re
 	pkgSelector := m.labelSelector(appName)
 	evts := make(chan resource.ResourceEvent)
 	wg := sync.WaitGroup{}
-	for _, byNs := range resources.GroupByNamespace() {
-		if byNs.Key == "" {
-			byNs.Key = m.namespace
+	watched := map[string]bool{}
+	for _, ref := range resources {
+		ns := ref.Namespace()
+		if ns == "" {
+			ns = m.namespace
 		}
-		for _, byKind := range byNs.Resources.GroupByKind() {
-			wg.Add(1)
-			go func(kind, ns string) {
-				for evt := range m.client.Watch(ctx, kind, ns, pkgSelector, false) {
-					evts <- evt
-				}
-				wg.Done()
-			}(byKind.Key, byNs.Key)
+		kind, ns := m.watchKind(ref, ns)
+		if watched[ns+"/"+kind] {
+			continue
 		}
+		watched[ns+"/"+kind] = true
+		wg.Add(1)
+		go func(kind, ns string) {
+			for evt := range m.client.Watch(ctx, kind, ns, pkgSelector, false) {
+				evts <- evt
+			}
+			wg.Done()
+		}(kind, ns)
 	}
 	go func() {
 		wg.Wait()
@@ -197,68 +256,292 @@ func (m *PackageManager) watch(ctx context.Context, appName string, resources re
 	return evts
 }
 
-func (m *PackageManager) Apply(ctx context.Context, pkg *K8sPackage, prune bool) (err error) {
-	logrus.Infof("Applying package %s...", pkg.Name)
+// Apply installs or updates the package and awaits its rollout.
+// The resources are applied in stages derived from their dependencies -
+// each stage is applied after the previous stage's rollout succeeded.
+// The label selector of the provided options is set to the package label.
+// When a dry run is requested the resources that would be changed are logged
+// but neither the Application record is written nor the rollout awaited.
+func (m *PackageManager) Apply(ctx context.Context, pkg *K8sPackage, opts client.ApplyOptions) (err error) {
+	opts.Labels = m.labelSelector(pkg.Name)
+	if m.schemas != nil {
+		if err = m.Validate(ctx, pkg); err != nil {
+			return
+		}
+	}
+	if opts.DryRun != client.DryRunNone {
+		return errors.Wrapf(m.dryRunApply(ctx, pkg, opts), "apply package %s", pkg.Name)
+	}
+	m.log.Infof("Applying package %s...", pkg.Name)
+	refs, err := m.resolveRefs(ctx, pkg.Resources.Refs())
+	if err != nil {
+		return errors.Wrapf(err, "apply package %s", pkg.Name)
+	}
+	kubeContext, err := m.client.CurrentContext(ctx)
+	if err != nil {
+		m.log.Debugf("cannot resolve kubeconfig context: %s", err)
+	}
+	installed, err := m.installedApp(ctx, pkg.Name)
+	if err != nil {
+		return errors.Wrapf(err, "apply package %s", pkg.Name)
+	}
+	stages, err := dependencyStages(pkg.Resources, m.namespace)
+	if err != nil {
+		return errors.Wrapf(err, "apply package %s", pkg.Name)
+	}
+	rev, err := packageRevision(pkg, refs, installed, m.selection)
+	if err != nil {
+		return errors.Wrapf(err, "apply package %s", pkg.Name)
+	}
 	app := App{
 		Name:      pkg.Name,
 		Namespace: m.namespace,
-		Resources: pkg.Resources.Refs(),
+		Context:   kubeContext,
+		Resources: refs,
+		Digests:   rev.unchangedDigests(),
+	}
+	if !rev.hasChanges() {
+		app.Digest = rev.digest
+	}
+	var pruned resource.K8sResourceRefList
+	if installed != nil {
+		// keep the ownership of namespaces created by a previous apply
+		app.Namespaces = installed.Namespaces
+	}
+	if len(rev.excluded) > 0 {
+		m.log.Infof("Skipping %d resources that are not selected", len(rev.excluded))
+		stages = rev.selectedStages(stages)
+	}
+	if m.skipUnchanged {
+		unchanged := 0
+		for id := range rev.unchanged {
+			if !rev.excluded[id] {
+				unchanged++
+			}
+		}
+		if unchanged > 0 {
+			m.log.Infof("Skipping %d unchanged resources", unchanged)
+		}
+		stages = rev.changedStages(stages)
+	}
+	if opts.Prune {
+		// The candidates are looked up before the Application is updated
+		// since they are derived from the kinds it previously contained.
+		if pruned, err = m.pruneCandidates(ctx, pkg, installed, opts.Labels); err != nil {
+			return errors.Wrapf(err, "apply package %s", pkg.Name)
+		}
+		opts.Prune = false
+	}
+	if !m.selection.IsEmpty() {
+		app.Resources = m.keepInstalledResources(refs, installed, pruned)
+	}
+	if m.createNs {
+		created, err := m.createNamespaces(ctx, m.targetNamespaces(pkg))
+		app.Namespaces = mergeNamespaces(app.Namespaces, created)
+		if err != nil {
+			if len(created) > 0 {
+				// record the ownership of the namespaces that have been created already
+				if e := m.installedApps.Put(ctx, &app); e != nil {
+					m.log.Warnf("cannot record created namespaces: %s", e)
+				}
+			}
+			return errors.Wrapf(err, "apply package %s", pkg.Name)
+		}
 	}
 	if err = m.installedApps.Put(ctx, &app); err != nil {
 		return
 	}
-	pkgLabel := []string{PKG_NAME_LABEL + "=" + pkg.Name}
-	applied, err := m.client.Apply(ctx, app.Namespace, pkg.Resources, prune, pkgLabel)
-	// TODO: detect which resources changed or have been created
-	if err == nil {
-		err = m.await(ctx, pkg.Name, applied, status.RolloutConditions)
+	for i, stage := range stages {
+		// each stage is applied after the previous one became ready
+		if len(stages) > 1 {
+			m.log.Infof("Applying stage %d/%d: %d resources...", i+1, len(stages), len(stage))
+		}
+		results, err := m.applyBatches(ctx, app.Namespace, stage, opts)
+		m.logApplyResults(results)
+		if err != nil {
+			if e, ok := errors.Cause(err).(*client.ApplyError); i > 0 || ok && e.Partial() {
+				return errors.Wrapf(err, "apply package %s partially", pkg.Name)
+			}
+			return errors.Wrapf(err, "apply package %s", pkg.Name)
+		}
+		if i == len(stages)-1 && len(pruned) > 0 {
+			m.log.Infof("Pruning %d resources...", len(pruned))
+			if err = m.deleteResources(ctx, pruned); err != nil {
+				return errors.Wrapf(err, "apply package %s: prune", pkg.Name)
+			}
+		}
+		if err = m.await(ctx, pkg.Name, results.Resources(), status.RolloutConditions); err != nil {
+			return errors.Wrapf(err, "apply package %s", pkg.Name)
+		}
+	}
+	if len(stages) == 0 && len(pruned) > 0 {
+		m.log.Infof("Pruning %d resources...", len(pruned))
+		if err = m.deleteResources(ctx, pruned); err != nil {
+			return errors.Wrapf(err, "apply package %s: prune", pkg.Name)
+		}
 	}
-	if err == nil {
-		logrus.Infof("Applied %s successfully", pkg.Name)
+	// record the revision after it has been applied successfully
+	if digest, digests := rev.appliedDigests(); app.Digest != digest || len(app.Digests) != len(digests) {
+		app.Digest, app.Digests = digest, digests
+		if err = m.installedApps.Put(ctx, &app); err != nil {
+			return errors.Wrapf(err, "apply package %s", pkg.Name)
+		}
 	}
-	return errors.Wrapf(err, "apply package %s", pkg.Name)
+	m.log.Infof("Applied %s successfully", pkg.Name)
+	return nil
 }
 
-func (m *PackageManager) Delete(ctx context.Context, name string) (err error) {
+// keepInstalledResources returns the refs extended with the installed resources
+// that are not part of the package unless they are pruned.
+// A partial apply must not forget the resources it didn't touch.
+func (m *PackageManager) keepInstalledResources(refs resource.K8sResourceRefList, installed *App, pruned resource.K8sResourceRefList) (kept resource.K8sResourceRefList) {
+	kept = append(kept, refs...)
+	if installed == nil {
+		return
+	}
+	ids := map[string]bool{}
+	for _, ref := range refs {
+		ids[m.id(ref)] = true
+	}
+	for _, ref := range pruned {
+		ids[m.id(ref)] = true
+	}
+	for _, ref := range installed.Resources {
+		if !ids[m.id(ref)] {
+			kept = append(kept, ref)
+		}
+	}
+	return
+}
+
+// logApplyResults logs a table that shows what happened to each resource
+func (m *PackageManager) logApplyResults(results client.ApplyResults) {
+	if len(results) == 0 {
+		return
+	}
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RESOURCE\tNAMESPACE\tACTION\tREASON")
+	for _, r := range results {
+		reason := ""
+		if r.Error != nil {
+			if reason = string(client.Reason(r.Error)); reason == "" {
+				reason = "Unknown"
+			}
+		}
+		fmt.Fprintf(w, "%s/%s\t%s\t%s\t%s\n", r.Resource.QualifiedKind(), r.Resource.Name(), r.Resource.Namespace(), r.Action, reason)
+	}
+	w.Flush()
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		m.log.Info(line)
+	}
+}
+
+// Delete deletes the package's resources and its Application record.
+// The namespaces the package created are deleted last, after the resources are gone.
+// When a dry run is requested the resources that would be deleted are logged only.
+// If a selection is set only the selected resources are deleted.
+func (m *PackageManager) Delete(ctx context.Context, name string, opts client.DeleteOptions) (err error) {
 	app, err := m.installedApps.Get(ctx, m.namespace, name)
-	if err == nil {
-		logrus.Infof("Deleting %s...", name)
-		resources := app.Resources
-		sort.Sort(reverseResources(resources))
+	if err == nil && !m.selection.IsEmpty() {
+		err = m.deleteSelected(ctx, app, opts)
+	} else if err == nil {
+		resources := sortForUninstall(app.Resources)
+		namespaces := namespaceRefs(app.Namespaces)
+		if opts.DryRun != client.DryRunNone {
+			return errors.Wrapf(m.dryRunDelete(ctx, append(resources, namespaces...), opts), "delete package %s", name)
+		}
+		m.log.Infof("Deleting %s...", name)
 		if err = m.deleteResources(ctx, resources); err == nil {
 			if err = m.installedApps.Delete(ctx, app); err == nil {
-				logrus.Infof("Deleted %s", name)
+				if len(namespaces) > 0 {
+					m.log.Infof("Deleting namespaces %s...", strings.Join(app.Namespaces, ", "))
+					err = errors.Wrap(m.deleteResources(ctx, namespaces), "delete namespaces")
+				}
+				if err == nil {
+					m.log.Infof("Deleted %s", name)
+				}
 			}
 		}
 	}
 	return errors.Wrapf(err, "delete package %s", name)
 }
 
-func (m *PackageManager) DeleteResources(ctx context.Context, obj resource.K8sResourceRefList) (err error) {
-	return m.deleteResources(ctx, obj)
+// deleteSelected deletes the package's selected resources.
+// The Application record and the namespaces are kept - the record lists the remaining resources.
+func (m *PackageManager) deleteSelected(ctx context.Context, app *App, opts client.DeleteOptions) (err error) {
+	selected, remaining, err := m.selectInstalled(ctx, app.Resources)
+	if err != nil {
+		return
+	}
+	selected = sortForUninstall(selected)
+	if opts.DryRun != client.DryRunNone {
+		return m.dryRunDelete(ctx, selected, opts)
+	}
+	if len(selected) == 0 {
+		m.log.Infof("No resources of %s selected", app.Name)
+		return
+	}
+	m.log.Infof("Deleting %d resources of %s...", len(selected), app.Name)
+	if err = m.deleteResources(ctx, selected); err != nil {
+		return
+	}
+	app.Resources = remaining
+	for _, ref := range selected {
+		delete(app.Digests, ref.ID())
+	}
+	// the package is not installed completely anymore
+	app.Digest = ""
+	if err = m.installedApps.Put(ctx, app); err == nil {
+		m.log.Infof("Deleted %d resources of %s", len(selected), app.Name)
+	}
+	return
 }
 
-func (m *PackageManager) deleteResources(ctx context.Context, obj resource.K8sResourceRefList) (err error) {
-	if err = m.client.Delete(ctx, m.namespace, obj); err == nil {
-		err = m.client.AwaitDeletion(ctx, m.namespace, obj)
+// selectInstalled splits the installed resources into the selected and the remaining ones.
+// Label criteria are matched against the live objects since the Application
+// record doesn't store labels - resources that don't exist anymore never match them.
+func (m *PackageManager) selectInstalled(ctx context.Context, refs resource.K8sResourceRefList) (selected, remaining resource.K8sResourceRefList, err error) {
+	for _, ref := range refs {
+		match := ref
+		if m.selection.HasLabels() {
+			ns := ref.Namespace()
+			if ns == "" {
+				ns = m.namespace
+			}
+			live, e := m.client.GetResource(ctx, ref.QualifiedKind(), ns, ref.Name())
+			if e != nil && !client.IsNotFound(e) {
+				return nil, nil, errors.Wrapf(e, "get %s", ref.ID())
+			}
+			if e == nil {
+				match = live
+			}
+		}
+		if m.selection.Match(match) {
+			selected = append(selected, ref)
+		} else {
+			remaining = append(remaining, ref)
+		}
 	}
 	return
 }
 
-type reverseResources resource.K8sResourceRefList
-
-// Less reports whether the element with
-// index i should sort before the element with index j.
-func (r reverseResources) Less(i, j int) bool {
-	return i < j
-}
-func (r reverseResources) Len() int {
-	return len(r)
+// DeleteResources deletes the provided resources in reverse install order.
+// If a selection is set only the selected resources are deleted.
+func (m *PackageManager) DeleteResources(ctx context.Context, obj resource.K8sResourceRefList, opts client.DeleteOptions) (err error) {
+	obj = sortForUninstall(obj.Filter(m.selection.Match))
+	if opts.DryRun != client.DryRunNone {
+		return m.dryRunDelete(ctx, obj, opts)
+	}
+	return m.deleteResources(ctx, obj)
 }
 
-// Swap swaps the elements with indexes i and j.
-func (r reverseResources) Swap(i, j int) {
-	tmp := r[i]
-	r[i] = r[j]
-	r[j] = tmp
+func (m *PackageManager) deleteResources(ctx context.Context, obj resource.K8sResourceRefList) (err error) {
+	if obj, err = m.resolveRefs(ctx, obj); err != nil {
+		return
+	}
+	if err = m.client.Delete(ctx, m.namespace, obj, client.DeleteOptions{}); err == nil {
+		err = m.client.AwaitDeletion(ctx, m.namespace, obj)
+	}
+	return
 }
@@ -1,15 +1,22 @@
 package k8spkg
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"os"
 	"strings"
 	"testing"
 
+	"github.com/mgoltzsche/k8spkg/pkg/client"
+	"github.com/mgoltzsche/k8spkg/pkg/client/fakecluster"
 	"github.com/mgoltzsche/k8spkg/pkg/client/mock"
 	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 func assertPkgManagerCall(t *testing.T, call func(*PackageManager, *mock.ClientMock) error) {
@@ -36,13 +43,16 @@ func TestPackageManagerApply(t *testing.T) {
 	labels := fmt.Sprintf("[%s=%s]", PKG_NAME_LABEL, pkg.Name)
 	for _, ns := range []string{"", "myns"} {
 		expectedCalls := []string{
+			"resourcetypes",
+			"currentcontext",
+			fmt.Sprintf("getresource %s/ %s.%s %s", ns, strings.ToLower(CrdKind), CrdAPIGroup, pkg.Name),
 			fmt.Sprintf("apply %s/ false []", ns),
-			fmt.Sprintf("apply %s/ %v %s", ns, false, labels), // TODO: test prune
-
 		}
+		stages := applyStages(obj, DefaultBatchOptions.Size)
 		expectedCallMap := map[string]int{
-			fmt.Sprintf("watch default/Event [] true"): 1,
-			fmt.Sprintf("watch otherns/Event [] true"): 1,
+			fmt.Sprintf("apply %s/ %v %s", ns, false, labels): len(stages), // one batch per kind, TODO: test prune
+			fmt.Sprintf("watch default/Event [] true"):        1,
+			fmt.Sprintf("watch otherns/Event [] true"):        1,
 		}
 		for _, byNs := range obj.Refs().GroupByNamespace() {
 			for _, byKind := range byNs.Resources.GroupByKind() {
@@ -58,16 +68,16 @@ func TestPackageManagerApply(t *testing.T) {
 			testee = NewPackageManager(c, ns)
 			evts := make([]resource.ResourceEvent, len(obj))
 			for i, res := range obj {
-				evts[i] = resource.ResourceEvent{res, c.MockErr}
+				evts[i] = resource.ResourceEvent{Resource: res, Error: c.MockErr}
 			}
 			evts[len(evts)-1].Resource.Conditions()[1].Status = false
 			c.MockWatchEvents = evts
-			err = testee.Apply(context.Background(), pkg, false)
+			err = testee.Apply(context.Background(), pkg, client.ApplyOptions{})
 			require.Error(t, err, "unavailable (last) deployment should cause error")
 			if c.MockErr == nil {
-				require.Equal(t, expectedCalls, c.Calls[:2], "client calls")
+				require.Equal(t, expectedCalls, c.Calls[:4], "client calls")
 				callMap := map[string]int{}
-				for _, call := range c.Calls[2:] {
+				for _, call := range c.Calls[4:] {
 					callMap[call]++
 				}
 				require.Equal(t, expectedCallMap, callMap, "rollout observation client calls")
@@ -75,15 +85,326 @@ func TestPackageManagerApply(t *testing.T) {
 			evts[len(evts)-1].Resource.Conditions()[1].Status = true
 			c.Calls = c.Calls[:0]
 			c.Applied = nil
-			if err = testee.Apply(context.Background(), pkg, false); err == nil {
-				require.Equal(t, obj, c.Applied, "applied")
-				require.Equal(t, expectedCalls[:len(expectedCalls)-1], c.Calls[:len(expectedCalls)-1], "client calls")
+			if err = testee.Apply(context.Background(), pkg, client.ApplyOptions{}); err == nil {
+				// the revision is recorded after the last batch has been applied
+				require.Equal(t, 1, len(c.Applied), "applied app record")
+				digest, err := pkg.Resources.Digest()
+				require.NoError(t, err)
+				app, err := appFromResource(c.Applied[0])
+				require.NoError(t, err)
+				require.Equal(t, digest, app.Digest, "recorded package digest")
+				for _, ref := range app.Resources {
+					require.NotEmpty(t, app.Digests[ref.ID()], "recorded digest of %s", ref.ID())
+				}
+				// resource types are loaded only once
+				require.Equal(t, expectedCalls[1:], c.Calls[:len(expectedCalls)-1], "client calls")
 			}
 			return
 		})
 	}
 }
 
+func TestPackageManagerApplyServerSide(t *testing.T) {
+	obj := mock.MockResourceList("../client/mock/get-list.json")
+	pkg := &K8sPackage{"somepkg", obj}
+	c := mock.NewClientMock()
+	testee := NewPackageManager(c, "myns")
+	err := testee.Apply(context.Background(), pkg, client.ApplyOptions{ServerSide: true, ForceConflicts: true, Prune: true})
+	require.NoError(t, err)
+	expectedCall := fmt.Sprintf("apply myns/ false [%s=%s] serverside force=true", PKG_NAME_LABEL, pkg.Name)
+	require.Contains(t, c.Calls, expectedCall, "client calls")
+}
+
+func TestPackageManagerApplySkipUnchanged(t *testing.T) {
+	ctx := context.Background()
+	obj := mock.MockResourceList("../client/mock/get-list.json")
+	pkg := &K8sPackage{"somepkg", obj}
+	c := mock.NewClientMock()
+	testee := NewPackageManager(c, "myns")
+	require.NoError(t, testee.Apply(ctx, pkg, client.ApplyOptions{}))
+	labels := fmt.Sprintf("[%s=%s]", PKG_NAME_LABEL, pkg.Name)
+	countCalls := func(prefix string) (n int) {
+		for _, call := range c.Calls {
+			if strings.HasPrefix(call, prefix) {
+				n++
+			}
+		}
+		return
+	}
+
+	// unchanged
+	c.MockResource = c.Applied[0] // recorded app
+	c.Calls = nil
+	require.NoError(t, testee.Apply(ctx, pkg, client.ApplyOptions{}))
+	require.Equal(t, 0, countCalls(fmt.Sprintf("apply myns/ false %s", labels)), "package apply calls")
+	require.Equal(t, 0, countCalls("watch"), "watch calls")
+
+	// single resource changed
+	c.Calls = nil
+	obj[0].Raw()["data"] = map[string]interface{}{"changed": "value"}
+	require.NoError(t, testee.Apply(ctx, pkg, client.ApplyOptions{}))
+	require.Equal(t, 1, countCalls(fmt.Sprintf("apply myns/ false %s", labels)), "package apply calls")
+
+	// skipping disabled
+	c.Calls = nil
+	testee.SetSkipUnchanged(false)
+	require.NoError(t, testee.Apply(ctx, pkg, client.ApplyOptions{}))
+	require.Equal(t, len(applyStages(obj, DefaultBatchOptions.Size)), countCalls(fmt.Sprintf("apply myns/ false %s", labels)), "package apply calls")
+}
+
+func TestPackageManagerSelection(t *testing.T) {
+	ctx := context.Background()
+	obj := mock.MockResourceList("../client/mock/get-list.json")
+	pkg := &K8sPackage{"somepkg", obj}
+	c := mock.NewClientMock()
+	testee := NewPackageManager(c, "myns")
+	require.NoError(t, testee.Apply(ctx, pkg, client.ApplyOptions{}))
+	installed, err := appFromResource(c.Applied[0])
+	require.NoError(t, err)
+	c.MockResource = c.Applied[0]
+	sel, err := resource.ParseSelection([]string{"kind=ConfigMap"}, nil)
+	require.NoError(t, err)
+	testee.SetSelection(sel)
+	labels := fmt.Sprintf("[%s=%s]", PKG_NAME_LABEL, pkg.Name)
+	countCalls := func(prefix string) (n int) {
+		for _, call := range c.Calls {
+			if strings.HasPrefix(call, prefix) {
+				n++
+			}
+		}
+		return
+	}
+
+	// partial apply
+	for _, o := range obj {
+		o.Raw()["data"] = map[string]interface{}{"changed": "value"}
+	}
+	c.Calls = nil
+	require.NoError(t, testee.Apply(ctx, pkg, client.ApplyOptions{Prune: true}))
+	require.Equal(t, 1, countCalls(fmt.Sprintf("apply myns/ false %s", labels)), "package apply calls")
+	app, err := appFromResource(c.Applied[0])
+	require.NoError(t, err)
+	require.Equal(t, installed.Resources.Names(), app.Resources.Names(), "recorded resources")
+	require.Equal(t, "", app.Digest, "recorded package digest")
+	for _, ref := range app.Resources {
+		if ref.Kind() == "ConfigMap" {
+			require.NotEqual(t, installed.Digests[ref.ID()], app.Digests[ref.ID()], "digest of applied %s", ref.ID())
+		} else {
+			require.Equal(t, installed.Digests[ref.ID()], app.Digests[ref.ID()], "digest of excluded %s", ref.ID())
+		}
+	}
+
+	// partial delete
+	c.MockResource = c.Applied[0]
+	c.Calls = nil
+	require.NoError(t, testee.Delete(ctx, pkg.Name, client.DeleteOptions{}))
+	require.Equal(t, 1, countCalls("delete"), "delete calls")
+	app, err = appFromResource(c.Applied[0])
+	require.NoError(t, err)
+	for _, ref := range app.Resources {
+		require.NotEqual(t, "ConfigMap", ref.Kind(), "remaining resource")
+	}
+	require.Equal(t, len(installed.Resources)-2, len(app.Resources), "remaining resources")
+}
+
+func TestPackageManagerDeleteSelectedByLabel(t *testing.T) {
+	ctx := context.Background()
+	cluster := fakecluster.New(fakecluster.Options{})
+	ns := resource.Resource(namespaceRef("myns"), map[string]interface{}{})
+	cm1 := resource.Resource(resource.ResourceRef("v1", "ConfigMap", "myns", "cm1"), map[string]interface{}{})
+	cm2 := resource.Resource(resource.ResourceRef("v1", "ConfigMap", "myns", "cm2"), map[string]interface{}{})
+	pkg := &K8sPackage{"somepkg", resource.K8sResourceList{ns, cm1, cm2}}
+	for _, o := range pkg.Resources {
+		o.Raw()["metadata"].(map[string]interface{})["labels"] = map[string]interface{}{PKG_NAME_LABEL: pkg.Name}
+	}
+	cm2.Raw()["metadata"].(map[string]interface{})["labels"].(map[string]interface{})["app"] = "x"
+	testee := NewPackageManager(cluster, "myns")
+	require.NoError(t, testee.Apply(ctx, pkg, client.ApplyOptions{}))
+	sel, err := resource.ParseSelection([]string{"app=x"}, nil)
+	require.NoError(t, err)
+	testee.SetSelection(sel)
+	require.NoError(t, testee.Delete(ctx, pkg.Name, client.DeleteOptions{}))
+	keys := objectKeys(cluster)
+	require.Contains(t, keys, "configmap/myns/cm1", "unselected resource")
+	require.NotContains(t, keys, "configmap/myns/cm2", "resource selected by label")
+	app, err := testee.installedApp(ctx, pkg.Name)
+	require.NoError(t, err)
+	require.Equal(t, []string{"namespace/myns", "configmap/cm1"}, app.Resources.Names(), "remaining resources")
+}
+
+func TestPackageManagerApplyDryRun(t *testing.T) {
+	obj := mock.MockResourceList("../client/mock/get-list.json")
+	pkg := &K8sPackage{"somepkg", obj}
+	c := mock.NewClientMock()
+	c.MockResource = testAppResource(t, testApp)[0]
+	applied := testAppResource(t, testApp)[0]
+	applied.Raw()["metadata"].(map[string]interface{})["annotations"] = map[string]interface{}{corev1.LastAppliedConfigAnnotation: "{}"}
+	owned := resource.Resource(resource.ResourceRef("v1", "Pod", "myns", "owned"), map[string]interface{}{})
+	owned.Raw()["metadata"].(map[string]interface{})["annotations"] = map[string]interface{}{corev1.LastAppliedConfigAnnotation: "{}"}
+	owned.Raw()["metadata"].(map[string]interface{})["ownerReferences"] = []interface{}{map[string]interface{}{"kind": "ReplicaSet", "name": "myrs"}}
+	unapplied := resource.Resource(resource.ResourceRef("v1", "Pod", "myns", "unapplied"), map[string]interface{}{})
+	c.MockResources = resource.K8sResourceList{obj[0], obj[1], applied, owned, unapplied}
+	testee := NewPackageManager(c, "myns")
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+	err := testee.Apply(context.Background(), pkg, client.ApplyOptions{Prune: true, DryRun: client.DryRunClient})
+	require.NoError(t, err)
+	labels := fmt.Sprintf("[%s=%s]", PKG_NAME_LABEL, pkg.Name)
+	require.Equal(t, fmt.Sprintf("apply myns/ true %s dryrun=client", labels), c.Calls[0], "client calls")
+	for _, call := range c.Calls {
+		require.False(t, strings.HasPrefix(call, "apply myns/ false"), "should not write application record")
+		require.False(t, strings.HasPrefix(call, "watch "), "should not await rollout")
+	}
+	var msgs []string
+	for _, e := range hook.AllEntries() {
+		msgs = append(msgs, e.Message)
+	}
+	require.Contains(t, msgs, fmt.Sprintf("%s/%s -n %s configured (client dry run)", obj[0].QualifiedKind(), obj[0].Name(), obj[0].Namespace()))
+	require.Contains(t, msgs, fmt.Sprintf("%s.%s/%s -n %s pruned (client dry run)", strings.ToLower(CrdKind), CrdAPIGroup, testApp.Name, testApp.Namespace))
+	require.NotContains(t, msgs, "pod/owned -n myns pruned (client dry run)", "should not prune objects owned by another object")
+	require.NotContains(t, msgs, "pod/unapplied -n myns pruned (client dry run)", "should not prune objects that have not been applied")
+}
+
+func TestPackageManagerDiff(t *testing.T) {
+	obj := mock.MockResourceList("../client/mock/get-list.json")
+	pkg := &K8sPackage{testApp.Name, obj[:2]}
+	app := *testApp
+	app.Resources = obj[:3].Refs()
+	appRes := testAppResource(t, &app)[0]
+	var live resource.K8sResourceList
+	for _, res := range obj[:3] {
+		m := (&unstructured.Unstructured{Object: res.Raw()}).DeepCopy().Object
+		unstructured.SetNestedField(m, "12345", "metadata", "resourceVersion")
+		unstructured.SetNestedField(m, "someuid", "metadata", "uid")
+		live = append(live, resource.FromMap(m))
+	}
+
+	// unchanged
+	c := mock.NewClientMock()
+	c.MockResources = append(resource.K8sResourceList{appRes}, live...)
+	testee := NewPackageManager(c, testApp.Namespace)
+	var buf bytes.Buffer
+	removed := *pkg
+	removed.Resources = obj[:3]
+	changed, err := testee.Diff(context.Background(), &removed, &buf)
+	require.NoError(t, err)
+	require.False(t, changed, "changed")
+	require.Equal(t, "", buf.String(), "diff output")
+
+	// added, changed, removed
+	unstructured.SetNestedField(live[1].Raw(), "changedvalue", "metadata", "labels", "changedlabel")
+	c.MockResources = append(resource.K8sResourceList{appRes}, live[1:]...)
+	changed, err = testee.Diff(context.Background(), pkg, &buf)
+	require.NoError(t, err)
+	require.True(t, changed, "changed")
+	out := buf.String()
+	require.Contains(t, out, fmt.Sprintf("# %s added\n--- /dev/null\n+++ package/", displayName(obj[0])))
+	require.Contains(t, out, fmt.Sprintf("# %s changed\n--- live/", displayName(obj[1])))
+	require.Contains(t, out, "-    changedlabel: changedvalue\n")
+	require.Contains(t, out, fmt.Sprintf("# %s removed\n--- live/", displayName(obj[2])))
+	require.NotContains(t, out, "resourceVersion", "server-populated fields should be ignored")
+
+	// error
+	c.MockErr = fmt.Errorf("mock error")
+	_, err = testee.Diff(context.Background(), pkg, &buf)
+	require.Error(t, err)
+}
+
+func TestPackageManagerDiffIgnoresDefaults(t *testing.T) {
+	desired := resource.Resource(resource.ResourceRef("apps/v1", "Deployment", "myns", "api"), map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas": int64(1),
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "api", "image": "api:1", "ports": []interface{}{
+							map[string]interface{}{"containerPort": int64(8080)},
+						}},
+					},
+				},
+			},
+		},
+	})
+	live := resource.Resource(resource.ResourceRef("apps/v1", "Deployment", "myns", "api"), map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas":                int64(1),
+			"revisionHistoryLimit":    int64(10),
+			"progressDeadlineSeconds": int64(600),
+			"strategy": map[string]interface{}{
+				"type":          "RollingUpdate",
+				"rollingUpdate": map[string]interface{}{"maxSurge": "25%", "maxUnavailable": "25%"},
+			},
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"dnsPolicy":     "ClusterFirst",
+					"schedulerName": "default-scheduler",
+					"containers": []interface{}{
+						map[string]interface{}{
+							"name":                     "api",
+							"image":                    "api:1",
+							"terminationMessagePath":   "/dev/termination-log",
+							"terminationMessagePolicy": "File",
+							"ports": []interface{}{
+								map[string]interface{}{"containerPort": int64(8080), "protocol": "TCP"},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	unstructured.SetNestedField(live.Raw(), "12345", "metadata", "resourceVersion")
+	c := mock.NewClientMock()
+	c.MockResources = resource.K8sResourceList{live}
+	testee := NewPackageManager(c, "myns")
+	pkg := &K8sPackage{"somepkg", resource.K8sResourceList{desired}}
+	var buf bytes.Buffer
+	changed, err := testee.Diff(context.Background(), pkg, &buf)
+	require.NoError(t, err)
+	require.False(t, changed, "server-defaulted fields should not be reported as change")
+	require.Equal(t, "", buf.String(), "diff output")
+
+	// field that has been removed from the package since the last apply
+	unstructured.SetNestedField(live.Raw(), `{"spec":{"replicas":1,"revisionHistoryLimit":3}}`, "metadata", "annotations", "kubectl.kubernetes.io/last-applied-configuration")
+	changed, err = testee.Diff(context.Background(), pkg, &buf)
+	require.NoError(t, err)
+	require.True(t, changed, "removed field should be reported as change")
+	require.Contains(t, buf.String(), "-  revisionHistoryLimit: 10\n")
+	require.NotContains(t, buf.String(), "progressDeadlineSeconds")
+}
+
+func TestPackageManagerDiffSecret(t *testing.T) {
+	secret := func(data, stringData map[string]interface{}) *resource.K8sResource {
+		m := map[string]interface{}{"data": data}
+		if stringData != nil {
+			m["stringData"] = stringData
+		}
+		return resource.Resource(resource.ResourceRef("v1", "Secret", "myns", "mysecret"), m)
+	}
+	c := mock.NewClientMock()
+	// "p4ssw0rd" and "oldpw" base64-encoded
+	c.MockResources = resource.K8sResourceList{secret(map[string]interface{}{"a": "cDRzc3cwcmQ=", "b": "b2xkcHc="}, nil)}
+	testee := NewPackageManager(c, "myns")
+	pkg := &K8sPackage{"somepkg", resource.K8sResourceList{secret(map[string]interface{}{"a": "cDRzc3cwcmQ="}, map[string]interface{}{"b": "newpw"})}}
+	var buf bytes.Buffer
+	changed, err := testee.Diff(context.Background(), pkg, &buf)
+	require.NoError(t, err)
+	require.True(t, changed, "changed")
+	out := buf.String()
+	require.NotContains(t, out, "-  a: ", "unchanged value should be compared by hash")
+	require.Contains(t, out, "-  b: hmac-sha256:", "changed value")
+	require.NotContains(t, out, fmt.Sprintf("%x", sha256.Sum256([]byte("oldpw"))), "diff output should not contain unsalted hashes")
+	for _, value := range []string{"p4ssw0rd", "oldpw", "newpw", "cDRzc3cwcmQ=", "b2xkcHc="} {
+		require.NotContains(t, out, value, "diff output should not contain secret values")
+	}
+
+	buf.Reset()
+	testee.SetShowSecrets(true)
+	_, err = testee.Diff(context.Background(), pkg, &buf)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "-  b: oldpw\n+  b: newpw\n", "decoded values")
+}
+
 func TestPackageManagerList(t *testing.T) {
 	testApp2 := *testApp
 	testApp2.Name = testApp.Name + "2"
@@ -117,14 +438,15 @@ func TestPackageManagerDelete(t *testing.T) {
 	for _, ns := range []string{"", "myns"} {
 		expectedCalls := []string{
 			fmt.Sprintf("getresource %s/ %s.%s %s", ns, strings.ToLower(CrdKind), CrdAPIGroup, testApp.Name),
-			fmt.Sprintf("delete %s/ [deployment.apps/mydeployment apiservice.apiservice/myapi]", ns),
-			fmt.Sprintf("awaitdeletion %s/ [deployment.apps/mydeployment apiservice.apiservice/myapi]", ns),
+			"resourcetypes",
+			fmt.Sprintf("delete %s/ [apiservice.apiservice/myapi deployment.apps/mydeployment]", ns),
+			fmt.Sprintf("awaitdeletion %s/ [apiservice.apiservice/myapi deployment.apps/mydeployment]", ns),
 			fmt.Sprintf("delete %s/ [%s.%s/%s]", testApp.Namespace, strings.ToLower(CrdKind), CrdAPIGroup, testApp.Name),
 		}
 		assertPkgManagerCall(t, func(testee *PackageManager, c *mock.ClientMock) (err error) {
 			testee = NewPackageManager(c, ns)
 			c.MockResource = testAppResource(t, testApp)[0]
-			err = testee.Delete(context.Background(), testApp.Name)
+			err = testee.Delete(context.Background(), testApp.Name, client.DeleteOptions{})
 			if err == nil {
 				require.Equal(t, expectedCalls, c.Calls, "client calls")
 			}
@@ -132,3 +454,175 @@ func TestPackageManagerDelete(t *testing.T) {
 		})
 	}
 }
+
+func TestPackageManagerApplyCreateNamespaces(t *testing.T) {
+	ctx := context.Background()
+	cluster := fakecluster.New(fakecluster.Options{})
+	existing := resource.Resource(namespaceRef("existingns"), map[string]interface{}{})
+	_, err := cluster.Apply(ctx, "", resource.K8sResourceList{existing}, client.ApplyOptions{})
+	require.NoError(t, err)
+	cm := resource.Resource(resource.ResourceRef("v1", "ConfigMap", "otherns", "mycm"), map[string]interface{}{})
+	cm2 := resource.Resource(resource.ResourceRef("v1", "ConfigMap", "existingns", "mycm"), map[string]interface{}{})
+	pkg := &K8sPackage{"somepkg", resource.K8sResourceList{cm, cm2}}
+	testee := NewPackageManager(cluster, "myns")
+	testee.SetCreateNamespaces(true)
+	err = testee.Apply(ctx, pkg, client.ApplyOptions{})
+	require.NoError(t, err)
+	app, err := testee.installedApp(ctx, pkg.Name)
+	require.NoError(t, err)
+	require.Equal(t, []string{"myns", "otherns"}, app.Namespaces, "namespaces owned by the Application")
+
+	// ownership is kept when applied without namespace creation
+	testee.SetCreateNamespaces(false)
+	err = testee.Apply(ctx, pkg, client.ApplyOptions{})
+	require.NoError(t, err)
+	app, err = testee.installedApp(ctx, pkg.Name)
+	require.NoError(t, err)
+	require.Equal(t, []string{"myns", "otherns"}, app.Namespaces, "namespaces owned by the Application after reapply")
+
+	err = testee.Delete(ctx, pkg.Name, client.DeleteOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"namespace//existingns"}, objectKeys(cluster), "remaining objects")
+}
+
+// namespaceFailingCluster fails to create the namespace with the given name
+type namespaceFailingCluster struct {
+	*fakecluster.Cluster
+	namespace string
+}
+
+func (c *namespaceFailingCluster) Apply(ctx context.Context, namespace string, resources resource.K8sResourceList, opts client.ApplyOptions) (client.ApplyResults, error) {
+	for _, o := range resources {
+		if o.Kind() == "Namespace" && o.Name() == c.namespace {
+			return nil, fmt.Errorf("namespace creation error mock")
+		}
+	}
+	return c.Cluster.Apply(ctx, namespace, resources, opts)
+}
+
+func TestPackageManagerApplyCreateNamespacesError(t *testing.T) {
+	ctx := context.Background()
+	cluster := &namespaceFailingCluster{fakecluster.New(fakecluster.Options{}), "otherns"}
+	cm := resource.Resource(resource.ResourceRef("v1", "ConfigMap", "otherns", "mycm"), map[string]interface{}{})
+	pkg := &K8sPackage{"somepkg", resource.K8sResourceList{cm}}
+	testee := NewPackageManager(cluster, "myns")
+	testee.SetCreateNamespaces(true)
+	err := testee.Apply(ctx, pkg, client.ApplyOptions{})
+	require.Error(t, err)
+	require.Contains(t, objectKeys(cluster.Cluster), "namespace//myns", "created namespace")
+	app, err := testee.installedApp(ctx, pkg.Name)
+	require.NoError(t, err)
+	require.NotNil(t, app, "Application should be recorded")
+	require.Equal(t, []string{"myns"}, app.Namespaces, "namespaces owned by the Application")
+}
+
+func objectKeys(cluster *fakecluster.Cluster) (keys []string) {
+	for _, o := range cluster.Objects() {
+		keys = append(keys, fmt.Sprintf("%s/%s/%s", o.QualifiedKind(), o.Namespace(), o.Name()))
+	}
+	return
+}
+
+func TestPackageManagerDeleteNamespaces(t *testing.T) {
+	app := *testApp
+	app.Namespaces = []string{"myns", "otherns"}
+	c := mock.NewClientMock()
+	c.MockResource = resourceFromApp(&app)
+	testee := NewPackageManager(c, "myns")
+	err := testee.Delete(context.Background(), app.Name, client.DeleteOptions{})
+	require.NoError(t, err)
+	expectedCalls := []string{
+		fmt.Sprintf("delete myns/ [%s.%s/%s]", strings.ToLower(CrdKind), CrdAPIGroup, app.Name),
+		"delete myns/ [namespace/myns namespace/otherns]",
+		"awaitdeletion myns/ [namespace/myns namespace/otherns]",
+	}
+	require.Equal(t, expectedCalls, c.Calls[len(c.Calls)-3:], "client calls")
+}
+
+func TestPackageManagerDeleteDryRun(t *testing.T) {
+	c := mock.NewClientMock()
+	c.MockResource = testAppResource(t, testApp)[0]
+	testee := NewPackageManager(c, "myns")
+	err := testee.Delete(context.Background(), testApp.Name, client.DeleteOptions{DryRun: client.DryRunServer})
+	require.NoError(t, err)
+	expectedCalls := []string{
+		fmt.Sprintf("getresource myns/ %s.%s %s", strings.ToLower(CrdKind), CrdAPIGroup, testApp.Name),
+		"delete myns/ [apiservice.apiservice/myapi deployment.apps/mydeployment] dryrun=server",
+		"getresource myns/ apiservice.apiservice myapi",
+		"getresource myns/ deployment.apps mydeployment",
+	}
+	require.Equal(t, expectedCalls, c.Calls, "client calls")
+}
+
+func TestPackageManagerResolveRefs(t *testing.T) {
+	c := mock.NewClientMock()
+	c.MockTypes = []*client.APIResourceType{
+		{Name: "namespaces", Kind: "Namespace", Version: "v1", Versions: []string{"v1"}},
+		{Name: "deployments", APIGroup: "apps", Kind: "Deployment", Namespaced: true, Version: "v1", Versions: []string{"v1", "v1beta2"}},
+	}
+	testee := NewPackageManager(c, "myns")
+	refs := resource.K8sResourceRefList{
+		resource.ResourceRef("apps/v1beta2", "Deployment", "", "mydeployment"),
+		resource.ResourceRef("v1", "Namespace", "myns", "othernamespace"),
+		resource.ResourceRef("example.org/v1", "Unknown", "myns", "unknown"),
+		resource.ResourceRef("extensions/v1beta1", "Deployment", "", "legacydeployment"),
+	}
+	resolved, err := testee.resolveRefs(context.Background(), refs)
+	require.NoError(t, err)
+	expected := resource.K8sResourceRefList{
+		resource.ResourceRef("apps/v1", "Deployment", "", "mydeployment"),
+		resource.ResourceRef("v1", "Namespace", "", "othernamespace"),
+		refs[2],
+		resource.ResourceRef("apps/v1", "Deployment", "", "legacydeployment"),
+	}
+	require.Equal(t, expected, resolved)
+	_, err = testee.resolveRefs(context.Background(), refs)
+	require.NoError(t, err)
+	require.Equal(t, []string{"resourcetypes"}, c.Calls, "client calls")
+
+	for _, tc := range []struct {
+		ref  resource.K8sResourceRef
+		kind string
+		ns   string
+	}{
+		{refs[0], "deployments.v1.apps", "myns"},
+		{refs[1], "namespaces.v1.", ""},
+		{refs[2], "Unknown", "myns"},
+		{refs[3], "deployments.v1.apps", "myns"},
+	} {
+		kind, ns := testee.watchKind(tc.ref, "myns")
+		require.Equal(t, tc.kind, kind, "watch kind of %s", tc.ref.ID())
+		require.Equal(t, tc.ns, ns, "watch namespace of %s", tc.ref.ID())
+	}
+
+	c = mock.NewClientMock()
+	c.MockErr = fmt.Errorf("mock error")
+	_, err = NewPackageManager(c, "myns").resolveRefs(context.Background(), refs)
+	require.Error(t, err)
+
+	// identify types served within several API groups by the same group-kind
+	c = mock.NewClientMock()
+	c.MockTypes = []*client.APIResourceType{
+		{Name: "deployments", APIGroup: "apps", Kind: "Deployment", Namespaced: true, Version: "v1"},
+		{Name: "deployments", APIGroup: "extensions", Kind: "Deployment", Namespaced: true, Version: "v1beta1"},
+		{Name: "things", APIGroup: "legacy.example.org", Kind: "Thing", Namespaced: true, Version: "v1"},
+		{Name: "things", APIGroup: "example.org", Kind: "Thing", Namespaced: true, Version: "v1"},
+	}
+	testee = NewPackageManager(c, "myns")
+	thing := resource.ResourceRef("example.org/v1", "Thing", "myns", "mything")
+	legacyThing := resource.ResourceRef("legacy.example.org/v1", "Thing", "myns", "mything")
+	resolved, err = testee.resolveRefs(context.Background(), resource.K8sResourceRefList{refs[3], legacyThing})
+	require.NoError(t, err)
+	expected = resource.K8sResourceRefList{
+		resource.ResourceRef("apps/v1", "Deployment", "", "legacydeployment"),
+		resource.ResourceRef("example.org/v1", "Thing", "myns", "mything"),
+	}
+	require.Equal(t, expected, resolved, "resolved aliases")
+	require.Equal(t, "thing.example.org", testee.groupKind(legacyThing), "group-kind of alias")
+	require.Equal(t, testee.id(thing), testee.id(legacyThing), "alias ID")
+	require.Equal(t, "deployment.apps", testee.groupKind(refs[3]), "group-kind of legacy kind")
+	require.NotEqual(t, thing.ID(), legacyThing.ID(), "static ID of unknown alias")
+	pkg := resource.K8sResourceList{resource.Resource(thing, map[string]interface{}{})}
+	require.True(t, testee.containsResource(pkg, legacyThing), "should contain alias")
+	require.False(t, NewPackageManager(c, "myns").containsResource(pkg, legacyThing), "should not contain alias before resource types are loaded")
+}
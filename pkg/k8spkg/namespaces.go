@@ -0,0 +1,81 @@
+package k8spkg
+
+import (
+	"context"
+	"sort"
+
+	"github.com/mgoltzsche/k8spkg/pkg/client"
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/pkg/errors"
+)
+
+func namespaceRef(name string) resource.K8sResourceRef {
+	return resource.ResourceRef("v1", "Namespace", "", name)
+}
+
+func namespaceRefs(names []string) (refs resource.K8sResourceRefList) {
+	for _, name := range names {
+		refs = append(refs, namespaceRef(name))
+	}
+	return
+}
+
+// targetNamespaces returns the namespaces the package's resources are applied to
+// except those the package defines itself
+func (m *PackageManager) targetNamespaces(pkg *K8sPackage) (namespaces []string) {
+	names := containedNamespaces(pkg.Resources)
+	if m.namespace != "" {
+		names = mergeNamespaces(names, []string{m.namespace})
+	}
+	for _, name := range names {
+		if !m.containsResource(pkg.Resources, namespaceRef(name)) {
+			namespaces = append(namespaces, name)
+		}
+	}
+	return
+}
+
+// missingNamespaces returns the provided namespaces that do not exist
+func (m *PackageManager) missingNamespaces(ctx context.Context, names []string) (missing []string, err error) {
+	for _, name := range names {
+		_, e := m.client.GetResource(ctx, "namespace", "", name)
+		if client.IsNotFound(e) {
+			missing = append(missing, name)
+		} else if e != nil {
+			return nil, errors.Wrapf(e, "get namespace %s", name)
+		}
+	}
+	return
+}
+
+// createNamespaces creates the provided namespaces unless they exist and returns the created ones
+func (m *PackageManager) createNamespaces(ctx context.Context, names []string) (created []string, err error) {
+	missing, err := m.missingNamespaces(ctx, names)
+	if err != nil {
+		return
+	}
+	for _, name := range missing {
+		ns := resource.Resource(namespaceRef(name), map[string]interface{}{})
+		if _, err = m.client.Apply(ctx, "", resource.K8sResourceList{ns}, client.ApplyOptions{}); err != nil {
+			return created, errors.Wrapf(err, "create namespace %s", name)
+		}
+		m.log.Infof("Created namespace %s", name)
+		created = append(created, name)
+	}
+	return
+}
+
+// mergeNamespaces returns the sorted union of the provided namespace names
+func mergeNamespaces(a, b []string) (merged []string) {
+	set := map[string]bool{}
+	for _, l := range [][]string{a, b} {
+		for _, name := range l {
+			if !set[name] {
+				set[name] = true
+				merged = append(merged, name)
+			}
+		}
+	}
+	sort.Strings(merged)
+	return
+}
@@ -0,0 +1,67 @@
+package k8spkg
+
+import (
+	"sort"
+
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+)
+
+// installOrder lists the group-qualified kinds (see K8sResourceRef.GroupKind)
+// in the order they are installed.
+// Kinds that are not listed are considered custom resources and installed last.
+// Resources are uninstalled in the exact reverse order.
+var installOrder = [][]string{
+	{"namespace", "resourcequota", "limitrange", "podsecuritypolicy.policy"},
+	{"customresourcedefinition.apiextensions.k8s.io"},
+	{"serviceaccount"},
+	{"clusterrole.rbac.authorization.k8s.io", "role.rbac.authorization.k8s.io", "clusterrolebinding.rbac.authorization.k8s.io", "rolebinding.rbac.authorization.k8s.io"},
+	{"priorityclass.scheduling.k8s.io", "storageclass.storage.k8s.io", "persistentvolume", "persistentvolumeclaim"},
+	{"configmap", "secret"},
+	{"service", "endpoints", "ingress.networking.k8s.io", "networkpolicy.networking.k8s.io"},
+	{"deployment.apps", "statefulset.apps", "daemonset.apps", "replicaset.apps", "replicationcontroller", "pod", "job.batch", "cronjob.batch"},
+	{"horizontalpodautoscaler.autoscaling", "poddisruptionbudget.policy"},
+	{"mutatingwebhookconfiguration.admissionregistration.k8s.io", "validatingwebhookconfiguration.admissionregistration.k8s.io"},
+	{"apiservice.apiregistration.k8s.io"},
+}
+
+var installRanks = func() map[string]int {
+	ranks := map[string]int{}
+	for i, kinds := range installOrder {
+		for _, kind := range kinds {
+			ranks[kind] = i
+		}
+	}
+	return ranks
+}()
+
+// installRank returns the position of the resource's group-qualified kind within the install order
+func installRank(ref resource.K8sResourceRef) int {
+	if rank, ok := installRanks[ref.GroupKind()]; ok {
+		return rank
+	}
+	return len(installOrder)
+}
+
+// sortForInstall returns the resources in install order.
+// Resources of the same rank keep their input order.
+func sortForInstall(resources resource.K8sResourceList) resource.K8sResourceList {
+	sorted := make(resource.K8sResourceList, len(resources))
+	copy(sorted, resources)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return installRank(sorted[i]) < installRank(sorted[j])
+	})
+	return sorted
+}
+
+// sortForUninstall returns the resources in the exact reverse install order
+func sortForUninstall(refs resource.K8sResourceRefList) resource.K8sResourceRefList {
+	sorted := make(resource.K8sResourceRefList, len(refs))
+	copy(sorted, refs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return installRank(sorted[i]) < installRank(sorted[j])
+	})
+	for i, j := 0, len(sorted)-1; i < j; i, j = i+1, j-1 {
+		sorted[i], sorted[j] = sorted[j], sorted[i]
+	}
+	return sorted
+}
@@ -0,0 +1,52 @@
+package k8spkg
+
+import (
+	"testing"
+
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstallOrder(t *testing.T) {
+	input := resource.K8sResourceList{
+		resource.Resource(resource.ResourceRef("example.org/v1", "MyResource", "myns", "mycr"), map[string]interface{}{}),
+		resource.Resource(resource.ResourceRef("serving.knative.dev/v1", "Service", "myns", "myksvc"), map[string]interface{}{}),
+		resource.Resource(resource.ResourceRef("extensions/v1beta1", "Deployment", "myns", "mylegacydeployment"), map[string]interface{}{}),
+		resource.Resource(resource.ResourceRef("apps/v1", "Deployment", "myns", "mydeployment"), map[string]interface{}{}),
+		resource.Resource(resource.ResourceRef("v1", "ConfigMap", "myns", "mycm"), map[string]interface{}{}),
+		resource.Resource(resource.ResourceRef("apiregistration.k8s.io/v1", "APIService", "", "myapi"), map[string]interface{}{}),
+		resource.Resource(resource.ResourceRef("v1", "Secret", "myns", "mysecret"), map[string]interface{}{}),
+		resource.Resource(resource.ResourceRef("admissionregistration.k8s.io/v1", "ValidatingWebhookConfiguration", "", "mywebhook"), map[string]interface{}{}),
+		resource.Resource(resource.ResourceRef("v1", "Service", "myns", "mysvc"), map[string]interface{}{}),
+		resource.Resource(resource.ResourceRef("rbac.authorization.k8s.io/v1", "RoleBinding", "myns", "mybinding"), map[string]interface{}{}),
+		resource.Resource(resource.ResourceRef("v1", "ServiceAccount", "myns", "mysa"), map[string]interface{}{}),
+		resource.Resource(resource.ResourceRef("apiextensions.k8s.io/v1", "CustomResourceDefinition", "", "mycrd"), map[string]interface{}{}),
+		resource.Resource(resource.ResourceRef("v1", "Namespace", "", "myns"), map[string]interface{}{}),
+	}
+	expected := []string{
+		"namespace/myns",
+		"customresourcedefinition.apiextensions.k8s.io/mycrd",
+		"serviceaccount/mysa",
+		"rolebinding.rbac.authorization.k8s.io/mybinding",
+		"configmap/mycm",
+		"secret/mysecret",
+		"service/mysvc",
+		"deployment.extensions/mylegacydeployment",
+		"deployment.apps/mydeployment",
+		"validatingwebhookconfiguration.admissionregistration.k8s.io/mywebhook",
+		"apiservice.apiregistration.k8s.io/myapi",
+		"myresource.example.org/mycr",
+		"service.serving.knative.dev/myksvc",
+	}
+	require.Equal(t, expected, sortForInstall(input).Refs().Names(), "install order")
+	reversed := make([]string, len(expected))
+	for i, name := range expected {
+		reversed[len(expected)-1-i] = name
+	}
+	require.Equal(t, reversed, sortForUninstall(input.Refs()).Names(), "uninstall order")
+
+	stages := applyStages(input, 0)
+	require.Equal(t, "namespace", stages[0][0].kind, "first apply stage")
+	require.Equal(t, "myresource.example.org", stages[len(stages)-2][0].kind, "custom resource apply stage")
+	require.Equal(t, "service.serving.knative.dev", stages[len(stages)-1][0].kind, "last apply stage")
+}
@@ -0,0 +1,135 @@
+package k8spkg
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mgoltzsche/k8spkg/pkg/client"
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/pkg/errors"
+)
+
+// loadResourceTypes lazily loads the cluster's API resource types indexed by
+// qualified kind and, unless served, by their canonical group-kind as well.
+// Types the cluster serves within several API groups are identified by one of
+// their group-kinds (see groupKind).
+func (m *PackageManager) loadResourceTypes(ctx context.Context) (err error) {
+	if m.resourceTypes != nil {
+		return
+	}
+	types, err := m.client.ResourceTypes(ctx)
+	if err != nil {
+		return errors.Wrap(err, "resolve resource types")
+	}
+	m.resourceTypes = map[string]*client.APIResourceType{}
+	aliases := map[string][]string{}
+	for _, t := range types {
+		kind := qualifiedKind(t)
+		m.resourceTypes[kind] = t
+		key := t.Kind + "/" + t.Name
+		aliases[key] = append(aliases[key], kind)
+	}
+	m.groupKinds = map[string]string{}
+	for _, kinds := range aliases {
+		if len(kinds) > 1 {
+			canonical := canonicalAlias(kinds)
+			for _, kind := range kinds {
+				m.groupKinds[kind] = canonical
+			}
+		}
+	}
+	for _, t := range types {
+		// allows to resolve a kind of a legacy API group the cluster doesn't serve anymore and vice versa
+		if gk := m.groupKind(resource.ResourceRef(t.APIVersion(), t.Kind, "", "")); m.resourceTypes[gk] == nil {
+			m.resourceTypes[gk] = t
+		}
+	}
+	return
+}
+
+func qualifiedKind(t *client.APIResourceType) string {
+	kind := strings.ToLower(t.Kind)
+	if t.APIGroup != "" {
+		kind += "." + t.APIGroup
+	}
+	return kind
+}
+
+// canonicalAlias returns the group-kind that identifies the qualified kinds
+// the cluster serves as the same resource type.
+// The static table's group-kind is preferred (see resource.CanonicalGroupKind),
+// aliases it doesn't know are identified by the first group-kind in alphabetical order.
+func canonicalAlias(kinds []string) string {
+	sorted := append([]string{}, kinds...)
+	sort.Strings(sorted)
+	for _, kind := range sorted {
+		if gk := resource.CanonicalGroupKind(kind); gk != kind {
+			return gk // known by the static table
+		}
+	}
+	return sorted[0]
+}
+
+// groupKind returns the group-kind that identifies the resource's type.
+// Types the cluster serves within several API groups are identified by the
+// same group-kind, other types by the static table's group-kind.
+// Falls back to the static table if the resource types are not loaded.
+func (m *PackageManager) groupKind(ref resource.K8sResourceRef) string {
+	if gk, ok := m.groupKinds[ref.QualifiedKind()]; ok {
+		return gk
+	}
+	gk := ref.GroupKind()
+	if canonical, ok := m.groupKinds[gk]; ok {
+		return canonical
+	}
+	return gk
+}
+
+// id returns the resource's ID based on the group-kind the cluster identifies it with
+func (m *PackageManager) id(ref resource.K8sResourceRef) string {
+	return fmt.Sprintf("%s:%s:%s", m.groupKind(ref), ref.Namespace(), ref.Name())
+}
+
+// resourceType returns the API resource type of the provided resource or nil if the cluster does not serve it (yet).
+// Types served within several API groups resolve to the type of their canonical group-kind.
+func (m *PackageManager) resourceType(ref resource.K8sResourceRef) *client.APIResourceType {
+	if t := m.resourceTypes[m.groupKind(ref)]; t != nil {
+		return t
+	}
+	return m.resourceTypes[ref.QualifiedKind()]
+}
+
+// resolveRefs returns the provided references with the server's preferred
+// version and without namespace if the resource is cluster-scoped.
+// References of types the cluster does not serve are returned unchanged.
+func (m *PackageManager) resolveRefs(ctx context.Context, refs resource.K8sResourceRefList) (resolved resource.K8sResourceRefList, err error) {
+	if err = m.loadResourceTypes(ctx); err != nil {
+		return
+	}
+	resolved = make(resource.K8sResourceRefList, len(refs))
+	for i, ref := range refs {
+		resolved[i] = ref
+		if t := m.resourceType(ref); t != nil {
+			ns := ref.Namespace()
+			if !t.Namespaced {
+				ns = ""
+			}
+			resolved[i] = resource.ResourceRef(t.APIVersion(), t.Kind, ns, ref.Name())
+		}
+	}
+	return
+}
+
+// watchKind returns the kind argument used to watch the provided resource and the namespace to watch it in
+func (m *PackageManager) watchKind(ref resource.K8sResourceRef, namespace string) (kind, ns string) {
+	kind, ns = ref.Kind(), namespace
+	if t := m.resourceType(ref); t != nil {
+		kind = t.QualifiedName()
+		if !t.Namespaced {
+			ns = ""
+		}
+	}
+	return
+}
@@ -0,0 +1,62 @@
+package k8spkg
+
+import (
+	"bytes"
+	"context"
+	"os"
+
+	"github.com/mgoltzsche/k8spkg/pkg/client"
+	"github.com/mgoltzsche/k8spkg/pkg/validate"
+	"github.com/pkg/errors"
+)
+
+// SchemaSource loads the schemas packages are validated against
+type SchemaSource func(ctx context.Context, c client.K8sClient) (*validate.Schemas, error)
+
+// BuiltinSchemas provides the schemas of the Kubernetes API k8spkg has been built with
+func BuiltinSchemas(ctx context.Context, c client.K8sClient) (*validate.Schemas, error) {
+	return validate.Builtin(), nil
+}
+
+// ClusterSchemas provides the schemas the cluster's API server serves
+func ClusterSchemas(ctx context.Context, c client.K8sClient) (*validate.Schemas, error) {
+	b, err := client.OpenAPISchema(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return validate.FromOpenAPI(bytes.NewReader(b))
+}
+
+// FileSchemas provides the schemas of a local OpenAPI v2 document
+func FileSchemas(file string) SchemaSource {
+	return func(ctx context.Context, c client.K8sClient) (*validate.Schemas, error) {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "schema")
+		}
+		defer f.Close()
+		s, err := validate.FromOpenAPI(f)
+		return s, errors.Wrap(err, file)
+	}
+}
+
+// SetSchemaSource makes Apply validate the package against the provided schemas.
+// A nil source disables the validation.
+func (m *PackageManager) SetSchemaSource(source SchemaSource) {
+	m.schemas = source
+}
+
+// Validate validates the package's resources against the configured schemas
+// or the builtin schemas if none are configured.
+// The returned error's cause is a *validate.Error if the package is invalid.
+func (m *PackageManager) Validate(ctx context.Context, pkg *K8sPackage) (err error) {
+	source := m.schemas
+	if source == nil {
+		source = BuiltinSchemas
+	}
+	schemas, err := source(ctx, m.client)
+	if err != nil {
+		return errors.Wrapf(err, "validate package %s", pkg.Name)
+	}
+	return errors.Wrapf(schemas.Validate(pkg.Resources), "validate package %s", pkg.Name)
+}
@@ -0,0 +1,80 @@
+package resource
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ServerFields are populated by the server and therefore ignored when
+// comparing a resource with its desired state
+var ServerFields = [][]string{
+	{"status"},
+	{"metadata", "managedFields"},
+	{"metadata", "resourceVersion"},
+	{"metadata", "uid"},
+	{"metadata", "creationTimestamp"},
+	{"metadata", "generation"},
+	{"metadata", "selfLink"},
+	{"metadata", "annotations", "kubectl.kubernetes.io/last-applied-configuration"},
+	{"metadata", "annotations", "deployment.kubernetes.io/revision"},
+}
+
+// Normalized returns a copy of the resource's content without server-populated
+// fields, empty annotations and an empty namespace.
+func (o *K8sResource) Normalized() (obj map[string]interface{}, err error) {
+	b, err := json.Marshal(o.raw)
+	if err != nil {
+		return nil, errors.Wrapf(err, "normalize resource %s", o.ID())
+	}
+	if err = json.Unmarshal(b, &obj); err != nil {
+		return nil, errors.Wrapf(err, "normalize resource %s", o.ID())
+	}
+	for _, field := range ServerFields {
+		unstructured.RemoveNestedField(obj, field...)
+	}
+	if annotations, _, _ := unstructured.NestedMap(obj, "metadata", "annotations"); len(annotations) == 0 {
+		unstructured.RemoveNestedField(obj, "metadata", "annotations")
+	}
+	if ns, _, _ := unstructured.NestedString(obj, "metadata", "namespace"); ns == "" {
+		unstructured.RemoveNestedField(obj, "metadata", "namespace")
+	}
+	return
+}
+
+// Digest returns the SHA-256 digest of the resource's normalized content.
+// It is stable across map orderings and changes whenever the desired state changes.
+func (o *K8sResource) Digest() (string, error) {
+	obj, err := o.Normalized()
+	if err != nil {
+		return "", err
+	}
+	// map keys are marshalled in sorted order
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return "", errors.Wrapf(err, "digest resource %s", o.ID())
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(b)), nil
+}
+
+// Digest returns a digest of the resources' digests that does not depend on their order
+func (l K8sResourceList) Digest() (string, error) {
+	entries := make([]string, len(l))
+	for i, o := range l {
+		d, err := o.Digest()
+		if err != nil {
+			return "", err
+		}
+		entries[i] = o.ID() + "=" + d + "\n"
+	}
+	sort.Strings(entries)
+	h := sha256.New()
+	for _, e := range entries {
+		h.Write([]byte(e))
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,61 @@
+package resource
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigest(t *testing.T) {
+	l, err := FromReader(bytes.NewReader([]byte(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+  namespace: myns
+data:
+  x: "1"
+  y: "2"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  namespace: myns
+  name: a
+  uid: someuid
+  resourceVersion: "42"
+  annotations: {}
+data:
+  y: "2"
+  x: "1"
+status: {}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+  namespace: myns
+data:
+  x: "1"
+  y: "3"
+`)))
+	require.NoError(t, err)
+	digests := make([]string, len(l))
+	for i, o := range l {
+		digests[i], err = o.Digest()
+		require.NoError(t, err)
+	}
+	require.Regexp(t, "^sha256:[0-9a-f]{64}$", digests[0])
+	require.Equal(t, digests[0], digests[1], "digest should ignore field order and server-populated fields")
+	require.NotEqual(t, digests[0], digests[2], "digest should change with the content")
+
+	d1, err := K8sResourceList{l[0], l[2]}.Digest()
+	require.NoError(t, err)
+	d2, err := K8sResourceList{l[2], l[1]}.Digest()
+	require.NoError(t, err)
+	require.Equal(t, d1, d2, "list digest should not depend on the order")
+	d3, err := K8sResourceList{l[0]}.Digest()
+	require.NoError(t, err)
+	require.NotEqual(t, d1, d3, "list digest")
+}
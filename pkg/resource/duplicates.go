@@ -0,0 +1,130 @@
+package resource
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// DuplicateStrategy specifies how resources that occur multiple times within an input are handled
+type DuplicateStrategy string
+
+const (
+	// DuplicateError rejects the input
+	DuplicateError DuplicateStrategy = "error"
+	// DuplicateFirst keeps the first occurrence
+	DuplicateFirst DuplicateStrategy = "first"
+	// DuplicateLast keeps the last occurrence
+	DuplicateLast DuplicateStrategy = "last"
+	// DuplicateMerge merges each occurrence onto the previous ones
+	DuplicateMerge DuplicateStrategy = "merge"
+)
+
+// ParseDuplicateStrategy parses an --on-duplicate option value
+func ParseDuplicateStrategy(s string) (DuplicateStrategy, error) {
+	switch d := DuplicateStrategy(s); d {
+	case DuplicateError, DuplicateFirst, DuplicateLast, DuplicateMerge:
+		return d, nil
+	}
+	return DuplicateError, errors.Errorf("unsupported duplicate strategy %q provided, expected %s, %s, %s or %s", s, DuplicateError, DuplicateFirst, DuplicateLast, DuplicateMerge)
+}
+
+// Deduplicate returns a list that contains each resource ID only once.
+// The strategy decides whether duplicates are rejected, the first or last
+// occurrence is kept or the later occurrences are merged onto the earlier ones
+// using a strategic merge patch (a JSON merge patch for unknown kinds).
+// A resource keeps the position of its first occurrence.
+func (l K8sResourceList) Deduplicate(strategy DuplicateStrategy) (r K8sResourceList, err error) {
+	positions := map[string]int{}
+	duplicates := map[string]K8sResourceList{}
+	var ids []string
+	for _, res := range l {
+		id := res.ID()
+		pos, found := positions[id]
+		if !found {
+			positions[id] = len(r)
+			r = append(r, res)
+			continue
+		}
+		if len(duplicates[id]) == 0 {
+			ids = append(ids, id)
+			duplicates[id] = K8sResourceList{r[pos]}
+		}
+		duplicates[id] = append(duplicates[id], res)
+		switch strategy {
+		case DuplicateLast:
+			r[pos] = res
+		case DuplicateMerge:
+			if r[pos], err = mergeResource(r[pos], res); err != nil {
+				return nil, errors.Wrapf(err, "merge duplicate %s from %s", displayName(res), res.Source())
+			}
+		}
+	}
+	if strategy == DuplicateError && len(ids) > 0 {
+		msgs := make([]string, len(ids))
+		for i, id := range ids {
+			sources := make([]string, len(duplicates[id]))
+			for j, res := range duplicates[id] {
+				sources[j] = res.Source()
+			}
+			msgs[i] = fmt.Sprintf("%s in %s", displayName(duplicates[id][0]), strings.Join(sources, ", "))
+		}
+		return nil, errors.Errorf("duplicate resources:\n  %s", strings.Join(msgs, "\n  "))
+	}
+	return
+}
+
+// mergeResource returns a resource that contains the patch's fields merged onto the original.
+// The result keeps the original's formatting and source.
+func mergeResource(original, patch *K8sResource) (*K8sResource, error) {
+	raw, err := strategicMerge(original, patch.raw)
+	if err != nil {
+		return nil, err
+	}
+	merged := FromMap(raw)
+	merged.node = original.node
+	merged.source = original.source
+	return merged, nil
+}
+
+// strategicMerge merges the patch onto a copy of the original using a
+// strategic merge patch for built-in types and a JSON merge patch for all other types
+func strategicMerge(original *K8sResource, patch map[string]interface{}) (map[string]interface{}, error) {
+	versioned, err := scheme.Scheme.New(schema.FromAPIVersionAndKind(original.APIVersion(), original.Kind()))
+	if runtime.IsNotRegisteredError(err) {
+		return mergePatch(original.raw, patch), nil
+	} else if err != nil {
+		return nil, err
+	}
+	return strategicpatch.StrategicMergeMapPatch(runtime.DeepCopyJSON(original.raw), patch, versioned)
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386) to a copy of the original
+func mergePatch(original, patch map[string]interface{}) map[string]interface{} {
+	merged := copyMap(original)
+	for k, v := range patch {
+		if v == nil {
+			delete(merged, k)
+		} else if pm, ok := v.(map[string]interface{}); ok {
+			om, _ := merged[k].(map[string]interface{})
+			merged[k] = mergePatch(om, pm)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// displayName returns the resource's qualified kind and name and its namespace if set
+func displayName(ref K8sResourceRef) (name string) {
+	name = ref.QualifiedKind() + "/" + ref.Name()
+	if ref.Namespace() != "" {
+		name += " -n " + ref.Namespace()
+	}
+	return
+}
@@ -0,0 +1,104 @@
+package resource
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDeduplicate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8spkg-test-duplicates-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for file, content := range map[string]string{
+		"a.yaml": `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: mydeployment
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:1.0
+---
+apiVersion: example.org/v1
+kind: Thing
+metadata:
+  name: mything
+spec:
+  size: 1
+  colour: red
+`,
+		"b.yaml": `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: myconfig
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: mydeployment
+spec:
+  template:
+    spec:
+      containers:
+      - name: sidecar
+        image: sidecar:1.0
+---
+apiVersion: example.org/v1
+kind: Thing
+metadata:
+  name: mything
+spec:
+  size: 2
+  colour: null
+`,
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, file), []byte(content), 0644))
+	}
+	l, err := FromReader(ManifestReader(context.Background(), dir, dir))
+	require.NoError(t, err)
+	require.Equal(t, 5, len(l), "resources")
+	require.Equal(t, dir+"/b.yaml (document 2)", l[3].Source(), "source")
+
+	_, err = l.Deduplicate(DuplicateError)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "deployment.apps/mydeployment in "+dir+"/a.yaml (document 1), "+dir+"/b.yaml (document 2)")
+	require.Contains(t, err.Error(), "thing.example.org/mything in "+dir+"/a.yaml (document 2), "+dir+"/b.yaml (document 3)")
+
+	containers := func(res *K8sResource) (names []string) {
+		l, _, _ := unstructured.NestedSlice(res.Raw(), "spec", "template", "spec", "containers")
+		for _, c := range l {
+			names = append(names, c.(map[string]interface{})["name"].(string))
+		}
+		return
+	}
+	r, err := l.Deduplicate(DuplicateFirst)
+	require.NoError(t, err)
+	require.Equal(t, []string{"deployment.apps/mydeployment", "thing.example.org/mything", "configmap/myconfig"}, r.Refs().Names(), "first")
+	require.Equal(t, []string{"app"}, containers(r[0]), "first")
+	r, err = l.Deduplicate(DuplicateLast)
+	require.NoError(t, err)
+	require.Equal(t, []string{"deployment.apps/mydeployment", "thing.example.org/mything", "configmap/myconfig"}, r.Refs().Names(), "last")
+	require.Equal(t, []string{"sidecar"}, containers(r[0]), "last")
+	r, err = l.Deduplicate(DuplicateMerge)
+	require.NoError(t, err)
+	require.Equal(t, []string{"deployment.apps/mydeployment", "thing.example.org/mything", "configmap/myconfig"}, r.Refs().Names(), "merge")
+	require.Equal(t, []string{"sidecar", "app"}, containers(r[0]), "merged containers")
+	replicas, _, _ := unstructured.NestedFieldNoCopy(r[0].Raw(), "spec", "replicas")
+	require.NotNil(t, replicas, "merged replicas")
+	require.Equal(t, map[string]interface{}{"size": float64(2)}, r[1].Raw()["spec"], "merged custom resource spec")
+	require.Equal(t, []string{"app"}, containers(l[0]), "original should not be modified")
+
+	_, err = ParseDuplicateStrategy("unsupported")
+	require.Error(t, err)
+}
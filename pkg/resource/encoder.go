@@ -0,0 +1,154 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+// Encoder writes resources in a specific format
+type Encoder interface {
+	Encode(writer io.Writer, resources K8sResourceList) error
+}
+
+// EncoderFunc adapts a function to the Encoder interface
+type EncoderFunc func(writer io.Writer, resources K8sResourceList) error
+
+// Encode calls the function
+func (f EncoderFunc) Encode(writer io.Writer, resources K8sResourceList) error {
+	return f(writer, resources)
+}
+
+var (
+	encoders = map[string]Encoder{
+		"yaml":     EncoderFunc(encodeYaml),
+		"json":     EncoderFunc(encodeJson),
+		"jsonlist": EncoderFunc(encodeJsonList),
+		"name":     EncoderFunc(encodeNames),
+		"table":    NewTableEncoder(),
+	}
+	encodersLock sync.RWMutex
+)
+
+// RegisterEncoder registers an encoder for the format, replacing an existing one
+func RegisterEncoder(format string, encoder Encoder) {
+	encodersLock.Lock()
+	defer encodersLock.Unlock()
+	encoders[format] = encoder
+}
+
+// NewEncoder returns the encoder registered for the format
+func NewEncoder(format string) (Encoder, error) {
+	encodersLock.RLock()
+	defer encodersLock.RUnlock()
+	if enc := encoders[format]; enc != nil {
+		return enc, nil
+	}
+	return nil, errors.Errorf("unsupported output format %q, expected one of %s", format, strings.Join(formats(), "|"))
+}
+
+// Formats returns the names of the registered formats
+func Formats() []string {
+	encodersLock.RLock()
+	defer encodersLock.RUnlock()
+	return formats()
+}
+
+func formats() (names []string) {
+	for name := range encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// Encode writes the resources to writer in the provided format
+func (l K8sResourceList) Encode(writer io.Writer, format string) error {
+	enc, err := NewEncoder(format)
+	if err != nil {
+		return err
+	}
+	return enc.Encode(writer, l)
+}
+
+func encodeYaml(writer io.Writer, resources K8sResourceList) error {
+	return resources.WriteYaml(writer)
+}
+
+// encodeJson writes a stream of indented JSON documents
+func encodeJson(writer io.Writer, resources K8sResourceList) error {
+	enc := json.NewEncoder(writer)
+	enc.SetIndent("", "  ")
+	for _, o := range resources {
+		if err := enc.Encode(o.raw); err != nil {
+			return errors.Wrapf(err, "encode resource %s to json", o.ID())
+		}
+	}
+	return nil
+}
+
+// encodeJsonList writes a single JSON document of kind List
+func encodeJsonList(writer io.Writer, resources K8sResourceList) error {
+	items := make([]interface{}, len(resources))
+	for i, o := range resources {
+		items[i] = o.raw
+	}
+	enc := json.NewEncoder(writer)
+	enc.SetIndent("", "  ")
+	list := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items":      items,
+	}
+	return errors.Wrap(enc.Encode(list), "encode resource list to json")
+}
+
+// encodeNames writes the QualifiedKind/Name of each resource per line
+func encodeNames(writer io.Writer, resources K8sResourceList) (err error) {
+	for _, o := range resources {
+		if _, err = fmt.Fprintf(writer, "%s/%s\n", o.QualifiedKind(), o.Name()); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// TableColumn specifies a table column that shows the value of a label
+type TableColumn struct {
+	Header string
+	Label  string
+}
+
+type tableEncoder struct {
+	labels []TableColumn
+}
+
+// NewTableEncoder returns an encoder that writes a table listing the kind,
+// namespace and name of each resource followed by the provided label columns.
+func NewTableEncoder(labels ...TableColumn) Encoder {
+	return &tableEncoder{labels}
+}
+
+func (e *tableEncoder) Encode(writer io.Writer, resources K8sResourceList) (err error) {
+	w := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	headers := []string{"KIND", "NAMESPACE", "NAME"}
+	for _, c := range e.labels {
+		headers = append(headers, c.Header)
+	}
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+	for _, o := range resources {
+		row := []string{o.QualifiedKind(), o.Namespace(), o.Name()}
+		labels := o.Labels()
+		for _, c := range e.labels {
+			row = append(row, labels[c.Label])
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
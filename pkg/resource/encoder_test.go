@@ -0,0 +1,63 @@
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const encoderTestManifest = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: mydeployment
+  namespace: myns
+  labels:
+    app.kubernetes.io/part-of: mypkg
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: myns
+`
+
+func TestEncode(t *testing.T) {
+	l, err := FromReader(bytes.NewReader([]byte(encoderTestManifest)))
+	require.NoError(t, err)
+	encode := func(format string) string {
+		var buf bytes.Buffer
+		require.NoError(t, l.Encode(&buf, format), format)
+		return buf.String()
+	}
+
+	decoded, err := FromReader(bytes.NewReader([]byte(encode("yaml"))))
+	require.NoError(t, err, "decode yaml")
+	require.Equal(t, l.Refs().Names(), decoded.Refs().Names(), "yaml")
+
+	decoded, err = FromReader(bytes.NewReader([]byte(encode("json"))))
+	require.NoError(t, err, "decode json")
+	require.Equal(t, l.Refs().Names(), decoded.Refs().Names(), "json")
+
+	list := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(encode("jsonlist")), &list), "decode jsonlist")
+	require.Equal(t, "List", list["kind"], "jsonlist kind")
+	require.Equal(t, 2, len(list["items"].([]interface{})), "jsonlist items")
+	decoded, err = FromReader(bytes.NewReader([]byte(encode("jsonlist"))))
+	require.NoError(t, err, "decode jsonlist")
+	require.Equal(t, l.Refs().Names(), decoded.Refs().Names(), "jsonlist")
+
+	require.Equal(t, "deployment.apps/mydeployment\nnamespace/myns\n", encode("name"), "name")
+
+	var buf bytes.Buffer
+	err = NewTableEncoder(TableColumn{Header: "PACKAGE", Label: "app.kubernetes.io/part-of"}).Encode(&buf, l)
+	require.NoError(t, err)
+	expected := "KIND             NAMESPACE  NAME          PACKAGE\n" +
+		"deployment.apps  myns       mydeployment  mypkg\n" +
+		"namespace                   myns          \n"
+	require.Equal(t, expected, buf.String(), "table")
+
+	require.Error(t, l.Encode(&buf, "unsupported"), "unsupported format")
+	require.Equal(t, []string{"json", "jsonlist", "name", "table", "yaml"}, Formats(), "formats")
+}
@@ -0,0 +1,32 @@
+package resource
+
+import (
+	"strings"
+)
+
+// legacyGroupKinds maps the qualified kinds of legacy API groups to the
+// qualified kind of the group the API server serves the same objects in.
+// It lists all kinds the builtin Kubernetes API serves within several groups.
+// The table is used whenever no cluster is involved (e.g. to detect duplicates
+// within a source) and as fallback for kinds the cluster doesn't serve.
+// Operations that compare a package with a cluster identify the kinds the
+// cluster serves within several API groups using its discovery API instead.
+var legacyGroupKinds = map[string]string{
+	"daemonset.extensions":         "daemonset.apps",
+	"deployment.extensions":        "deployment.apps",
+	"replicaset.extensions":        "replicaset.apps",
+	"ingress.extensions":           "ingress.networking.k8s.io",
+	"networkpolicy.extensions":     "networkpolicy.networking.k8s.io",
+	"podsecuritypolicy.extensions": "podsecuritypolicy.policy",
+	"event.events.k8s.io":          "event",
+}
+
+// CanonicalGroupKind returns the identity of the provided qualified kind
+// (e.g. "deployment.apps" for "Deployment.extensions").
+func CanonicalGroupKind(qualifiedKind string) string {
+	kind := strings.ToLower(qualifiedKind)
+	if canonical, ok := legacyGroupKinds[kind]; ok {
+		return canonical
+	}
+	return kind
+}
@@ -0,0 +1,36 @@
+package resource
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// TestLegacyGroupKinds verifies that the static group-kind table covers all
+// kinds the builtin Kubernetes API serves within several groups.
+func TestLegacyGroupKinds(t *testing.T) {
+	groupKinds := map[string]map[string]bool{}
+	for gvk := range scheme.Scheme.AllKnownTypes() {
+		if gvk.Version == runtime.APIVersionInternal || strings.HasSuffix(gvk.Kind, "List") || strings.HasSuffix(gvk.Kind, "Options") {
+			continue
+		}
+		switch gvk.Kind {
+		case "WatchEvent", "Scale", "DeploymentRollback":
+			continue // not a resource
+		}
+		if groupKinds[gvk.Kind] == nil {
+			groupKinds[gvk.Kind] = map[string]bool{}
+		}
+		groupKinds[gvk.Kind][ResourceRef(gvk.GroupVersion().String(), gvk.Kind, "", "").GroupKind()] = true
+	}
+	for kind, gks := range groupKinds {
+		require.Equal(t, 1, len(gks), "group-kinds of %s: %v", kind, gks)
+	}
+	for legacy, canonical := range legacyGroupKinds {
+		require.NotEqual(t, legacy, canonical)
+		require.Equal(t, canonical, CanonicalGroupKind(canonical), "canonical group-kind of %s should not be an alias", legacy)
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
@@ -16,6 +17,10 @@ type K8sResource struct {
 	K8sResourceRef
 	raw        rawK8sResource
 	conditions []*K8sResourceCondition
+	// node is the YAML document the resource has been read from
+	node *yaml3.Node
+	// source describes the input document the resource has been read from
+	source string
 }
 
 func Resource(name K8sResourceRef, attrs map[string]interface{}) *K8sResource {
@@ -25,7 +30,7 @@ func Resource(name K8sResourceRef, attrs map[string]interface{}) *K8sResource {
 	meta["name"] = name.Name()
 	meta["namespace"] = name.Namespace()
 	attrs["metadata"] = meta
-	return &K8sResource{name, attrs, nil}
+	return &K8sResource{name, attrs, nil, nil, ""}
 }
 
 type ResourceEvent struct {
@@ -43,7 +48,7 @@ func FromJsonStream(reader io.Reader) <-chan ResourceEvent {
 		for ; err == nil; err = dec.Decode(&o) {
 			if len(o) > 0 {
 				l = l[:0]
-				if err = appendFlattened(o, &l); err != nil {
+				if err = appendFlattened(o, nil, "", &l); err != nil {
 					break
 				}
 				for _, lo := range l {
@@ -68,7 +73,7 @@ type K8sResourceCondition struct {
 }
 
 func FromMap(o map[string]interface{}) *K8sResource {
-	obj := unstructured.Unstructured{o}
+	obj := unstructured.Unstructured{Object: o}
 	rawConditions, _, _ := unstructured.NestedSlice(o, "status", "conditions")
 	conditions := make([]*K8sResourceCondition, 0, len(rawConditions))
 	for _, entry := range rawConditions {
@@ -102,7 +107,7 @@ func FromMap(o map[string]interface{}) *K8sResource {
 
 func (o *K8sResource) Validate() (err error) {
 	if o.APIVersion() == "" || o.Kind() == "" || o.Name() == "" {
-		err = errors.Errorf("invalid resource: apiVersion, kind or name are not set: %+v", o.raw)
+		err = errors.Errorf("invalid resource: apiVersion, kind or name are not set: %+v", Redact(o.raw))
 	}
 	return
 }
@@ -111,6 +116,12 @@ func (o *K8sResource) Raw() (r map[string]interface{}) {
 	return o.raw
 }
 
+// Source returns the file and document the resource has been read from
+// or the document's position within the input stream if the file is unknown.
+func (o *K8sResource) Source() string {
+	return o.source
+}
+
 func (o *K8sResource) Labels() (l map[string]string) {
 	l, _, _ = unstructured.NestedStringMap(o.raw, "metadata", "labels")
 	return
@@ -120,9 +131,16 @@ func (o *K8sResource) Conditions() []*K8sResourceCondition {
 	return o.conditions
 }
 
+// WriteYaml writes the resource as YAML document.
+// If the resource has been read from YAML the original key order and
+// comments are preserved while changes to the resource are applied.
 func (o *K8sResource) WriteYaml(writer io.Writer) (err error) {
 	if _, err = writer.Write([]byte("---\n")); err == nil {
-		err = yaml.NewEncoder(writer).Encode(o.raw)
+		if o.node != nil {
+			err = encodeYamlNode(writer, o.node, o.raw)
+		} else {
+			err = yaml.NewEncoder(writer).Encode(o.raw)
+		}
 	}
 	return errors.Wrapf(err, "encode resource %s to yaml", o.ID())
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 func TestK8sResource(t *testing.T) {
@@ -79,3 +80,125 @@ func TestFromJsonStream(t *testing.T) {
 	}
 	require.Equal(t, expectedNames, actualNames, "names")
 }
+
+func TestK8sResourceWriteYamlPreservesFormatting(t *testing.T) {
+	manifest := `# my deployment
+kind: Deployment
+apiVersion: apps/v1
+metadata:
+  name: mydeployment # the name
+  annotations:
+    note: "quoted"
+spec:
+  # scaled manually
+  replicas: 2
+  template:
+    spec:
+      containers:
+        - name: main
+          image: alpine:3.10
+          args: ['sleep', '60']
+---
+kind: ConfigMap
+apiVersion: v1
+metadata:
+  name: myconfig
+  namespace: oldns
+data:
+  removed: value
+  kept: value
+`
+	l, err := FromReader(bytes.NewReader([]byte(manifest)))
+	require.NoError(t, err)
+	require.NoError(t, unstructured.SetNestedField(l[0].Raw(), "mypkg", "metadata", "labels", "app.kubernetes.io/part-of"))
+	require.NoError(t, unstructured.SetNestedField(l[0].Raw(), int64(3), "spec", "replicas"))
+	require.NoError(t, unstructured.SetNestedField(l[1].Raw(), "myns", "metadata", "namespace"))
+	unstructured.RemoveNestedField(l[1].Raw(), "data", "removed")
+	var buf bytes.Buffer
+	require.NoError(t, l.WriteYaml(&buf))
+	expected := `---
+# my deployment
+kind: Deployment
+apiVersion: apps/v1
+metadata:
+  name: mydeployment # the name
+  annotations:
+    note: "quoted"
+  labels:
+    app.kubernetes.io/part-of: mypkg
+spec:
+  # scaled manually
+  replicas: 3
+  template:
+    spec:
+      containers:
+        - name: main
+          image: alpine:3.10
+          args: ['sleep', '60']
+---
+kind: ConfigMap
+apiVersion: v1
+metadata:
+  name: myconfig
+  namespace: myns
+data:
+  kept: value
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestK8sResourceWriteYamlPreservesCompactSequences(t *testing.T) {
+	manifest := `kind: Deployment
+apiVersion: apps/v1
+metadata:
+  name: mydeployment
+spec:
+  template:
+    spec:
+      containers:
+      # main container
+      - name: main
+        image: alpine:3.10
+        args:
+        - sh
+        - -c
+        - |
+          env:
+            - not a sequence
+        env:
+        - name: A
+          value: a
+      volumes: []
+`
+	l, err := FromReader(bytes.NewReader([]byte(manifest)))
+	require.NoError(t, err)
+	require.NoError(t, unstructured.SetNestedField(l[0].Raw(), "mypkg", "metadata", "labels", "app.kubernetes.io/part-of"))
+	var buf bytes.Buffer
+	require.NoError(t, l.WriteYaml(&buf))
+	expected := `---
+kind: Deployment
+apiVersion: apps/v1
+metadata:
+  name: mydeployment
+  labels:
+    app.kubernetes.io/part-of: mypkg
+spec:
+  template:
+    spec:
+      containers:
+      # main container
+      - name: main
+        image: alpine:3.10
+        args:
+        - sh
+        - -c
+        - |
+          env:
+            - not a sequence
+        env:
+        - name: A
+          value: a
+      volumes: []
+`
+	assert.Equal(t, expected, buf.String())
+}
@@ -1,8 +1,12 @@
 package resource
 
 import (
+	"bufio"
+	"bytes"
 	"io"
+	"io/ioutil"
 
+	yaml3 "gopkg.in/yaml.v3"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/yaml"
@@ -10,22 +14,61 @@ import (
 
 type K8sResourceList []*K8sResource
 
+// FromReader reads the resources from a YAML or JSON stream.
+// Each resource records the source document it has been read from.
 func FromReader(reader io.Reader) (l K8sResourceList, err error) {
-	obj := []*K8sResource{}
-	o := map[string]interface{}{}
-	dec := yaml.NewYAMLOrJSONDecoder(reader, 1024)
-	for ; err == nil; err = dec.Decode(&o) {
-		if len(o) > 0 {
-			if err = appendFlattened(o, &obj); err != nil {
-				return
+	manifest, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return
+	}
+	var (
+		docs    []map[string]interface{}
+		sources []string
+		file    string
+		n       int
+	)
+	chunks := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(manifest)))
+	for {
+		chunk, err := chunks.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if f := sourceFile(chunk); f != "" {
+			file, n = f, 0
+		}
+		o := map[string]interface{}{}
+		dec := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(chunk), 1024)
+		for err = dec.Decode(&o); err == nil; err = dec.Decode(&o) {
+			if len(o) > 0 {
+				n++
+				docs = append(docs, o)
+				sources = append(sources, documentSource(file, n))
+				o = map[string]interface{}{}
 			}
-			o = map[string]interface{}{}
+		}
+		if err != io.EOF {
+			return nil, err
 		}
 	}
-	if err == io.EOF {
-		err = nil
+	nodes := yamlDocuments(stripSourceComments(manifest))
+	if len(nodes) != len(docs) {
+		// cannot preserve the original formatting
+		nodes = nil
 	}
-	return obj, err
+	obj := []*K8sResource{}
+	for i, doc := range docs {
+		var node *yaml3.Node
+		if nodes != nil {
+			node = nodes[i]
+		}
+		if err = appendFlattened(doc, node, sources[i], &obj); err != nil {
+			return
+		}
+	}
+	return obj, nil
 }
 
 func (l K8sResourceList) Refs() K8sResourceRefList {
@@ -36,6 +79,14 @@ func (l K8sResourceList) Refs() K8sResourceRefList {
 	return r
 }
 
+// Filter returns the resources the filter function accepts
+func (l K8sResourceList) Filter(filter func(K8sResourceRef) bool) (filtered K8sResourceList) {
+	for _, ref := range l.Refs().Filter(filter) {
+		filtered = append(filtered, ref.(*K8sResource))
+	}
+	return
+}
+
 func (l K8sResourceList) WriteYaml(writer io.Writer) (err error) {
 	for _, o := range l {
 		if err = o.WriteYaml(writer); err != nil {
@@ -53,14 +104,28 @@ func (l K8sResourceList) YamlReader() io.ReadCloser {
 	return reader
 }
 
-func appendFlattened(o rawK8sResource, flattened *[]*K8sResource) (err error) {
-	obj := unstructured.Unstructured{o}
+// appendFlattened appends the object or the items if it is a list.
+// The YAML document node is optional.
+func appendFlattened(o rawK8sResource, node *yaml3.Node, source string, flattened *[]*K8sResource) (err error) {
+	obj := unstructured.Unstructured{Object: o}
 	if obj.IsList() {
+		var itemNodes []*yaml3.Node
+		if items := mappingValue(documentContent(node), "items"); items != nil && items.Kind == yaml3.SequenceNode {
+			itemNodes = items.Content
+		}
+		i := 0
 		return obj.EachListItem(func(o runtime.Object) error {
-			return appendFlattened(o.(*unstructured.Unstructured).Object, flattened)
+			var itemNode *yaml3.Node
+			if i < len(itemNodes) {
+				itemNode = &yaml3.Node{Kind: yaml3.DocumentNode, Content: []*yaml3.Node{itemNodes[i]}}
+			}
+			i++
+			return appendFlattened(o.(*unstructured.Unstructured).Object, itemNode, source, flattened)
 		})
 	}
 	entry := FromMap(o)
+	entry.node = node
+	entry.source = source
 	err = entry.Validate()
 	*flattened = append(*flattened, entry)
 	return
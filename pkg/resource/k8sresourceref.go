@@ -11,6 +11,9 @@ type K8sResourceRef interface {
 	Name() string
 	Namespace() string
 	QualifiedKind() string
+	// GroupKind returns the version-independent identity of the kind
+	GroupKind() string
+	// ID returns the resource's version-independent identity
 	ID() string
 }
 
@@ -43,7 +46,7 @@ func (o *k8sResourceRef) Namespace() string {
 	return o.namespace
 }
 func (o *k8sResourceRef) ID() string {
-	return fmt.Sprintf("%s:%s:%s", o.QualifiedKind(), o.namespace, o.name)
+	return fmt.Sprintf("%s:%s:%s", o.GroupKind(), o.namespace, o.name)
 }
 func (o *k8sResourceRef) String() string {
 	return o.ID()
@@ -60,6 +63,13 @@ func (o *k8sResourceRef) QualifiedKind() (kind string) {
 	return
 }
 
+// GroupKind returns the qualified kind of the API group the kind is maintained in.
+// Kinds of legacy API groups map to the group they have been moved to
+// since the API server serves the same objects within both groups.
+func (o *k8sResourceRef) GroupKind() string {
+	return CanonicalGroupKind(o.QualifiedKind())
+}
+
 type K8sResourceRefList []K8sResourceRef
 
 type K8sResourceGroup struct {
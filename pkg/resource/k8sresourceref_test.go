@@ -66,3 +66,24 @@ func TestFilter(t *testing.T) {
 	require.Equal(t, expected, filtered)
 	require.Equal(t, 0, len(K8sResourceRefList(nil).Filter(filter)), "on nil list")
 }
+
+func TestGroupKind(t *testing.T) {
+	for _, c := range []struct {
+		ref      K8sResourceRef
+		expected string
+	}{
+		{ResourceRef("v1", "Pod", "", "mypod"), "pod"},
+		{ResourceRef("apps/v1", "Deployment", "", "mydeployment"), "deployment.apps"},
+		{ResourceRef("apps/v1beta2", "Deployment", "", "mydeployment"), "deployment.apps"},
+		{ResourceRef("extensions/v1beta1", "Deployment", "", "mydeployment"), "deployment.apps"},
+		{ResourceRef("extensions/v1beta1", "Ingress", "", "myingress"), "ingress.networking.k8s.io"},
+		{ResourceRef("events.k8s.io/v1beta1", "Event", "", "myevent"), "event"},
+		{ResourceRef("example.org/v1alpha1", "Thing", "", "mything"), "thing.example.org"},
+	} {
+		require.Equal(t, c.expected, c.ref.GroupKind(), c.ref.APIVersion()+" "+c.ref.Kind())
+	}
+	legacy := ResourceRef("extensions/v1beta1", "Deployment", "myns", "mydeployment")
+	current := ResourceRef("apps/v1", "Deployment", "myns", "mydeployment")
+	require.Equal(t, current.ID(), legacy.ID(), "ID")
+	require.Equal(t, "deployment.extensions", legacy.QualifiedKind(), "qualified kind")
+}
@@ -2,19 +2,29 @@ package resource
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strings"
 
 	"github.com/hashicorp/go-getter"
 	urlhelper "github.com/hashicorp/go-getter/helper/url"
 	"github.com/pkg/errors"
 )
 
+// sourceCommentPrefix marks the beginning of a file within a manifest stream
+const sourceCommentPrefix = "# k8spkg-source: "
+
+var sourceCommentRegex = regexp.MustCompile("(?m)^" + sourceCommentPrefix + ".*$\n?")
+
 // Provides a reader for the given manifest dir or URL.
+// Each file is preceded by a comment that names it in order
+// to tell the source of a resource when reading the stream.
 func ManifestReader(ctx context.Context, src, baseDir string) (reader io.ReadCloser) {
 	reader, writer := io.Pipe()
 	go func() {
@@ -61,23 +71,23 @@ func copySourceFiles(ctx context.Context, src, baseDir string, writer io.Writer)
 			return
 		}
 	}
-	err = copyFiles(ctx, file, writer)
+	err = copyFiles(ctx, file, src, writer)
 	return errors.Wrapf(err, "source %s", src)
 }
 
-func copyFiles(ctx context.Context, file string, writer io.Writer) (err error) {
+func copyFiles(ctx context.Context, file, name string, writer io.Writer) (err error) {
 	si, err := os.Stat(file)
 	if err == nil {
 		if si.IsDir() {
-			err = copyManifestDir(ctx, file, writer)
+			err = copyManifestDir(ctx, file, name, writer)
 		} else {
-			err = copyManifestFile(file, writer)
+			err = copyManifestFile(file, name, writer)
 		}
 	}
 	return
 }
 
-func copyManifestDir(ctx context.Context, dir string, writer io.Writer) (err error) {
+func copyManifestDir(ctx context.Context, dir, name string, writer io.Writer) (err error) {
 	var files []string
 	extensions := []string{".yaml", ".yml", ".json"}
 	for _, fext := range extensions {
@@ -93,7 +103,7 @@ func copyManifestDir(ctx context.Context, dir string, writer io.Writer) (err err
 	sort.Strings(files)
 	for _, file := range files {
 		writer.Write([]byte("\n---\n"))
-		if err = copyManifestFile(file, writer); err != nil {
+		if err = copyManifestFile(file, strings.TrimSuffix(name, "/")+"/"+filepath.Base(file), writer); err != nil {
 			return
 		}
 		select {
@@ -105,11 +115,34 @@ func copyManifestDir(ctx context.Context, dir string, writer io.Writer) (err err
 	return nil
 }
 
-func copyManifestFile(file string, writer io.Writer) (err error) {
+func copyManifestFile(file, name string, writer io.Writer) (err error) {
 	f, err := os.Open(file)
 	if err == nil {
 		defer f.Close()
-		_, err = io.Copy(writer, f)
+		if _, err = writer.Write([]byte(sourceCommentPrefix + name + "\n")); err == nil {
+			_, err = io.Copy(writer, f)
+		}
 	}
 	return
 }
+
+// sourceFile returns the file the source comment within the YAML document names
+func sourceFile(doc []byte) string {
+	if m := sourceCommentRegex.Find(doc); m != nil {
+		return strings.TrimSpace(strings.TrimPrefix(string(m), sourceCommentPrefix))
+	}
+	return ""
+}
+
+// stripSourceComments removes the source comments from the manifest
+func stripSourceComments(manifest []byte) []byte {
+	return sourceCommentRegex.ReplaceAll(manifest, nil)
+}
+
+// documentSource describes the n-th document of the file or stream
+func documentSource(file string, n int) string {
+	if file == "" {
+		return fmt.Sprintf("document %d", n)
+	}
+	return fmt.Sprintf("%s (document %d)", file, n)
+}
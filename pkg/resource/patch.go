@@ -0,0 +1,199 @@
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// PatchType specifies how a patch modifies a resource
+type PatchType string
+
+const (
+	// PatchJSON is a JSON patch (RFC 6902)
+	PatchJSON PatchType = "json"
+	// PatchMerge is a JSON merge patch (RFC 7386)
+	PatchMerge PatchType = "merge"
+	// PatchStrategicMerge is a strategic merge patch (a JSON merge patch for unknown kinds)
+	PatchStrategicMerge PatchType = "strategic"
+)
+
+// ParsePatchType parses the type object patches are applied with.
+// JSON patches are lists and are therefore not selected by the type.
+func ParsePatchType(s string) (PatchType, error) {
+	switch t := PatchType(s); t {
+	case PatchStrategicMerge, PatchMerge:
+		return t, nil
+	}
+	return PatchStrategicMerge, errors.Errorf("unsupported patch type %q provided, expected %s or %s", s, PatchStrategicMerge, PatchMerge)
+}
+
+// Patch modifies the resources its target selects
+type Patch struct {
+	Target *Selector
+	Type   PatchType
+	Source string
+	ops    jsonpatch.Patch
+	fields map[string]interface{}
+}
+
+// ParsePatchFile parses a --patch option value of the form [SELECTOR:]FILE.
+// The file may contain several YAML or JSON documents.
+// A document that is a list is a JSON patch and any other document an
+// object patch of the provided type (PatchStrategicMerge or PatchMerge).
+// An object patch that specifies a kind and has no selector targets
+// the resource it names, all other patches require a selector.
+func ParsePatchFile(s string, objType PatchType) (patches []*Patch, err error) {
+	var target *Selector
+	file := s
+	if i := strings.Index(s, ":"); i > 0 && strings.Contains(s[:i], "=") {
+		if target, err = ParseSelector(s[:i]); err != nil {
+			return nil, errors.Wrap(err, "patch target")
+		}
+		file = s[i+1:]
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "read patch")
+	}
+	dec := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(b), 1024)
+	for n := 1; ; n++ {
+		var doc interface{}
+		if err = dec.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "read patch %s", file)
+		}
+		if doc == nil {
+			continue
+		}
+		p, err := newPatch(target, doc, objType, documentSource(file, n))
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, p)
+	}
+	if len(patches) == 0 {
+		return nil, errors.Errorf("no patch found in %s", file)
+	}
+	return patches, nil
+}
+
+// ParsePatchJSON parses a --patch-json option value of the form SELECTOR:PATCH
+// where PATCH is a JSON patch list or an object patch of the provided type.
+func ParsePatchJSON(s string, objType PatchType) (p *Patch, err error) {
+	kv := strings.SplitN(s, ":", 2)
+	if len(kv) != 2 || strings.TrimSpace(kv[1]) == "" {
+		return nil, errors.Errorf("invalid patch %q: expected SELECTOR:PATCH", s)
+	}
+	target, err := ParseSelector(kv[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "patch target")
+	}
+	var doc interface{}
+	if err = json.Unmarshal([]byte(kv[1]), &doc); err != nil {
+		return nil, errors.Wrapf(err, "invalid patch %q", s)
+	}
+	return newPatch(target, doc, objType, fmt.Sprintf("%q", s))
+}
+
+func newPatch(target *Selector, doc interface{}, objType PatchType, source string) (p *Patch, err error) {
+	p = &Patch{Target: target, Source: source}
+	switch d := doc.(type) {
+	case []interface{}:
+		b, err := json.Marshal(d)
+		if err != nil {
+			return nil, err
+		}
+		if p.ops, err = jsonpatch.DecodePatch(b); err != nil {
+			return nil, errors.Wrapf(err, "invalid JSON patch %s", source)
+		}
+		p.Type = PatchJSON
+	case map[string]interface{}:
+		p.Type = objType
+		if _, ok := d["kind"]; !ok {
+			p.fields = d
+			break
+		}
+		res := FromMap(d)
+		if p.Target == nil {
+			if err = res.Validate(); err != nil {
+				return nil, errors.Wrapf(err, "patch %s", source)
+			}
+			p.Target = &Selector{Kind: res.QualifiedKind(), Namespace: res.Namespace(), Name: res.Name()}
+		}
+		// the target's identity must not be changed by the patch
+		p.fields = copyMap(d)
+		delete(p.fields, "apiVersion")
+		delete(p.fields, "kind")
+		if meta, ok := p.fields["metadata"].(map[string]interface{}); ok {
+			meta = copyMap(meta)
+			delete(meta, "name")
+			delete(meta, "namespace")
+			p.fields["metadata"] = meta
+		}
+	default:
+		return nil, errors.Errorf("patch %s is neither a list nor an object", source)
+	}
+	if p.Target == nil {
+		return nil, errors.Errorf("%s patch %s requires a target selector", p.Type, source)
+	}
+	return
+}
+
+// Apply returns a copy of the resource with the patch applied.
+// The result keeps the resource's formatting and source.
+func (p *Patch) Apply(res *K8sResource) (patched *K8sResource, err error) {
+	var raw map[string]interface{}
+	switch p.Type {
+	case PatchJSON:
+		var doc []byte
+		if doc, err = json.Marshal(res.raw); err != nil {
+			return
+		}
+		if doc, err = p.ops.Apply(doc); err != nil {
+			return
+		}
+		err = json.Unmarshal(doc, &raw)
+	case PatchMerge:
+		raw = mergePatch(res.raw, p.fields)
+	default:
+		raw, err = strategicMerge(res, p.fields)
+	}
+	if err != nil {
+		return
+	}
+	patched = FromMap(raw)
+	patched.node = res.node
+	patched.source = res.source
+	return patched, patched.Validate()
+}
+
+// Patch returns a copy of the list with the patches applied in order
+// to the resources their targets select.
+// A patch that doesn't select any resource is rejected.
+func (l K8sResourceList) Patch(patches []*Patch) (r K8sResourceList, err error) {
+	r = append(K8sResourceList(nil), l...)
+	for _, p := range patches {
+		matched := false
+		for i, res := range r {
+			if p.Target.Match(res) {
+				matched = true
+				if r[i], err = p.Apply(res); err != nil {
+					return nil, errors.Wrapf(err, "apply patch %s to %s", p.Source, displayName(res))
+				}
+			}
+		}
+		if !matched {
+			return nil, errors.Errorf("patch %s does not match any resource", p.Source)
+		}
+	}
+	return
+}
@@ -0,0 +1,126 @@
+package resource
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestPatch(t *testing.T) {
+	l, err := FromReader(bytes.NewReader([]byte(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:1.0
+        args: ["--debug"]
+---
+apiVersion: example.org/v1
+kind: Thing
+metadata:
+  name: mything
+spec:
+  size: 1
+  colour: red
+`)))
+	require.NoError(t, err)
+	f, err := ioutil.TempFile("", "k8spkg-test-patch-")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:2.0
+---
+apiVersion: example.org/v1
+kind: Thing
+metadata:
+  name: mything
+spec:
+  colour: null
+`)
+	f.Close()
+	require.NoError(t, err)
+	patches, err := ParsePatchFile(f.Name(), PatchStrategicMerge)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(patches), "patches in file")
+	require.Equal(t, PatchStrategicMerge, patches[0].Type)
+	jsonPatch, err := ParsePatchJSON(`kind=Deployment:[{"op":"replace","path":"/spec/replicas","value":3}]`, PatchStrategicMerge)
+	require.NoError(t, err)
+	require.Equal(t, PatchJSON, jsonPatch.Type)
+	objPatch, err := ParsePatchJSON(`kind=deployment.apps,name=api:{"spec":{"template":{"spec":{"containers":[{"name":"app","image":"app:3.0"},{"name":"other","image":"other:1.0"}]}}}}`, PatchStrategicMerge)
+	require.NoError(t, err)
+	require.Equal(t, PatchStrategicMerge, objPatch.Type, "selector-targeted object patch type")
+	mergePatch, err := ParsePatchJSON(`kind=Deployment:{"spec":{"template":{"spec":{"containers":[{"name":"other","image":"other:1.0"}]}}}}`, PatchMerge)
+	require.NoError(t, err)
+	require.Equal(t, PatchMerge, mergePatch.Type, "merge patch type")
+	mergePatches, err := ParsePatchFile(f.Name(), PatchMerge)
+	require.NoError(t, err)
+	require.Equal(t, PatchMerge, mergePatches[0].Type, "merge patch type within file")
+
+	containers := func(res *K8sResource) []interface{} {
+		l, _, _ := unstructured.NestedSlice(res.Raw(), "spec", "template", "spec", "containers")
+		return l
+	}
+	r, err := l.Patch(append(patches, jsonPatch))
+	require.NoError(t, err)
+	require.Equal(t, []string{"deployment.apps/api", "thing.example.org/mything"}, r.Refs().Names())
+	require.Equal(t, "app:2.0", containers(r[0])[0].(map[string]interface{})["image"], "strategic merge patch image")
+	require.Equal(t, []interface{}{"--debug"}, containers(r[0])[0].(map[string]interface{})["args"], "strategic merge patch should keep args")
+	replicas, _, _ := unstructured.NestedFieldNoCopy(r[0].Raw(), "spec", "replicas")
+	require.Equal(t, float64(3), replicas, "JSON patch replicas")
+	require.Equal(t, map[string]interface{}{"size": float64(1)}, r[1].Raw()["spec"], "custom resource merge patch")
+	require.Equal(t, 1, len(containers(l[0])), "original should not be modified")
+	require.Equal(t, "app:1.0", containers(l[0])[0].(map[string]interface{})["image"], "original should not be modified")
+
+	r, err = l.Patch([]*Patch{objPatch})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(containers(r[0])), "selector-targeted object patch should merge containers by name")
+	require.Equal(t, "app:3.0", containers(r[0])[0].(map[string]interface{})["image"], "patched container image")
+	require.Equal(t, []interface{}{"--debug"}, containers(r[0])[0].(map[string]interface{})["args"], "patched container should keep args")
+
+	r, err = l.Patch([]*Patch{mergePatch})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(containers(r[0])), "merge patch should replace containers")
+	require.Equal(t, "other", containers(r[0])[0].(map[string]interface{})["name"], "merge patch container")
+
+	r, err = l.Patch(mergePatches)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(containers(r[0])), "merge patch within file should replace containers")
+	require.Nil(t, containers(r[0])[0].(map[string]interface{})["args"], "merge patch within file should replace container")
+	require.Equal(t, map[string]interface{}{"size": float64(1)}, r[1].Raw()["spec"], "custom resource merge patch within file")
+
+	for _, valid := range []string{"strategic", "merge"} {
+		typ, err := ParsePatchType(valid)
+		require.NoError(t, err, valid)
+		require.Equal(t, PatchType(valid), typ)
+	}
+	for _, invalid := range []string{"", "json", "unknown"} {
+		_, err = ParsePatchType(invalid)
+		require.Error(t, err, invalid)
+	}
+
+	_, err = l.Patch([]*Patch{{Target: &Selector{Kind: "Service"}, Type: PatchMerge}})
+	require.Error(t, err, "patch without matching resource")
+	for _, invalid := range []string{"", "kind=Deployment", "kind=Deployment:", "kind:[]", `kind=Deployment:"str"`, `kind=Deployment:[{"op":"invalid"`} {
+		_, err = ParsePatchJSON(invalid, PatchStrategicMerge)
+		require.Error(t, err, invalid)
+	}
+}
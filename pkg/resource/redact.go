@@ -0,0 +1,72 @@
+package resource
+
+const (
+	// RedactedValue replaces the values of Secrets in output
+	RedactedValue = "REDACTED"
+	// lastAppliedAnnotation contains the whole object including a Secret's values
+	lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+)
+
+// IsSecret returns true if the resource is a Secret
+func (o *K8sResource) IsSecret() bool {
+	return isSecret(o.raw)
+}
+
+func isSecret(obj map[string]interface{}) bool {
+	return obj["kind"] == "Secret" && obj["apiVersion"] == "v1"
+}
+
+// Redacted returns a copy of the resource with masked values if it is a Secret.
+// Other resources are returned as they are.
+func (o *K8sResource) Redacted() *K8sResource {
+	if !o.IsSecret() {
+		return o
+	}
+	return &K8sResource{o.K8sResourceRef, Redact(o.raw), o.conditions, o.node, o.source}
+}
+
+// Redacted returns the list with masked Secret values
+func (l K8sResourceList) Redacted() K8sResourceList {
+	r := make(K8sResourceList, len(l))
+	for i, o := range l {
+		r[i] = o.Redacted()
+	}
+	return r
+}
+
+// Redact returns a copy of the raw object with masked values if it is a Secret.
+// Other objects are returned as they are.
+// The keys are kept since they are useful when debugging.
+func Redact(obj map[string]interface{}) map[string]interface{} {
+	if !isSecret(obj) {
+		return obj
+	}
+	r := copyMap(obj)
+	for _, field := range []string{"data", "stringData"} {
+		if m, ok := r[field].(map[string]interface{}); ok {
+			masked := make(map[string]interface{}, len(m))
+			for k := range m {
+				masked[k] = RedactedValue
+			}
+			r[field] = masked
+		}
+	}
+	if meta, ok := r["metadata"].(map[string]interface{}); ok {
+		if annotations, ok := meta["annotations"].(map[string]interface{}); ok && annotations[lastAppliedAnnotation] != nil {
+			meta = copyMap(meta)
+			annotations = copyMap(annotations)
+			annotations[lastAppliedAnnotation] = RedactedValue
+			meta["annotations"] = annotations
+			r["metadata"] = meta
+		}
+	}
+	return r
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,47 @@
+package resource
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedacted(t *testing.T) {
+	l, err := FromReader(bytes.NewReader([]byte(`
+apiVersion: v1
+kind: Secret
+metadata:
+  name: mysecret
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: '{"data":{"password":"cDRzc3cwcmQ="}}'
+data:
+  password: cDRzc3cwcmQ=
+stringData:
+  token: mytoken
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: myconfig
+data:
+  key: value
+`)))
+	require.NoError(t, err)
+	redacted := l.Redacted()
+	var buf bytes.Buffer
+	require.NoError(t, redacted.WriteYaml(&buf))
+	out := buf.String()
+	for _, value := range []string{"cDRzc3cwcmQ=", "mytoken"} {
+		require.NotContains(t, out, value, "redacted output")
+	}
+	require.Contains(t, out, "password: "+RedactedValue)
+	require.Contains(t, out, "token: "+RedactedValue)
+	require.Contains(t, out, "key: value", "non-secret value")
+	require.True(t, l[1] == redacted[1], "non-secret resources should not be copied")
+
+	buf.Reset()
+	require.NoError(t, l.WriteYaml(&buf))
+	require.Equal(t, 2, strings.Count(buf.String(), "cDRzc3cwcmQ="), "original resource should not be modified")
+}
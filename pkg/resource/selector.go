@@ -0,0 +1,147 @@
+package resource
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Selector matches resources by kind, namespace, name and labels.
+// A resource matches if it meets all of the specified criteria.
+type Selector struct {
+	// Kind matches the resource's kind or (canonical) qualified kind case-insensitively
+	Kind      string
+	Namespace string
+	// Name is a glob pattern the resource's name must match
+	Name   string
+	Labels map[string]string
+}
+
+// ParseSelector parses a comma-separated list of key=value pairs
+// as e.g. "kind=Deployment,name=api-*,app.kubernetes.io/component=api".
+// The keys kind, namespace and name refer to the corresponding resource
+// attributes, any other key is matched against the resource's labels.
+func ParseSelector(s string) (sel *Selector, err error) {
+	sel = &Selector{Labels: map[string]string{}}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, errors.Errorf("invalid selector %q: expected comma-separated key=value pairs", s)
+		}
+		switch kv[0] {
+		case "kind":
+			sel.Kind = kv[1]
+		case "namespace":
+			sel.Namespace = kv[1]
+		case "name":
+			if _, err = path.Match(kv[1], ""); err != nil {
+				return nil, errors.Wrapf(err, "invalid selector %q: name", s)
+			}
+			sel.Name = kv[1]
+		default:
+			sel.Labels[kv[0]] = kv[1]
+		}
+	}
+	return
+}
+
+// Match returns true if the resource meets all of the selector's criteria.
+// Label criteria never match references that don't provide labels.
+func (s *Selector) Match(ref K8sResourceRef) bool {
+	if s.Kind != "" && !strings.EqualFold(s.Kind, ref.Kind()) && !strings.EqualFold(s.Kind, ref.QualifiedKind()) && CanonicalGroupKind(s.Kind) != ref.GroupKind() {
+		return false
+	}
+	if s.Namespace != "" && s.Namespace != ref.Namespace() {
+		return false
+	}
+	if s.Name != "" {
+		if ok, _ := path.Match(s.Name, ref.Name()); !ok {
+			return false
+		}
+	}
+	if len(s.Labels) > 0 {
+		labeled, ok := ref.(interface{ Labels() map[string]string })
+		if !ok {
+			return false
+		}
+		labels := labeled.Labels()
+		for k, v := range s.Labels {
+			if labels[k] != v {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Selection selects the resources that match any of the Only selectors
+// (or all resources if none is specified) but none of the Exclude selectors.
+type Selection struct {
+	Only    []*Selector
+	Exclude []*Selector
+}
+
+// ParseSelection parses the selectors of a selection
+func ParseSelection(only, exclude []string) (s *Selection, err error) {
+	s = &Selection{}
+	if s.Only, err = parseSelectors(only); err != nil {
+		return nil, err
+	}
+	if s.Exclude, err = parseSelectors(exclude); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func parseSelectors(l []string) (selectors []*Selector, err error) {
+	for _, str := range l {
+		sel, err := ParseSelector(str)
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, sel)
+	}
+	return
+}
+
+// IsEmpty returns true if the selection selects all resources
+func (s *Selection) IsEmpty() bool {
+	return s == nil || len(s.Only) == 0 && len(s.Exclude) == 0
+}
+
+// HasLabels returns true if any of the selectors specifies label criteria.
+// These require resources that provide labels since they never match plain references.
+func (s *Selection) HasLabels() bool {
+	if s == nil {
+		return false
+	}
+	for _, sel := range append(append([]*Selector{}, s.Only...), s.Exclude...) {
+		if len(sel.Labels) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns true if the resource is selected.
+// A nil selection selects all resources.
+func (s *Selection) Match(ref K8sResourceRef) bool {
+	if s.IsEmpty() {
+		return true
+	}
+	for _, sel := range s.Exclude {
+		if sel.Match(ref) {
+			return false
+		}
+	}
+	if len(s.Only) == 0 {
+		return true
+	}
+	for _, sel := range s.Only {
+		if sel.Match(ref) {
+			return true
+		}
+	}
+	return false
+}
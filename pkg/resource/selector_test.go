@@ -0,0 +1,58 @@
+package resource
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelection(t *testing.T) {
+	l, err := FromReader(bytes.NewReader([]byte(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api-server
+  namespace: myns
+  labels:
+    app: api
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker
+  namespace: myns
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: api-config
+  namespace: otherns
+  labels:
+    app: api
+`)))
+	require.NoError(t, err)
+	for _, c := range []struct {
+		only     []string
+		exclude  []string
+		expected []string
+	}{
+		{nil, nil, []string{"deployment.apps/api-server", "deployment.apps/worker", "configmap/api-config"}},
+		{[]string{"kind=Deployment"}, nil, []string{"deployment.apps/api-server", "deployment.apps/worker"}},
+		{[]string{"kind=deployment.apps,name=api-*"}, nil, []string{"deployment.apps/api-server"}},
+		{[]string{"kind=ConfigMap", "name=worker"}, nil, []string{"deployment.apps/worker", "configmap/api-config"}},
+		{[]string{"app=api"}, nil, []string{"deployment.apps/api-server", "configmap/api-config"}},
+		{nil, []string{"namespace=myns"}, []string{"configmap/api-config"}},
+		{[]string{"name=api-*"}, []string{"kind=ConfigMap"}, []string{"deployment.apps/api-server"}},
+	} {
+		sel, err := ParseSelection(c.only, c.exclude)
+		require.NoError(t, err)
+		require.Equal(t, c.expected, l.Filter(sel.Match).Refs().Names(), "only: %v, exclude: %v", c.only, c.exclude)
+	}
+	for _, invalid := range []string{"", "kind", "kind=", "=Deployment", "name=["} {
+		_, err := ParseSelection([]string{invalid}, nil)
+		require.Error(t, err, invalid)
+	}
+	require.True(t, (*Selection)(nil).Match(l[0]), "nil selection should match")
+	require.False(t, (&Selector{Labels: map[string]string{"app": "api"}}).Match(ResourceRef("v1", "ConfigMap", "otherns", "api-config")), "label selector should not match ref without labels")
+}
@@ -0,0 +1,249 @@
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// PreserveFormatting makes the resource be written with the key order and
+// comments of the YAML document the provided resource has been read from.
+// This allows to keep the formatting of a resource that has been derived from another one.
+func (o *K8sResource) PreserveFormatting(from *K8sResource) {
+	o.node = from.node
+}
+
+// yamlDocuments parses the YAML documents of a manifest into node trees in
+// order to preserve their key order and comments when they are written again.
+// Empty documents are skipped like they are skipped when decoding the objects.
+// Returns nil if the manifest is not a YAML stream that can be parsed.
+func yamlDocuments(manifest []byte) (docs []*yaml.Node) {
+	if trimmed := bytes.TrimSpace(manifest); len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return nil // JSON
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(manifest))
+	for {
+		doc := &yaml.Node{}
+		err := dec.Decode(doc)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			return nil
+		}
+		if len(doc.Content) == 1 && doc.Content[0].Kind == yaml.MappingNode && len(doc.Content[0].Content) > 0 {
+			docs = append(docs, doc)
+		}
+	}
+}
+
+// documentContent returns the root node of a document node or nil
+func documentContent(doc *yaml.Node) *yaml.Node {
+	if doc == nil || len(doc.Content) != 1 {
+		return nil
+	}
+	return doc.Content[0]
+}
+
+// mappingValue returns the value node of the key within a mapping node or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// encodeYamlNode writes the document node after it has been synchronized
+// with the object's current content
+func encodeYamlNode(writer io.Writer, doc *yaml.Node, obj map[string]interface{}) (err error) {
+	content, err := syncYamlNode(doc.Content[0], obj)
+	if err != nil {
+		return
+	}
+	synced := *doc
+	synced.Content = []*yaml.Node{content}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err = enc.Encode(&synced); err != nil {
+		return
+	}
+	if err = enc.Close(); err != nil {
+		return
+	}
+	out := buf.Bytes()
+	if compact, _ := compactSequences(doc); compact {
+		// the encoder always indents sequences within their parent mapping
+		if out, err = unindentSequences(out); err != nil {
+			return
+		}
+	}
+	_, err = writer.Write(out)
+	return
+}
+
+// compactSequences returns true if the first block sequence within a mapping
+// is not indented relative to its key (as kubectl and kustomize write them).
+// found is false if the node contains no such sequence.
+func compactSequences(node *yaml.Node) (compact, found bool) {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if k, v := node.Content[i], node.Content[i+1]; isBlockSequence(v) {
+				return v.Column == k.Column, true
+			}
+		}
+	}
+	for _, child := range node.Content {
+		if compact, found = compactSequences(child); found {
+			return
+		}
+	}
+	return
+}
+
+func isBlockSequence(node *yaml.Node) bool {
+	return node.Kind == yaml.SequenceNode && node.Style&yaml.FlowStyle == 0 && len(node.Content) > 0
+}
+
+// unindentSequences moves the block sequences within the YAML document to the
+// column of their key. The lines are located using the positions of the parsed
+// document so that the content of block scalars is never mistaken for a sequence.
+func unindentSequences(doc []byte) ([]byte, error) {
+	node := &yaml.Node{}
+	if err := yaml.Unmarshal(doc, node); err != nil {
+		return nil, errors.Wrap(err, "unindent yaml sequences")
+	}
+	lines := bytes.Split(doc, []byte("\n"))
+	shift := make([]int, len(lines))
+	markIndentedSequences(node, lines, shift)
+	for i, line := range lines {
+		if n := len(line) - len(bytes.TrimLeft(line, " ")); n > 0 {
+			if n > shift[i] {
+				n = shift[i]
+			}
+			lines[i] = line[n:]
+		}
+	}
+	return bytes.Join(lines, []byte("\n")), nil
+}
+
+// markIndentedSequences adds the indentation of each sequence relative to its key
+// to the shift of the lines that follow the key and are indented further
+func markIndentedSequences(node *yaml.Node, lines [][]byte, shift []int) {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k, v := node.Content[i], node.Content[i+1]
+			if !isBlockSequence(v) || v.Column <= k.Column {
+				continue
+			}
+			// the key's line index equals k.Line-1, its content starts with the next line
+			for l := k.Line; l < len(lines); l++ {
+				if trimmed := bytes.TrimLeft(lines[l], " "); len(trimmed) > 0 && len(lines[l])-len(trimmed) < k.Column {
+					break
+				}
+				shift[l] += v.Column - k.Column
+			}
+		}
+	}
+	for _, child := range node.Content {
+		markIndentedSequences(child, lines, shift)
+	}
+}
+
+// syncYamlNode returns a copy of the node that represents the provided value.
+// The order of existing keys, comments and the style of unchanged scalars are kept,
+// new keys are appended in alphabetical order. The original node is not modified.
+func syncYamlNode(node *yaml.Node, value interface{}) (*yaml.Node, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if node.Kind != yaml.MappingNode {
+			break
+		}
+		synced := *node
+		synced.Content = make([]*yaml.Node, 0, len(node.Content))
+		existing := map[string]bool{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if item, ok := v[key]; ok && !existing[key] {
+				existing[key] = true
+				itemNode, err := syncYamlNode(node.Content[i+1], item)
+				if err != nil {
+					return nil, err
+				}
+				synced.Content = append(synced.Content, node.Content[i], itemNode)
+			}
+		}
+		added := make([]string, 0, len(v))
+		for key := range v {
+			if !existing[key] {
+				added = append(added, key)
+			}
+		}
+		sort.Strings(added)
+		for _, key := range added {
+			keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+			itemNode, err := newYamlNode(v[key])
+			if err != nil {
+				return nil, err
+			}
+			synced.Content = append(synced.Content, keyNode, itemNode)
+		}
+		return &synced, nil
+	case []interface{}:
+		if node.Kind != yaml.SequenceNode {
+			break
+		}
+		synced := *node
+		synced.Content = make([]*yaml.Node, len(v))
+		var err error
+		for i, item := range v {
+			if i < len(node.Content) {
+				synced.Content[i], err = syncYamlNode(node.Content[i], item)
+			} else {
+				synced.Content[i], err = newYamlNode(item)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return &synced, nil
+	}
+	if equalYamlValue(node, value) {
+		return node, nil
+	}
+	n, err := newYamlNode(value)
+	if err != nil {
+		return nil, err
+	}
+	n.HeadComment, n.LineComment, n.FootComment = node.HeadComment, node.LineComment, node.FootComment
+	return n, nil
+}
+
+// equalYamlValue returns true if the node represents the value
+func equalYamlValue(node *yaml.Node, value interface{}) bool {
+	var decoded interface{}
+	if err := node.Decode(&decoded); err != nil {
+		return false
+	}
+	a, err := json.Marshal(decoded)
+	if err != nil {
+		return false
+	}
+	b, err := json.Marshal(value)
+	return err == nil && bytes.Equal(a, b)
+}
+
+func newYamlNode(value interface{}) (n *yaml.Node, err error) {
+	n = &yaml.Node{}
+	err = n.Encode(value)
+	return
+}
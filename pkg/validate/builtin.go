@@ -0,0 +1,132 @@
+package validate
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// BuiltinVersion is the Kubernetes version the builtin schemas correspond to.
+// Fields that have been added to the API in later versions are unknown to them.
+const BuiltinVersion = "v1.17"
+
+var (
+	builtin     *Schemas
+	builtinOnce sync.Once
+	// types whose JSON representation differs from their Go structure
+	stringTypes = map[reflect.Type]bool{
+		reflect.TypeOf(metav1.Time{}):      true,
+		reflect.TypeOf(metav1.MicroTime{}): true,
+		reflect.TypeOf(metav1.Duration{}):  true,
+	}
+	intOrStringTypes = map[reflect.Type]bool{
+		reflect.TypeOf(intstr.IntOrString{}): true,
+		reflect.TypeOf(resource.Quantity{}):  true,
+	}
+	unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// CheckBuiltinVersion returns an error unless the builtin schemas correspond
+// to the provided Kubernetes version, e.g. "v1.17" or "1.17.3".
+// The builtin schemas of other versions are not bundled.
+func CheckBuiltinVersion(version string) error {
+	v := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(v) < 2 || "v"+v[0]+"."+v[1] != BuiltinVersion {
+		return errors.Errorf("unsupported Kubernetes version %q: the builtin schemas correspond to Kubernetes %s only", version, BuiltinVersion)
+	}
+	return nil
+}
+
+// Builtin returns the schemas of the Kubernetes API kinds k8spkg has been built with.
+// They are derived from the API's Go types (see BuiltinVersion), include
+// deprecated API versions and do not declare required fields.
+func Builtin() *Schemas {
+	builtinOnce.Do(func() {
+		builtin = newSchemas()
+		types := map[reflect.Type]*Schema{}
+		for gvk, t := range scheme.Scheme.AllKnownTypes() {
+			if gvk.Version == runtime.APIVersionInternal || strings.HasSuffix(gvk.Kind, "List") || strings.HasSuffix(gvk.Kind, "Options") {
+				continue
+			}
+			builtin.kinds[gvk] = typeSchema(t, types)
+		}
+	})
+	return builtin
+}
+
+// typeSchema derives the schema from a Go type using its JSON tags
+func typeSchema(t reflect.Type, types map[reflect.Type]*Schema) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if s := types[t]; s != nil {
+		return s
+	}
+	switch {
+	case stringTypes[t]:
+		return &Schema{Type: TypeString}
+	case intOrStringTypes[t]:
+		return &Schema{IntOrString: true}
+	case reflect.PtrTo(t).Implements(unmarshalerType) || t == reflect.TypeOf(runtime.RawExtension{}):
+		// custom JSON representation
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		s := &Schema{Type: TypeObject, Properties: map[string]*Schema{}}
+		types[t] = s
+		addFields(s, t, types)
+		return s
+	case reflect.Map:
+		return &Schema{Type: TypeObject, AdditionalProperties: typeSchema(t.Elem(), types)}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// base64 encoded
+			return &Schema{Type: TypeString}
+		}
+		return &Schema{Type: TypeArray, Items: typeSchema(t.Elem(), types)}
+	case reflect.String:
+		return &Schema{Type: TypeString}
+	case reflect.Bool:
+		return &Schema{Type: TypeBoolean}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: TypeInteger}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: TypeNumber}
+	}
+	return &Schema{}
+}
+
+func addFields(s *Schema, t reflect.Type, types map[reflect.Type]*Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		name := strings.Split(tag, ",")[0]
+		if name == "-" || f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		if f.Anonymous && name == "" || strings.Contains(tag, ",inline") {
+			ft := f.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addFields(s, ft, types)
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = typeSchema(f.Type, types)
+	}
+}
@@ -0,0 +1,183 @@
+package validate
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// openAPISchema is the JSON representation of an OpenAPI v2 or v3 schema
+type openAPISchema struct {
+	Ref                   string                    `json:"$ref"`
+	Type                  string                    `json:"type"`
+	Format                string                    `json:"format"`
+	Properties            map[string]*openAPISchema `json:"properties"`
+	AdditionalProperties  json.RawMessage           `json:"additionalProperties"`
+	Items                 *openAPISchema            `json:"items"`
+	Required              []string                  `json:"required"`
+	AllOf                 []*openAPISchema          `json:"allOf"`
+	IntOrString           bool                      `json:"x-kubernetes-int-or-string"`
+	PreserveUnknownFields bool                      `json:"x-kubernetes-preserve-unknown-fields"`
+	EmbeddedResource      bool                      `json:"x-kubernetes-embedded-resource"`
+	GroupVersionKinds     []schema.GroupVersionKind `json:"x-kubernetes-group-version-kind"`
+}
+
+// schemaConverter converts OpenAPI schemas resolving references to the definitions
+type schemaConverter struct {
+	definitions map[string]*openAPISchema
+	converted   map[string]*Schema
+}
+
+// FromOpenAPI reads the schemas from an OpenAPI v2 document as served by
+// the API server at /openapi/v2 or published with the Kubernetes sources.
+func FromOpenAPI(reader io.Reader) (s *Schemas, err error) {
+	b, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "read openapi schema")
+	}
+	doc := struct {
+		Definitions map[string]*openAPISchema `json:"definitions"`
+	}{}
+	if err = json.Unmarshal(b, &doc); err != nil {
+		return nil, errors.Wrap(err, "decode openapi schema")
+	}
+	if len(doc.Definitions) == 0 {
+		return nil, errors.New("decode openapi schema: no definitions found")
+	}
+	c := &schemaConverter{doc.Definitions, map[string]*Schema{}}
+	s = newSchemas()
+	for name, def := range doc.Definitions {
+		for _, gvk := range def.GroupVersionKinds {
+			s.kinds[gvk] = c.ref(name)
+		}
+	}
+	return
+}
+
+func (c *schemaConverter) ref(ref string) *Schema {
+	name := strings.TrimPrefix(ref, "#/definitions/")
+	if s := c.converted[name]; s != nil {
+		return s
+	}
+	def := c.definitions[name]
+	if def == nil {
+		return &Schema{}
+	}
+	s := &Schema{}
+	c.converted[name] = s
+	*s = *c.convert(def)
+	return s
+}
+
+func (c *schemaConverter) convert(o *openAPISchema) *Schema {
+	if o == nil {
+		return nil
+	}
+	if o.Ref != "" {
+		return c.ref(o.Ref)
+	}
+	if len(o.AllOf) == 1 && o.Type == "" && len(o.Properties) == 0 {
+		return c.convert(o.AllOf[0])
+	}
+	s := &Schema{
+		Type:                  o.Type,
+		IntOrString:           o.IntOrString || o.Format == "int-or-string",
+		PreserveUnknownFields: o.PreserveUnknownFields,
+		Items:                 c.convert(o.Items),
+		Required:              o.Required,
+	}
+	if len(o.Properties) > 0 {
+		s.Properties = map[string]*Schema{}
+		for k, p := range o.Properties {
+			s.Properties[k] = c.convert(p)
+		}
+	}
+	if len(o.AdditionalProperties) > 0 {
+		var additional openAPISchema
+		if err := json.Unmarshal(o.AdditionalProperties, &additional); err == nil {
+			s.AdditionalProperties = c.convert(&additional)
+		} else {
+			// additionalProperties: true
+			s.PreserveUnknownFields = true
+		}
+	}
+	if o.EmbeddedResource {
+		s = withObjectMeta(s)
+	}
+	return s
+}
+
+// WithCRDs returns a copy of the schemas extended with the schemas
+// the CustomResourceDefinitions within the provided list define.
+func (s *Schemas) WithCRDs(resources resource.K8sResourceList) (*Schemas, error) {
+	r := newSchemas()
+	for gvk, sc := range s.kinds {
+		r.kinds[gvk] = sc
+	}
+	for _, res := range resources {
+		if res.Kind() != "CustomResourceDefinition" || !strings.HasPrefix(res.APIVersion(), "apiextensions.k8s.io/") {
+			continue
+		}
+		if err := r.addCRD(res); err != nil {
+			return nil, errors.Wrapf(err, "crd %s", res.Name())
+		}
+	}
+	return r, nil
+}
+
+func (s *Schemas) addCRD(crd *resource.K8sResource) (err error) {
+	obj := crd.Raw()
+	group, _, _ := unstructured.NestedString(obj, "spec", "group")
+	kind, _, _ := unstructured.NestedString(obj, "spec", "names", "kind")
+	if group == "" || kind == "" {
+		// incomplete CRD - left to the API server to reject
+		return
+	}
+	c := &schemaConverter{}
+	// apiextensions.k8s.io/v1beta1 schema that applies to all versions
+	var common *Schema
+	if raw, ok, _ := unstructured.NestedMap(obj, "spec", "validation", "openAPIV3Schema"); ok {
+		if common, err = c.convertMap(raw); err != nil {
+			return
+		}
+	}
+	versions, _, _ := unstructured.NestedSlice(obj, "spec", "versions")
+	if v, _, _ := unstructured.NestedString(obj, "spec", "version"); v != "" {
+		versions = append(versions, map[string]interface{}{"name": v})
+	}
+	for _, entry := range versions {
+		v, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := v["name"].(string)
+		sc := common
+		if raw, ok, _ := unstructured.NestedMap(v, "schema", "openAPIV3Schema"); ok {
+			if sc, err = c.convertMap(raw); err != nil {
+				return
+			}
+		}
+		if name != "" && sc != nil {
+			s.kinds[schema.GroupVersionKind{Group: group, Version: name, Kind: kind}] = sc
+		}
+	}
+	return
+}
+
+func (c *schemaConverter) convertMap(raw map[string]interface{}) (*Schema, error) {
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var o openAPISchema
+	if err = json.Unmarshal(b, &o); err != nil {
+		return nil, errors.Wrap(err, "decode openAPIV3Schema")
+	}
+	return c.convert(&o), nil
+}
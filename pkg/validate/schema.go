@@ -0,0 +1,267 @@
+package validate
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Schema types
+const (
+	TypeAny     = ""
+	TypeObject  = "object"
+	TypeArray   = "array"
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+)
+
+// Schema is the subset of an OpenAPI schema that is needed to validate API objects
+type Schema struct {
+	Type string
+	// IntOrString accepts both integers and strings (int-or-string format)
+	IntOrString bool
+	Properties  map[string]*Schema
+	// AdditionalProperties is the schema of the values of a map
+	AdditionalProperties *Schema
+	// PreserveUnknownFields accepts properties that are not declared
+	PreserveUnknownFields bool
+	Items                 *Schema
+	Required              []string
+}
+
+// FieldError describes an invalid field of a resource
+type FieldError struct {
+	Resource string
+	Field    string
+	Message  string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Resource, e.Field, e.Message)
+}
+
+// Error lists the field errors of all invalid resources
+type Error struct {
+	Errors []*FieldError
+}
+
+func (e *Error) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Error()
+	}
+	return fmt.Sprintf("%d invalid fields:\n  %s", len(e.Errors), strings.Join(msgs, "\n  "))
+}
+
+// Schemas maps the API object kinds to their schema
+type Schemas struct {
+	kinds map[schema.GroupVersionKind]*Schema
+}
+
+func newSchemas() *Schemas {
+	return &Schemas{map[schema.GroupVersionKind]*Schema{}}
+}
+
+// Get returns the schema of the kind or nil if unknown
+func (s *Schemas) Get(gvk schema.GroupVersionKind) *Schema {
+	return s.kinds[gvk]
+}
+
+// Len returns the number of kinds the schemas are known for
+func (s *Schemas) Len() int {
+	return len(s.kinds)
+}
+
+// Validate validates the resources against the schemas.
+// The schemas of CustomResourceDefinitions within the list are taken into
+// account. Resources of unknown kinds are not validated.
+// An *Error is returned if any resource is invalid.
+func (s *Schemas) Validate(resources resource.K8sResourceList) error {
+	withCRDs, err := s.WithCRDs(resources)
+	if err != nil {
+		return err
+	}
+	var errs []*FieldError
+	for _, res := range resources {
+		errs = append(errs, withCRDs.ValidateResource(res)...)
+	}
+	if len(errs) > 0 {
+		return &Error{errs}
+	}
+	return nil
+}
+
+// ValidateResource returns the invalid fields of the resource
+func (s *Schemas) ValidateResource(res *resource.K8sResource) (errs []*FieldError) {
+	gvk := schema.FromAPIVersionAndKind(res.APIVersion(), res.Kind())
+	sc := s.kinds[gvk]
+	if sc == nil {
+		return
+	}
+	id := res.QualifiedKind() + "/" + res.Name()
+	if res.Namespace() != "" {
+		id += " -n " + res.Namespace()
+	}
+	v := &validator{resource: id}
+	obj := res.Raw()
+	if sc.Properties != nil {
+		// custom resource schemas usually don't declare the common fields
+		sc = withObjectMeta(sc)
+	}
+	v.validate(obj, sc, "")
+	return v.errs
+}
+
+func withObjectMeta(s *Schema) *Schema {
+	missing := false
+	for _, name := range []string{"apiVersion", "kind", "metadata"} {
+		if s.Properties[name] == nil {
+			missing = true
+		}
+	}
+	if !missing {
+		return s
+	}
+	c := *s
+	c.Properties = map[string]*Schema{}
+	for k, v := range s.Properties {
+		c.Properties[k] = v
+	}
+	for _, name := range []string{"apiVersion", "kind"} {
+		if c.Properties[name] == nil {
+			c.Properties[name] = &Schema{Type: TypeString}
+		}
+	}
+	if c.Properties["metadata"] == nil {
+		c.Properties["metadata"] = &Schema{Type: TypeObject, PreserveUnknownFields: true}
+	}
+	return &c
+}
+
+type validator struct {
+	resource string
+	errs     []*FieldError
+}
+
+func (v *validator) fail(path, msg string, args ...interface{}) {
+	if path == "" {
+		path = "."
+	}
+	v.errs = append(v.errs, &FieldError{v.resource, path, fmt.Sprintf(msg, args...)})
+}
+
+func (v *validator) validate(value interface{}, s *Schema, path string) {
+	if s == nil || value == nil {
+		return
+	}
+	if s.IntOrString {
+		switch value.(type) {
+		case string, int, int32, int64, float64:
+		default:
+			v.fail(path, "expected integer or string but was %s", typeName(value))
+		}
+		return
+	}
+	switch s.Type {
+	case TypeObject:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			v.fail(path, "expected object but was %s", typeName(value))
+			return
+		}
+		v.validateObject(m, s, path)
+	case TypeArray:
+		l, ok := value.([]interface{})
+		if !ok {
+			v.fail(path, "expected array but was %s", typeName(value))
+			return
+		}
+		for i, item := range l {
+			v.validate(item, s.Items, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case TypeString:
+		if _, ok := value.(string); !ok {
+			v.fail(path, "expected string but was %s", typeName(value))
+		}
+	case TypeInteger:
+		if !isInteger(value) {
+			v.fail(path, "expected integer but was %s", typeName(value))
+		}
+	case TypeNumber:
+		switch value.(type) {
+		case int, int32, int64, float64:
+		default:
+			v.fail(path, "expected number but was %s", typeName(value))
+		}
+	case TypeBoolean:
+		if _, ok := value.(bool); !ok {
+			v.fail(path, "expected boolean but was %s", typeName(value))
+		}
+	default:
+		if m, ok := value.(map[string]interface{}); ok && s.Properties != nil {
+			v.validateObject(m, s, path)
+		}
+	}
+}
+
+func (v *validator) validateObject(m map[string]interface{}, s *Schema, path string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fieldPath := k
+		if path != "" {
+			fieldPath = path + "." + k
+		}
+		if p := s.Properties[k]; p != nil {
+			v.validate(m[k], p, fieldPath)
+		} else if s.AdditionalProperties != nil {
+			v.validate(m[k], s.AdditionalProperties, fieldPath)
+		} else if len(s.Properties) > 0 && !s.PreserveUnknownFields {
+			v.fail(fieldPath, "unknown field")
+		}
+	}
+	for _, k := range s.Required {
+		if _, ok := m[k]; !ok {
+			fieldPath := k
+			if path != "" {
+				fieldPath = path + "." + k
+			}
+			v.fail(fieldPath, "missing required field")
+		}
+	}
+}
+
+func isInteger(value interface{}) bool {
+	switch n := value.(type) {
+	case int, int32, int64:
+		return true
+	case float64:
+		return n == math.Trunc(n)
+	}
+	return false
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return TypeObject
+	case []interface{}:
+		return TypeArray
+	case string:
+		return TypeString
+	case bool:
+		return TypeBoolean
+	case int, int32, int64, float64:
+		return TypeNumber
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,149 @@
+package validate
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mgoltzsche/k8spkg/pkg/resource"
+	"github.com/stretchr/testify/require"
+)
+
+const invalidManifest = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: mydeployment
+  namespace: myns
+spec:
+  replicas: "2"
+  template:
+    spec:
+      contianers:
+      - name: mycontainer
+        image: alpine:3.10
+        resources:
+          limits:
+            cpu: 1
+            memory: 64Mi
+        ports:
+        - containerPort: 8080
+          protocol: TCP
+---
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: things.example.org
+spec:
+  group: example.org
+  version: v1
+  names:
+    kind: Thing
+    plural: things
+  validation:
+    openAPIV3Schema:
+      properties:
+        spec:
+          properties:
+            size:
+              type: integer
+            settings:
+              type: object
+              x-kubernetes-preserve-unknown-fields: true
+---
+apiVersion: example.org/v1
+kind: Thing
+metadata:
+  name: mything
+spec:
+  size: 3
+  colour: red
+  settings:
+    any: value
+`
+
+func readManifest(t *testing.T, manifest string) resource.K8sResourceList {
+	l, err := resource.FromReader(bytes.NewReader([]byte(manifest)))
+	require.NoError(t, err)
+	return l
+}
+
+func TestBuiltinValidate(t *testing.T) {
+	err := Builtin().Validate(readManifest(t, invalidManifest))
+	require.Error(t, err)
+	var msgs []string
+	for _, e := range err.(*Error).Errors {
+		msgs = append(msgs, e.Error())
+	}
+	expected := []string{
+		"deployment.apps/mydeployment -n myns: spec.replicas: expected integer but was string",
+		"deployment.apps/mydeployment -n myns: spec.template.spec.contianers: unknown field",
+		"thing.example.org/mything: spec.colour: unknown field",
+	}
+	require.Equal(t, expected, msgs)
+}
+
+func TestCheckBuiltinVersion(t *testing.T) {
+	for _, v := range []string{BuiltinVersion, "1.17", "v1.17.3"} {
+		require.NoError(t, CheckBuiltinVersion(v), v)
+	}
+	for _, v := range []string{"", "v1", "v1.16", "v1.18.0", "1.170"} {
+		require.Error(t, CheckBuiltinVersion(v), v)
+	}
+}
+
+func TestBuiltinValidateTestManifests(t *testing.T) {
+	err := filepath.Walk("../resource/test", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".yaml") || info.Name() == "kustomization.yaml" {
+			return err
+		}
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer f.Close()
+		l, err := resource.FromReader(f)
+		require.NoError(t, err, path)
+		require.NoError(t, Builtin().Validate(l), path)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestFromOpenAPI(t *testing.T) {
+	doc := `{"definitions": {
+		"io.k8s.api.core.v1.ConfigMap": {
+			"type": "object",
+			"properties": {
+				"apiVersion": {"type": "string"},
+				"kind": {"type": "string"},
+				"metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+				"data": {"type": "object", "additionalProperties": {"type": "string"}}
+			},
+			"x-kubernetes-group-version-kind": [{"group": "", "kind": "ConfigMap", "version": "v1"}]
+		},
+		"io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta": {
+			"type": "object",
+			"properties": {"name": {"type": "string"}, "labels": {"type": "object", "additionalProperties": {"type": "string"}}}
+		}
+	}}`
+	schemas, err := FromOpenAPI(strings.NewReader(doc))
+	require.NoError(t, err)
+	require.Equal(t, 1, schemas.Len(), "kinds")
+	err = schemas.Validate(readManifest(t, `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: mycm
+  lables: {}
+data:
+  key: value
+  nested: {}
+`))
+	require.Error(t, err)
+	var msgs []string
+	for _, e := range err.(*Error).Errors {
+		msgs = append(msgs, e.Field+": "+e.Message)
+	}
+	require.Equal(t, []string{"data.nested: expected string but was object", "metadata.lables: unknown field"}, msgs)
+}